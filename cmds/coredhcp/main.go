@@ -17,14 +17,25 @@ import (
 	"github.com/coredhcp/coredhcp/server"
 
 	"github.com/coredhcp/coredhcp/plugins"
+	pl_broadcast "github.com/coredhcp/coredhcp/plugins/broadcast"
+	pl_ddns "github.com/coredhcp/coredhcp/plugins/ddns"
 	pl_dns "github.com/coredhcp/coredhcp/plugins/dns"
+	pl_domainname "github.com/coredhcp/coredhcp/plugins/domainname"
 	pl_file "github.com/coredhcp/coredhcp/plugins/file"
+	pl_fqdn "github.com/coredhcp/coredhcp/plugins/fqdn"
 	pl_leasetime "github.com/coredhcp/coredhcp/plugins/leasetime"
+	pl_mtu "github.com/coredhcp/coredhcp/plugins/mtu"
 	pl_nbp "github.com/coredhcp/coredhcp/plugins/nbp"
+	pl_netbios "github.com/coredhcp/coredhcp/plugins/netbios"
 	pl_netmask "github.com/coredhcp/coredhcp/plugins/netmask"
+	pl_ntp "github.com/coredhcp/coredhcp/plugins/ntp"
+	pl_options "github.com/coredhcp/coredhcp/plugins/options"
+	pl_pdroute "github.com/coredhcp/coredhcp/plugins/pdroute"
 	pl_prefix "github.com/coredhcp/coredhcp/plugins/prefix"
 	pl_range "github.com/coredhcp/coredhcp/plugins/range"
+	pl_relayinfo "github.com/coredhcp/coredhcp/plugins/relayinfo"
 	pl_router "github.com/coredhcp/coredhcp/plugins/router"
+	pl_routes "github.com/coredhcp/coredhcp/plugins/routes"
 	pl_searchdomains "github.com/coredhcp/coredhcp/plugins/searchdomains"
 	pl_serverid "github.com/coredhcp/coredhcp/plugins/serverid"
 
@@ -58,14 +69,25 @@ func getLogLevels() []string {
 }
 
 var desiredPlugins = []*plugins.Plugin{
+	&pl_broadcast.Plugin,
+	&pl_ddns.Plugin,
 	&pl_dns.Plugin,
+	&pl_domainname.Plugin,
 	&pl_file.Plugin,
+	&pl_fqdn.Plugin,
 	&pl_leasetime.Plugin,
+	&pl_mtu.Plugin,
 	&pl_nbp.Plugin,
+	&pl_netbios.Plugin,
 	&pl_netmask.Plugin,
+	&pl_ntp.Plugin,
+	&pl_options.Plugin,
+	&pl_pdroute.Plugin,
 	&pl_prefix.Plugin,
 	&pl_range.Plugin,
+	&pl_relayinfo.Plugin,
 	&pl_router.Plugin,
+	&pl_routes.Plugin,
 	&pl_searchdomains.Plugin,
 	&pl_serverid.Plugin,
 }
@@ -0,0 +1,83 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build integration
+
+package e2e_test
+
+import (
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+
+	"github.com/coredhcp/coredhcp/plugins/pdroute"
+)
+
+// pdMessage builds a message from a client with the given delegated prefix
+func pdMessage(t *testing.T, typ dhcpv6.MessageType, prefix *net.IPNet) *dhcpv6.Message {
+	msg, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	msg.MessageType = typ
+	msg.AddOption(dhcpv6.OptClientID(dhcpv6.Duid{
+		Type:          dhcpv6.DUID_LL,
+		HwType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0x00, 0x01},
+	}))
+	iapd := &dhcpv6.OptIAPD{IaId: [4]byte{1}}
+	iapd.Options.Add(&dhcpv6.OptIAPrefix{
+		PreferredLifetime: 30 * time.Minute,
+		ValidLifetime:     time.Hour,
+		Prefix:            prefix,
+	})
+	msg.AddOption(iapd)
+	return msg
+}
+
+// TestPDRoute installs and removes the route to a delegated prefix, in a
+// network namespace of its own
+func TestPDRoute(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	backupNS, err := netns.Get()
+	require.NoError(t, err)
+	defer backupNS.Close()
+	// Creating the namespace switches to it
+	ns, err := netns.New()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, netns.Set(backupNS))
+		ns.Close()
+	}()
+
+	link := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "cdhcp_pd"}, PeerName: "cdhcp_pd_cli"}
+	require.NoError(t, netlink.LinkAdd(link))
+	require.NoError(t, netlink.LinkSetUp(link))
+
+	handler, err := pdroute.Plugin.Setup6("dev=cdhcp_pd")
+	require.NoError(t, err)
+
+	_, prefix, err := net.ParseCIDR("2001:db8:0:100::/56")
+	require.NoError(t, err)
+	routes := func() []netlink.Route {
+		list, err := netlink.RouteListFiltered(netlink.FAMILY_V6, &netlink.Route{Dst: prefix}, netlink.RT_FILTER_DST)
+		require.NoError(t, err)
+		return list
+	}
+
+	handler(pdMessage(t, dhcpv6.MessageTypeRequest, prefix), pdMessage(t, dhcpv6.MessageTypeReply, prefix))
+	list := routes()
+	require.Len(t, list, 1)
+	require.Equal(t, "fe80::dcad:beff:feef:1", list[0].Gw.String())
+	require.Equal(t, netlink.RouteProtocol(16), list[0].Protocol)
+
+	handler(pdMessage(t, dhcpv6.MessageTypeRelease, prefix), pdMessage(t, dhcpv6.MessageTypeReply, nil))
+	require.Empty(t, routes())
+}
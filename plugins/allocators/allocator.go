@@ -33,6 +33,84 @@ type Allocator interface {
 	Free(net.IPNet) error
 }
 
+// Excluder is implemented by allocators that can keep addresses or prefixes
+// out of the pool, such as reserved addresses, the server's own address or
+// gateways
+type Excluder interface {
+	// Exclude permanently removes the addresses of the given network from
+	// the pool: they are never allocated, nor returned by Free. Parts of the
+	// network outside of the pool are ignored
+	Exclude(net.IPNet) error
+}
+
+// Inspector is implemented by allocators which can report their state, for
+// instance for metrics. Excluded blocks are neither part of the capacity nor
+// allocated
+type Inspector interface {
+	// Capacity returns the number of blocks that can be allocated. It
+	// saturates at the largest uint64 for larger pools
+	Capacity() uint64
+	// Used returns the number of blocks currently allocated
+	Used() uint64
+	// Each calls f with each allocated block, in ascending order, until it
+	// returns false
+	Each(f func(net.IPNet) bool)
+	// IsAllocated returns whether the block containing an address is
+	// allocated
+	IsAllocated(net.IP) bool
+}
+
+// Reserver is implemented by allocators which can mark a given block as
+// allocated, for instance to restore leases persisted before a restart
+type Reserver interface {
+	// Reserve allocates the block containing an address, and returns it. It
+	// returns ErrAllocated if the block is already allocated or excluded,
+	// and doesn't fall back to another block
+	Reserve(net.IP) (net.IPNet, error)
+}
+
+// ErrAllocated is returned by Reserver.Reserve when the block is not free
+var ErrAllocated = errors.New("block already allocated")
+
+// Strategy decides which free block an allocator picks when it can't use the
+// hint
+type Strategy int
+
+const (
+	// Sequential picks the first free block, following the hint if there is
+	// one
+	Sequential Strategy = iota
+	// Random picks a free block at random, so that allocations are not
+	// predictable and don't reveal how many clients there are
+	Random
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case Sequential:
+		return "sequential"
+	case Random:
+		return "random"
+	}
+	return fmt.Sprintf("Strategy(%d)", int(s))
+}
+
+// ParseStrategy parses the name of a strategy
+func ParseStrategy(name string) (Strategy, error) {
+	for _, s := range []Strategy{Sequential, Random} {
+		if name == s.String() {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown allocation strategy %s, want sequential or random", name)
+}
+
+// StrategySetter is implemented by allocators supporting several strategies.
+// Allocators use the Sequential strategy until told otherwise
+type StrategySetter interface {
+	SetStrategy(Strategy) error
+}
+
 // ErrDoubleFree is an error type returned by Allocator.Free() when a
 // non-allocated block is passed
 type ErrDoubleFree struct {
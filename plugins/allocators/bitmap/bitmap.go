@@ -13,6 +13,7 @@ package bitmap
 import (
 	"errors"
 	"fmt"
+	"math/rand"
 	"net"
 	"strconv"
 	"sync"
@@ -32,7 +33,11 @@ type Allocator struct {
 	containing net.IPNet
 	page       int
 	bitmap     *bitset.BitSet
-	l          sync.Mutex
+	// excluded prefixes are also set in bitmap, so they're never allocated
+	excluded *bitset.BitSet
+	strategy allocators.Strategy
+	rng      *rand.Rand
+	l        sync.Mutex
 }
 
 // prefix must verify: containing.Mask.Size < prefix.Mask.Size < page
@@ -73,7 +78,15 @@ func (a *Allocator) Allocate(hint net.IPNet) (ret net.IPNet, err error) {
 	}
 
 	// Find a free prefix
-	next, ok := a.bitmap.NextClear(0)
+	var (
+		next uint
+		ok   bool
+	)
+	if a.strategy == allocators.Random {
+		next, ok = randomClear(a.bitmap, a.rng)
+	} else {
+		next, ok = a.bitmap.NextClear(0)
+	}
 	if !ok {
 		err = allocators.ErrNoAddrAvail
 		return
@@ -98,13 +111,142 @@ func (a *Allocator) Free(prefix net.IPNet) error {
 	a.l.Lock()
 	defer a.l.Unlock()
 
-	if !a.bitmap.Test(idx) {
+	if !a.bitmap.Test(idx) || a.excluded.Test(idx) {
 		return &allocators.ErrDoubleFree{Loc: prefix}
 	}
 	a.bitmap.Clear(idx)
 	return nil
 }
 
+// SetStrategy sets how the allocator picks prefixes
+func (a *Allocator) SetStrategy(s allocators.Strategy) error {
+	if s != allocators.Sequential && s != allocators.Random {
+		return fmt.Errorf("Unsupported allocation strategy %s", s)
+	}
+	a.l.Lock()
+	defer a.l.Unlock()
+	a.strategy = s
+	return nil
+}
+
+// Capacity returns the number of blocks in the pool, excluded ones aside
+func (a *Allocator) Capacity() uint64 {
+	a.l.Lock()
+	defer a.l.Unlock()
+	return uint64(a.bitmap.Len() - a.excluded.Count())
+}
+
+// Used returns the number of allocated blocks
+func (a *Allocator) Used() uint64 {
+	a.l.Lock()
+	defer a.l.Unlock()
+	return uint64(a.bitmap.Count() - a.excluded.Count())
+}
+
+// Each calls f with each allocated block, in ascending order, until it
+// returns false. f must not call the allocator
+func (a *Allocator) Each(f func(net.IPNet) bool) {
+	a.l.Lock()
+	defer a.l.Unlock()
+	for i, ok := a.bitmap.NextSet(0); ok; i, ok = a.bitmap.NextSet(i + 1) {
+		if a.excluded.Test(i) {
+			continue
+		}
+		ip, err := a.toPrefix(i)
+		if err != nil {
+			log.Errorf("BUG: could not get prefix from allocation: %v", err)
+			continue
+		}
+		if !f(net.IPNet{IP: ip, Mask: net.CIDRMask(a.page, 128)}) {
+			return
+		}
+	}
+}
+
+// IsAllocated returns whether the block containing an address is allocated
+func (a *Allocator) IsAllocated(ip net.IP) bool {
+	if !a.containing.Contains(ip) {
+		return false
+	}
+	idx, err := a.toIndex(ip)
+	if err != nil {
+		return false
+	}
+	a.l.Lock()
+	defer a.l.Unlock()
+	return a.bitmap.Test(idx) && !a.excluded.Test(idx)
+}
+
+// Reserve allocates the block containing the given address
+func (a *Allocator) Reserve(ip net.IP) (ret net.IPNet, err error) {
+	if !a.containing.Contains(ip) {
+		return ret, fmt.Errorf("%s is not in the pool %s", ip, a.containing.String())
+	}
+	idx, err := a.toIndex(ip)
+	if err != nil {
+		return ret, err
+	}
+	a.l.Lock()
+	defer a.l.Unlock()
+	if a.bitmap.Test(idx) {
+		return ret, allocators.ErrAllocated
+	}
+	ret.IP, err = a.toPrefix(idx)
+	if err != nil {
+		return ret, err
+	}
+	ret.Mask = net.CIDRMask(a.page, 128)
+	a.bitmap.Set(idx)
+	return ret, nil
+}
+
+// Contains returns whether an address is in the pool of the allocator
+func (a *Allocator) Contains(ip net.IP) bool {
+	return a.containing.Contains(ip)
+}
+
+// First returns the lowest address in the pool of the allocator
+func (a *Allocator) First() net.IP {
+	return a.containing.IP
+}
+
+// Exclude removes the blocks overlapping the given prefix from the pool
+func (a *Allocator) Exclude(prefix net.IPNet) error {
+	ones, bits := prefix.Mask.Size()
+	if bits != 128 || prefix.IP.To16() == nil {
+		return fmt.Errorf("Invalid IPv6 prefix %s", prefix.String())
+	}
+	poolSize, _ := a.containing.Mask.Size()
+
+	var first, count uint
+	switch {
+	case ones <= poolSize:
+		if !prefix.Contains(a.containing.IP) {
+			return nil
+		}
+		first, count = 0, a.bitmap.Len()
+	case !a.containing.Contains(prefix.IP):
+		return nil
+	default:
+		idx, err := a.toIndex(prefix.IP.Mask(prefix.Mask))
+		if err != nil {
+			return err
+		}
+		first, count = idx, 1
+		if ones < a.page {
+			count = 1 << uint(a.page-ones)
+		}
+	}
+
+	a.l.Lock()
+	defer a.l.Unlock()
+	for idx := first; idx < first+count; idx++ {
+		a.bitmap.Set(idx)
+		a.excluded.Set(idx)
+	}
+	return nil
+}
+
 // NewBitmapAllocator creates a new allocator, allocating /`size` prefixes
 // carved out of the given `pool` prefix
 func NewBitmapAllocator(pool net.IPNet, size int) (*Allocator, error) {
@@ -128,7 +270,9 @@ func NewBitmapAllocator(pool net.IPNet, size int) (*Allocator, error) {
 		containing: pool,
 		page:       size,
 
-		bitmap: bitset.New(1 << uint(allocOrder)),
+		bitmap:   bitset.New(1 << uint(allocOrder)),
+		excluded: bitset.New(1 << uint(allocOrder)),
+		rng:      newRand(),
 	}
 
 	return &alloc, nil
@@ -11,6 +11,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math/rand"
 	"net"
 	"sync"
 
@@ -31,7 +32,11 @@ type IPv4Allocator struct {
 	// This bitset implementation isn't goroutine-safe, we protect it with a mutex for now
 	// until we can swap for another concurrent implementation
 	bitmap *bitset.BitSet
-	l      sync.Mutex
+	// excluded addresses are also set in bitmap, so they're never allocated
+	excluded *bitset.BitSet
+	strategy allocators.Strategy
+	rng      *rand.Rand
+	l        sync.Mutex
 }
 
 func (a *IPv4Allocator) toIP(offset uint32) net.IP {
@@ -62,18 +67,28 @@ func (a *IPv4Allocator) Allocate(hint net.IPNet) (n net.IPNet, err error) {
 	n.Mask = net.CIDRMask(32, 32)
 
 	// This is just a hint, ignore any error with it
-	hintOffset, _ := a.toOffset(hint.IP)
+	hintOffset, hintErr := a.toOffset(hint.IP)
 
 	a.l.Lock()
 	defer a.l.Unlock()
 
 	var next uint
 	// First try the exact match
-	if a.bitmap.Test(hintOffset) {
+	if hintErr == nil && !a.bitmap.Test(hintOffset) {
 		next = hintOffset
 	} else {
-		// Then any available address
-		avail, ok := a.bitmap.NextClear(0)
+		// Then any available address: with the sequential strategy the next
+		// one, wrapping around at the end of the range. Without a hint, this
+		// is the lowest available address
+		var (
+			avail uint
+			ok    bool
+		)
+		if a.strategy == allocators.Random {
+			avail, ok = randomClear(a.bitmap, a.rng)
+		} else {
+			avail, ok = nextClear(a.bitmap, hintOffset)
+		}
 		if !ok {
 			return n, allocators.ErrNoAddrAvail
 		}
@@ -95,13 +110,122 @@ func (a *IPv4Allocator) Free(n net.IPNet) error {
 	a.l.Lock()
 	defer a.l.Unlock()
 
-	if !a.bitmap.Test(uint(offset)) {
+	if !a.bitmap.Test(uint(offset)) || a.excluded.Test(offset) {
 		return &allocators.ErrDoubleFree{Loc: n}
 	}
 	a.bitmap.Clear(offset)
 	return nil
 }
 
+// SetStrategy sets how the allocator picks addresses
+func (a *IPv4Allocator) SetStrategy(s allocators.Strategy) error {
+	if s != allocators.Sequential && s != allocators.Random {
+		return fmt.Errorf("unsupported allocation strategy %s", s)
+	}
+	a.l.Lock()
+	defer a.l.Unlock()
+	a.strategy = s
+	return nil
+}
+
+// Capacity returns the number of addresses in the range, excluded ones aside
+func (a *IPv4Allocator) Capacity() uint64 {
+	a.l.Lock()
+	defer a.l.Unlock()
+	return uint64(a.end-a.start) + 1 - uint64(a.excluded.Count())
+}
+
+// Used returns the number of allocated addresses
+func (a *IPv4Allocator) Used() uint64 {
+	a.l.Lock()
+	defer a.l.Unlock()
+	return uint64(a.bitmap.Count() - a.excluded.Count())
+}
+
+// Each calls f with each allocated address, in ascending order, until it
+// returns false. f must not call the allocator
+func (a *IPv4Allocator) Each(f func(net.IPNet) bool) {
+	a.l.Lock()
+	defer a.l.Unlock()
+	for i, ok := a.bitmap.NextSet(0); ok && i <= uint(a.end-a.start); i, ok = a.bitmap.NextSet(i + 1) {
+		if a.excluded.Test(i) {
+			continue
+		}
+		if !f(net.IPNet{IP: a.toIP(uint32(i)), Mask: net.CIDRMask(32, 32)}) {
+			return
+		}
+	}
+}
+
+// IsAllocated returns whether an address is allocated
+func (a *IPv4Allocator) IsAllocated(ip net.IP) bool {
+	offset, err := a.toOffset(ip)
+	if err != nil {
+		return false
+	}
+	a.l.Lock()
+	defer a.l.Unlock()
+	return a.bitmap.Test(offset) && !a.excluded.Test(offset)
+}
+
+// Reserve allocates the given address
+func (a *IPv4Allocator) Reserve(ip net.IP) (net.IPNet, error) {
+	offset, err := a.toOffset(ip)
+	if err != nil {
+		return net.IPNet{}, err
+	}
+	a.l.Lock()
+	defer a.l.Unlock()
+	if a.bitmap.Test(offset) {
+		return net.IPNet{}, allocators.ErrAllocated
+	}
+	a.bitmap.Set(offset)
+	return net.IPNet{IP: a.toIP(uint32(offset)), Mask: net.CIDRMask(32, 32)}, nil
+}
+
+// Contains returns whether an address is in the range of the allocator
+func (a *IPv4Allocator) Contains(ip net.IP) bool {
+	_, err := a.toOffset(ip)
+	return err == nil
+}
+
+// First returns the lowest address in the range of the allocator
+func (a *IPv4Allocator) First() net.IP {
+	return a.toIP(0)
+}
+
+// Exclude removes the addresses of the given network from the pool
+func (a *IPv4Allocator) Exclude(n net.IPNet) error {
+	if n.IP.To4() == nil {
+		return errInvalidIP
+	}
+	ones, bits := n.Mask.Size()
+	if bits == 128 && ones >= 96 {
+		ones -= 96
+	} else if bits != 32 {
+		return fmt.Errorf("invalid IPv4 network %s", n.String())
+	}
+	first := binary.BigEndian.Uint32(n.IP.To4()) &^ (1<<(32-uint(ones)) - 1)
+	last := first | (1<<(32-uint(ones)) - 1)
+	if last < a.start || first > a.end {
+		return nil
+	}
+	if first < a.start {
+		first = a.start
+	}
+	if last > a.end {
+		last = a.end
+	}
+
+	a.l.Lock()
+	defer a.l.Unlock()
+	for ip := uint64(first); ip <= uint64(last); ip++ {
+		a.bitmap.Set(uint(uint32(ip) - a.start))
+		a.excluded.Set(uint(uint32(ip) - a.start))
+	}
+	return nil
+}
+
 // NewIPv4Allocator creates a new allocator suitable for giving out IPv4 addresses
 func NewIPv4Allocator(start, end net.IP) (*IPv4Allocator, error) {
 	if start.To4() == nil || end.To4() == nil {
@@ -117,6 +241,8 @@ func NewIPv4Allocator(start, end net.IP) (*IPv4Allocator, error) {
 		return nil, errors.New("no IPs in the given range to allocate")
 	}
 	alloc.bitmap = bitset.New(uint(alloc.end - alloc.start + 1))
+	alloc.excluded = bitset.New(uint(alloc.end - alloc.start + 1))
+	alloc.rng = newRand()
 
 	return &alloc, nil
 }
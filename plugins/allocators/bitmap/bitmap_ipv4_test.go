@@ -5,8 +5,11 @@
 package bitmap
 
 import (
+	"errors"
 	"net"
 	"testing"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators"
 )
 
 func getv4Allocator() *IPv4Allocator {
@@ -52,3 +55,134 @@ func Test4OutOfPool(t *testing.T) {
 		t.Fatalf("Prefixes have wrong size %d/%d", prefLen, totalLen)
 	}
 }
+
+func Test4Hint(t *testing.T) {
+	alloc := getv4Allocator()
+	hint := net.IPNet{IP: net.IPv4(192, 0, 2, 42), Mask: net.CIDRMask(32, 32)}
+
+	res, err := alloc.Allocate(hint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.IP.Equal(hint.IP) {
+		t.Fatalf("Hint was not honored, got %s", res.IP)
+	}
+	res, err = alloc.Allocate(hint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.IP.Equal(hint.IP) {
+		t.Fatal("Allocated the same address twice")
+	}
+}
+
+func Test4Exclude(t *testing.T) {
+	alloc, err := NewIPv4Allocator(net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 192.0.2.0/31 overlaps the start of the range
+	for _, excl := range []string{"192.0.2.0/31", "192.0.2.4/32", "198.51.100.0/24"} {
+		_, n, _ := net.ParseCIDR(excl)
+		if err := alloc.Exclude(*n); err != nil {
+			t.Fatalf("Could not exclude %s: %v", excl, err)
+		}
+	}
+
+	hint := net.IPNet{IP: net.IPv4(192, 0, 2, 4), Mask: net.CIDRMask(32, 32)}
+	var got []string
+	for i := 0; i < 4; i++ {
+		res, err := alloc.Allocate(hint)
+		if err != nil {
+			t.Fatalf("Error before exhaustion: %v", err)
+		}
+		got = append(got, res.IP.String())
+	}
+	// Addresses following the hint come first, then the search wraps around
+	want := []string{"192.0.2.5", "192.0.2.6", "192.0.2.2", "192.0.2.3"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Allocated %v, expected %v", got, want)
+		}
+	}
+	if _, err := alloc.Allocate(net.IPNet{}); err == nil {
+		t.Fatal("Allocated an excluded address")
+	}
+	if err := alloc.Free(hint); err == nil {
+		t.Fatal("Expected an error freeing an excluded address")
+	}
+}
+
+func Test4Random(t *testing.T) {
+	alloc := getv4Allocator()
+	if err := alloc.SetStrategy(allocators.Random); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every address is eventually allocated, once
+	seen := make(map[string]bool)
+	sequential := true
+	for i := 0; i < 256; i++ {
+		res, err := alloc.Allocate(net.IPNet{})
+		if err != nil {
+			t.Fatalf("Error before exhaustion: %v", err)
+		}
+		if seen[res.IP.String()] {
+			t.Fatalf("Allocated %s twice", res.IP)
+		}
+		seen[res.IP.String()] = true
+		if int(res.IP.To4()[3]) != i {
+			sequential = false
+		}
+	}
+	if sequential {
+		t.Fatal("Addresses were allocated sequentially")
+	}
+	if _, err := alloc.Allocate(net.IPNet{}); err == nil {
+		t.Fatal("Allocated more addresses than there are in the pool")
+	}
+}
+
+func Test4Inspect(t *testing.T) {
+	alloc := getv4Allocator()
+	_, excluded, _ := net.ParseCIDR("192.0.2.0/30")
+	if err := alloc.Exclude(*excluded); err != nil {
+		t.Fatal(err)
+	}
+
+	reserved, err := alloc.Reserve(net.IPv4(192, 0, 2, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alloc.Reserve(net.IPv4(192, 0, 2, 100)); !errors.Is(err, allocators.ErrAllocated) {
+		t.Fatalf("Expected ErrAllocated reserving twice, got %v", err)
+	}
+	if _, err := alloc.Reserve(net.IPv4(192, 0, 2, 1)); !errors.Is(err, allocators.ErrAllocated) {
+		t.Fatalf("Expected ErrAllocated reserving an excluded address, got %v", err)
+	}
+	if _, err := alloc.Reserve(net.IPv4(198, 51, 100, 1)); err == nil {
+		t.Fatal("Reserved an address outside of the range")
+	}
+	allocated, err := alloc.Allocate(net.IPNet{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := alloc.Capacity(); c != 252 {
+		t.Fatalf("Expected a capacity of 252, got %d", c)
+	}
+	if u := alloc.Used(); u != 2 {
+		t.Fatalf("Expected 2 addresses used, got %d", u)
+	}
+	if !alloc.IsAllocated(reserved.IP) || alloc.IsAllocated(net.IPv4(192, 0, 2, 1)) || alloc.IsAllocated(net.IPv4(192, 0, 2, 200)) {
+		t.Fatal("IsAllocated doesn't match the allocations")
+	}
+	var got []string
+	alloc.Each(func(n net.IPNet) bool {
+		got = append(got, n.String())
+		return true
+	})
+	if len(got) != 2 || got[0] != allocated.String() || got[1] != "192.0.2.100/32" {
+		t.Fatalf("Expected %s and 192.0.2.100/32, got %v", &allocated, got)
+	}
+}
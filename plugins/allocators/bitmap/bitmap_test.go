@@ -5,12 +5,15 @@
 package bitmap
 
 import (
+	"errors"
 	"math"
 	"math/rand"
 	"net"
 	"testing"
 
 	"github.com/willf/bitset"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators"
 )
 
 func getAllocator(bits int) *Allocator {
@@ -92,6 +95,94 @@ func TestOutOfPool(t *testing.T) {
 	}
 }
 
+func TestExclude(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("2001:db8::/62")
+	alloc, _ := NewBitmapAllocator(*prefix, 64)
+
+	// A smaller prefix excludes the whole block containing it, a larger one
+	// all the blocks it contains
+	for _, excl := range []string{"2001:db8::/80", "2001:db8:0:2::/63", "2001:db8:1::/48"} {
+		_, n, _ := net.ParseCIDR(excl)
+		if err := alloc.Exclude(*n); err != nil {
+			t.Fatalf("Could not exclude %s: %v", excl, err)
+		}
+	}
+
+	res, err := alloc.Allocate(net.IPNet{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.String() != "2001:db8:0:1::/64" {
+		t.Fatalf("Allocated %s, expected 2001:db8:0:1::/64", &res)
+	}
+	if _, err := alloc.Allocate(net.IPNet{}); err == nil {
+		t.Fatal("Allocated an excluded prefix")
+	}
+	_, excluded, _ := net.ParseCIDR("2001:db8::/64")
+	if err := alloc.Free(*excluded); err == nil {
+		t.Fatal("Expected an error freeing an excluded prefix")
+	}
+}
+
+func TestRandom(t *testing.T) {
+	alloc := getAllocator(4)
+	if err := alloc.SetStrategy(allocators.Random); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 16; i++ {
+		res, err := alloc.Allocate(net.IPNet{})
+		if err != nil {
+			t.Fatalf("Error before exhaustion: %v", err)
+		}
+		if seen[res.String()] {
+			t.Fatalf("Allocated %s twice", &res)
+		}
+		seen[res.String()] = true
+	}
+	if _, err := alloc.Allocate(net.IPNet{}); err == nil {
+		t.Fatal("Allocated more prefixes than there are in the pool")
+	}
+	if err := alloc.SetStrategy(allocators.Strategy(42)); err == nil {
+		t.Fatal("Expected an error setting an unknown strategy")
+	}
+}
+
+func TestInspect(t *testing.T) {
+	alloc := getAllocator(8)
+
+	_, hint, _ := net.ParseCIDR("2001:db8:0:42::/64")
+	reserved, err := alloc.Reserve(net.ParseIP("2001:db8:0:42::1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reserved.String() != hint.String() {
+		t.Fatalf("Reserved %s, expected %s", &reserved, hint)
+	}
+	if _, err := alloc.Reserve(hint.IP); !errors.Is(err, allocators.ErrAllocated) {
+		t.Fatalf("Expected ErrAllocated reserving twice, got %v", err)
+	}
+	if _, err := alloc.Allocate(net.IPNet{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if c, u := alloc.Capacity(), alloc.Used(); c != 256 || u != 2 {
+		t.Fatalf("Expected 2 blocks used out of 256, got %d out of %d", u, c)
+	}
+	if !alloc.IsAllocated(hint.IP) || alloc.IsAllocated(net.ParseIP("2001:db8:0:43::")) {
+		t.Fatal("IsAllocated doesn't match the allocations")
+	}
+	var got []string
+	alloc.Each(func(n net.IPNet) bool {
+		got = append(got, n.String())
+		return false
+	})
+	if len(got) != 1 || got[0] != "2001:db8::/64" {
+		t.Fatalf("Expected to stop after 2001:db8::/64, got %v", got)
+	}
+}
+
 func prefixSizeForAllocs(allocs int) int {
 	return int(math.Ceil(math.Log2(float64(allocs))))
 }
@@ -135,3 +226,22 @@ func BenchmarkParallelAllocPartiallyFilled(b *testing.B) {
 		}
 	})
 }
+
+// Benchmark random Allocate on a bitmap 90% full, where most random picks are
+// taken and the allocator searches from a random position instead
+func BenchmarkRandomAllocDense(b *testing.B) {
+	alloc := getAllocator(prefixSizeForAllocs(b.N*10) + 1)
+	if err := alloc.SetStrategy(allocators.Random); err != nil {
+		b.Fatal(err)
+	}
+	for i := uint(0); i < alloc.bitmap.Len()*9/10; i++ {
+		alloc.bitmap.Set(i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if net, err := alloc.Allocate(net.IPNet{}); err != nil {
+			b.Fatalf("Could not allocate (got %v and an error): %v", net, err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package bitmap
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+
+	"github.com/willf/bitset"
+)
+
+// randomTries is the number of blocks picked at random before falling back to
+// a search from a random position. In sparse bitmaps one of them is almost
+// always free, while the search is efficient in dense ones
+const randomTries = 8
+
+// newRand returns a random source seeded from the system's, so that
+// allocations differ across restarts and servers
+func newRand() *rand.Rand {
+	var seed [8]byte
+	if _, err := crand.Read(seed[:]); err != nil {
+		log.Warningf("Could not seed the random allocation strategy: %v", err)
+	}
+	return rand.New(rand.NewSource(int64(binary.LittleEndian.Uint64(seed[:]))))
+}
+
+// nextClear returns the first free block at or after from, wrapping around at
+// the end of the bitmap
+func nextClear(b *bitset.BitSet, from uint) (uint, bool) {
+	if next, ok := b.NextClear(from); ok {
+		return next, true
+	}
+	return b.NextClear(0)
+}
+
+// randomClear returns a free block picked at random
+func randomClear(b *bitset.BitSet, rng *rand.Rand) (uint, bool) {
+	size := int64(b.Len())
+	if size == 0 {
+		return 0, false
+	}
+	for i := 0; i < randomTries; i++ {
+		idx := uint(rng.Int63n(size))
+		if !b.Test(idx) {
+			return idx, true
+		}
+	}
+	return nextClear(b, uint(rng.Int63n(size)))
+}
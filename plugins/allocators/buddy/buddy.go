@@ -0,0 +1,232 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package buddy implements a prefix allocator handing out prefixes of
+// different lengths from the same pool, within a shortest and a longest
+// prefix length. Blocks of the shortest length are taken from a sparse
+// allocator and split in halves as needed to serve longer prefixes; when both
+// halves of a block are free again, they are merged back
+package buddy
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators"
+	"github.com/coredhcp/coredhcp/plugins/allocators/sparse"
+)
+
+// block is a prefix, usable as a map key
+type block struct {
+	ip   [net.IPv6len]byte
+	ones int
+}
+
+func toBlock(ip net.IP, ones int) (b block) {
+	copy(b.ip[:], ip.To16().Mask(net.CIDRMask(ones, 128)))
+	b.ones = ones
+	return b
+}
+
+func (b block) net() net.IPNet {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, b.ip[:])
+	return net.IPNet{IP: ip, Mask: net.CIDRMask(b.ones, 128)}
+}
+
+// halves splits a block in two blocks one bit longer
+func (b block) halves() (block, block) {
+	low, high := block{ip: b.ip, ones: b.ones + 1}, block{ip: b.ip, ones: b.ones + 1}
+	high.ip[b.ones/8] |= 0x80 >> uint(b.ones%8)
+	return low, high
+}
+
+// buddy returns the other half of the block one bit shorter containing b
+func (b block) buddy() block {
+	b.ip[(b.ones-1)/8] ^= 0x80 >> uint((b.ones-1)%8)
+	return b
+}
+
+// contains returns whether c is inside b
+func (b block) contains(c block) bool {
+	return c.ones >= b.ones && toBlock(c.ip[:], b.ones) == b
+}
+
+// Allocator is a prefix allocator allocating prefixes of the length requested
+// by the client, within configured bounds
+type Allocator struct {
+	containing net.IPNet
+	// shortest and longest are the bounds of the allocated prefix lengths
+	shortest, longest int
+	top               *sparse.Allocator
+	// free holds the free halves of the blocks that were split, indexed by
+	// prefix length minus shortest. Free blocks of the shortest length are
+	// returned to top
+	free      []map[block]struct{}
+	allocated map[block]struct{}
+	l         sync.Mutex
+}
+
+// NewAllocator creates a new allocator, allocating prefixes from /`shortest`
+// to /`longest` out of the given `pool` prefix. The pool can hold at most 2^64
+// prefixes of the shortest length
+func NewAllocator(pool net.IPNet, shortest, longest int) (*Allocator, error) {
+	if shortest > longest || longest > 128 {
+		return nil, fmt.Errorf("Invalid prefix length bounds /%d to /%d", shortest, longest)
+	}
+	top, err := sparse.NewAllocator(pool, shortest)
+	if err != nil {
+		return nil, err
+	}
+	a := &Allocator{
+		containing: net.IPNet{IP: pool.IP.To16().Mask(pool.Mask), Mask: pool.Mask},
+		shortest:   shortest,
+		longest:    longest,
+		top:        top,
+		free:       make([]map[block]struct{}, longest-shortest+1),
+		allocated:  make(map[block]struct{}),
+	}
+	for i := range a.free {
+		a.free[i] = make(map[block]struct{})
+	}
+	return a, nil
+}
+
+// splitTo splits b down to target, which must be inside b. The halves not
+// containing target are free
+func (a *Allocator) splitTo(b, target block) block {
+	for b.ones < target.ones {
+		low, high := b.halves()
+		if high.contains(target) {
+			a.free[low.ones-a.shortest][low] = struct{}{}
+			b = high
+		} else {
+			a.free[high.ones-a.shortest][high] = struct{}{}
+			b = low
+		}
+	}
+	return b
+}
+
+// take allocates the given block if it's free
+func (a *Allocator) take(target block) bool {
+	for ones := target.ones; ones > a.shortest; ones-- {
+		ancestor := toBlock(target.ip[:], ones)
+		if _, ok := a.free[ones-a.shortest][ancestor]; ok {
+			delete(a.free[ones-a.shortest], ancestor)
+			a.allocated[a.splitTo(ancestor, target)] = struct{}{}
+			return true
+		}
+	}
+	ancestor := toBlock(target.ip[:], a.shortest)
+	if _, err := a.top.Reserve(ancestor.net().IP); err != nil {
+		return false
+	}
+	a.allocated[a.splitTo(ancestor, target)] = struct{}{}
+	return true
+}
+
+// lowestFree returns the free block of the given length with the lowest address
+func (a *Allocator) lowestFree(ones int) (block, bool) {
+	var (
+		lowest block
+		found  bool
+	)
+	for b := range a.free[ones-a.shortest] {
+		if !found || bytes.Compare(b.ip[:], lowest.ip[:]) < 0 {
+			lowest, found = b, true
+		}
+	}
+	return lowest, found
+}
+
+// Allocate reserves a prefix of the length of the hint, bounded by the
+// shortest and longest lengths of the allocator; the longest one when the hint
+// has no length. The hinted prefix is returned if it is free. Otherwise, the
+// smallest free block that fits is split, so that larger blocks remain
+// available for clients requesting them
+func (a *Allocator) Allocate(hint net.IPNet) (net.IPNet, error) {
+	ones, bits := hint.Mask.Size()
+	switch {
+	case bits != 128 || ones == 0 || ones > a.longest:
+		ones = a.longest
+	case ones < a.shortest:
+		ones = a.shortest
+	}
+
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if hint.IP.To16() != nil && a.containing.Contains(hint.IP) {
+		target := toBlock(hint.IP, ones)
+		if a.take(target) {
+			return target.net(), nil
+		}
+	}
+	for l := ones; l > a.shortest; l-- {
+		if b, ok := a.lowestFree(l); ok {
+			delete(a.free[l-a.shortest], b)
+			target := a.splitTo(b, toBlock(b.ip[:], ones))
+			a.allocated[target] = struct{}{}
+			return target.net(), nil
+		}
+	}
+	topBlock, err := a.top.Allocate(net.IPNet{})
+	if err != nil {
+		return net.IPNet{}, err
+	}
+	b := toBlock(topBlock.IP, a.shortest)
+	target := a.splitTo(b, toBlock(b.ip[:], ones))
+	a.allocated[target] = struct{}{}
+	return target.net(), nil
+}
+
+// Free returns the given prefix to the available pool if it was taken, merging
+// it with its free buddies
+func (a *Allocator) Free(prefix net.IPNet) error {
+	ones, bits := prefix.Mask.Size()
+	if bits != 128 || prefix.IP.To16() == nil || !a.containing.Contains(prefix.IP) {
+		return fmt.Errorf("Could not find prefix in pool: %s", prefix.String())
+	}
+	b := toBlock(prefix.IP, ones)
+
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if _, ok := a.allocated[b]; !ok {
+		return &allocators.ErrDoubleFree{Loc: prefix}
+	}
+	delete(a.allocated, b)
+	for b.ones > a.shortest {
+		buddy := b.buddy()
+		if _, ok := a.free[b.ones-a.shortest][buddy]; !ok {
+			a.free[b.ones-a.shortest][b] = struct{}{}
+			return nil
+		}
+		delete(a.free[b.ones-a.shortest], buddy)
+		b = toBlock(b.ip[:], b.ones-1)
+	}
+	if err := a.top.Free(b.net()); err != nil {
+		return fmt.Errorf("BUG: could not free a merged block: %w", err)
+	}
+	return nil
+}
+
+// SetStrategy sets how the allocator picks the blocks of the shortest length.
+// Within split blocks, the lowest free prefix is always used
+func (a *Allocator) SetStrategy(s allocators.Strategy) error {
+	return a.top.SetStrategy(s)
+}
+
+// Contains returns whether an address is in the pool of the allocator
+func (a *Allocator) Contains(ip net.IP) bool {
+	return a.containing.Contains(ip)
+}
+
+// First returns the lowest address in the pool of the allocator
+func (a *Allocator) First() net.IP {
+	return a.containing.IP
+}
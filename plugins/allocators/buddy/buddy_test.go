@@ -0,0 +1,160 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package buddy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators"
+)
+
+func getAllocator(pool string, shortest, longest int) *Allocator {
+	_, prefix, err := net.ParseCIDR(pool)
+	if err != nil {
+		panic(err)
+	}
+	alloc, err := NewAllocator(*prefix, shortest, longest)
+	if err != nil {
+		panic(err)
+	}
+	return alloc
+}
+
+func cidr(s string) net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return *n
+}
+
+func TestNewAllocator(t *testing.T) {
+	_, pool, _ := net.ParseCIDR("2001:db8::/48")
+	for _, bounds := range [][2]int{{64, 56}, {56, 129}, {40, 64}} {
+		if _, err := NewAllocator(*pool, bounds[0], bounds[1]); err == nil {
+			t.Errorf("Bounds /%d to /%d should be rejected", bounds[0], bounds[1])
+		}
+	}
+}
+
+func TestLengths(t *testing.T) {
+	alloc := getAllocator("2001:db8::/48", 52, 64)
+
+	for _, tt := range []struct {
+		hint, expected string
+	}{
+		// No length: the longest prefix, split from the first /52
+		{"", "2001:db8::/64"},
+		// The smallest fitting block is split: the buddy of the first /64
+		{"::/64", "2001:db8:0:1::/64"},
+		{"::/56", "2001:db8:0:100::/56"},
+		// Clamped to the bounds
+		{"::/40", "2001:db8:0:1000::/52"},
+		{"::/72", "2001:db8:0:2::/64"},
+	} {
+		var hint net.IPNet
+		if tt.hint != "" {
+			hint = cidr(tt.hint)
+		}
+		res, err := alloc.Allocate(hint)
+		if err != nil {
+			t.Fatalf("Allocating %q: %v", tt.hint, err)
+		}
+		if res.String() != tt.expected {
+			t.Errorf("Allocating %q: expected %s, got %s", tt.hint, tt.expected, res.String())
+		}
+	}
+}
+
+func TestHint(t *testing.T) {
+	alloc := getAllocator("2001:db8::/48", 52, 64)
+
+	res, err := alloc.Allocate(cidr("2001:db8:0:8800::/56"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.String() != "2001:db8:0:8800::/56" {
+		t.Fatalf("Hint was not honored, got %s", res.String())
+	}
+	// The same /56, and a /64 inside it, are taken now
+	for _, hint := range []string{"2001:db8:0:8800::/56", "2001:db8:0:8842::/64"} {
+		res, err = alloc.Allocate(cidr(hint))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Contains(net.ParseIP("2001:db8:0:8800::")) {
+			t.Errorf("Allocated %s twice for hint %s", res.String(), hint)
+		}
+	}
+	// The remaining free blocks of the split /52 are used first
+	res, err = alloc.Allocate(net.IPNet{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	split := cidr("2001:db8:0:8000::/52")
+	if !split.Contains(res.IP) {
+		t.Errorf("Expected a prefix of the split /52, got %s", res.String())
+	}
+}
+
+func TestFreeMerges(t *testing.T) {
+	alloc := getAllocator("2001:db8::/62", 62, 64)
+
+	var allocd []net.IPNet
+	for i := 0; i < 4; i++ {
+		res, err := alloc.Allocate(net.IPNet{})
+		if err != nil {
+			t.Fatalf("Error before exhaustion: %v", err)
+		}
+		allocd = append(allocd, res)
+	}
+	if _, err := alloc.Allocate(net.IPNet{}); err != allocators.ErrNoAddrAvail {
+		t.Fatalf("Expected the pool to be exhausted, got %v", err)
+	}
+	if _, err := alloc.Allocate(cidr("::/62")); err == nil {
+		t.Fatal("Allocated a /62 out of an exhausted pool")
+	}
+
+	for _, p := range allocd {
+		if err := alloc.Free(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := alloc.Free(allocd[0]); err == nil {
+		t.Fatal("Expected DoubleFree error")
+	}
+	for i, free := range alloc.free {
+		if len(free) != 0 {
+			t.Errorf("Expected all blocks to be merged, got %d free /%d", len(free), alloc.shortest+i)
+		}
+	}
+	// The whole pool is available again
+	res, err := alloc.Allocate(cidr("::/62"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.String() != "2001:db8::/62" {
+		t.Fatalf("Expected the whole pool, got %s", res.String())
+	}
+}
+
+func TestFreeWrongLength(t *testing.T) {
+	alloc := getAllocator("2001:db8::/48", 56, 64)
+
+	res, err := alloc.Allocate(cidr("::/56"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := alloc.Free(net.IPNet{IP: res.IP, Mask: net.CIDRMask(64, 128)}); err == nil {
+		t.Error("Freed a /64 out of an allocated /56")
+	}
+	if err := alloc.Free(cidr("2001:db9::/56")); err == nil {
+		t.Error("Freed a prefix outside of the pool")
+	}
+	if err := alloc.Free(res); err != nil {
+		t.Error(err)
+	}
+}
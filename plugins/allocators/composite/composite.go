@@ -0,0 +1,222 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package composite implements an allocator spanning several disjoint pools,
+// each one handled by its own allocator. This lets a single pool be made of
+// several ranges, with holes between them
+package composite
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators"
+)
+
+// Member is an allocator that can be part of a composite allocator: it must
+// tell which addresses belong to its pool, and where the pool starts
+type Member interface {
+	allocators.Allocator
+	Contains(net.IP) bool
+	First() net.IP
+}
+
+// Allocator allocates from its members in ascending order of their pools,
+// moving on to the next one when a member has no address left. With the random strategy, it starts from
+// a random member
+type Allocator struct {
+	members []Member
+	// rng picks the first member with the random strategy, it is nil
+	// otherwise
+	rng *rand.Rand
+	l   sync.Mutex
+}
+
+// New creates a composite allocator. The pools of the members must not
+// overlap
+func New(members ...Member) (*Allocator, error) {
+	if len(members) == 0 {
+		return nil, errors.New("a composite allocator needs at least one member")
+	}
+	sorted := make([]Member, len(members))
+	copy(sorted, members)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].First().To16(), sorted[j].First().To16()) < 0
+	})
+	return &Allocator{members: sorted}, nil
+}
+
+// member returns the member whose pool contains an address
+func (a *Allocator) member(ip net.IP) Member {
+	for _, m := range a.members {
+		if m.Contains(ip) {
+			return m
+		}
+	}
+	return nil
+}
+
+// Allocate reserves an address or prefix, from the member containing the hint
+// if there is one and it has space left, or else from the first member with
+// space left
+func (a *Allocator) Allocate(hint net.IPNet) (net.IPNet, error) {
+	if hint.IP != nil {
+		if m := a.member(hint.IP); m != nil {
+			if n, err := m.Allocate(hint); err == nil {
+				return n, nil
+			}
+		}
+	}
+	first := 0
+	a.l.Lock()
+	if a.rng != nil {
+		first = a.rng.Intn(len(a.members))
+	}
+	a.l.Unlock()
+	for i := range a.members {
+		m := a.members[(first+i)%len(a.members)]
+		n, err := m.Allocate(hint)
+		if err == nil {
+			return n, nil
+		}
+		if !errors.Is(err, allocators.ErrNoAddrAvail) {
+			return n, err
+		}
+	}
+	return net.IPNet{}, allocators.ErrNoAddrAvail
+}
+
+// Free returns an address or prefix to the member it was allocated from
+func (a *Allocator) Free(n net.IPNet) error {
+	m := a.member(n.IP)
+	if m == nil {
+		return fmt.Errorf("%s is not in the pool of any member", n.String())
+	}
+	return m.Free(n)
+}
+
+// Exclude removes the addresses of the given network from the pools of all
+// the members
+func (a *Allocator) Exclude(n net.IPNet) error {
+	for _, m := range a.members {
+		excluder, ok := m.(allocators.Excluder)
+		if !ok {
+			return errors.New("a member of the composite allocator does not support exclusions")
+		}
+		if err := excluder.Exclude(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetStrategy sets the strategy of the allocator and of all its members
+func (a *Allocator) SetStrategy(s allocators.Strategy) error {
+	for _, m := range a.members {
+		setter, ok := m.(allocators.StrategySetter)
+		if !ok {
+			return errors.New("a member of the composite allocator does not support strategies")
+		}
+		if err := setter.SetStrategy(s); err != nil {
+			return err
+		}
+	}
+	a.l.Lock()
+	defer a.l.Unlock()
+	a.rng = nil
+	if s == allocators.Random {
+		var seed [8]byte
+		if _, err := crand.Read(seed[:]); err != nil {
+			return fmt.Errorf("could not seed the random strategy: %w", err)
+		}
+		a.rng = rand.New(rand.NewSource(int64(binary.LittleEndian.Uint64(seed[:]))))
+	}
+	return nil
+}
+
+// inspectors returns the members as inspectors
+func (a *Allocator) inspectors() []allocators.Inspector {
+	inspectors := make([]allocators.Inspector, 0, len(a.members))
+	for _, m := range a.members {
+		if i, ok := m.(allocators.Inspector); ok {
+			inspectors = append(inspectors, i)
+		}
+	}
+	return inspectors
+}
+
+// Capacity returns the total capacity of the members which report it
+func (a *Allocator) Capacity() uint64 {
+	var capacity uint64
+	for _, i := range a.inspectors() {
+		c := i.Capacity()
+		if capacity+c < capacity {
+			return math.MaxUint64
+		}
+		capacity += c
+	}
+	return capacity
+}
+
+// Used returns the number of blocks allocated by the members which report it
+func (a *Allocator) Used() uint64 {
+	var used uint64
+	for _, i := range a.inspectors() {
+		used += i.Used()
+	}
+	return used
+}
+
+// Each calls f with each block allocated by the members, in ascending order,
+// until it returns false
+func (a *Allocator) Each(f func(net.IPNet) bool) {
+	more := true
+	for _, i := range a.inspectors() {
+		i.Each(func(n net.IPNet) bool {
+			more = f(n)
+			return more
+		})
+		if !more {
+			return
+		}
+	}
+}
+
+// IsAllocated returns whether the block containing an address is allocated
+func (a *Allocator) IsAllocated(ip net.IP) bool {
+	i, ok := a.member(ip).(allocators.Inspector)
+	return ok && i.IsAllocated(ip)
+}
+
+// Reserve allocates the block containing the given address from its member
+func (a *Allocator) Reserve(ip net.IP) (net.IPNet, error) {
+	m := a.member(ip)
+	if m == nil {
+		return net.IPNet{}, fmt.Errorf("%s is not in the pool of any member", ip)
+	}
+	r, ok := m.(allocators.Reserver)
+	if !ok {
+		return net.IPNet{}, errors.New("a member of the composite allocator does not support reservations")
+	}
+	return r.Reserve(ip)
+}
+
+// Contains returns whether an address is in the pool of a member, so that
+// composite allocators can themselves be members
+func (a *Allocator) Contains(ip net.IP) bool {
+	return a.member(ip) != nil
+}
+
+// First returns the lowest address of the pools of the members
+func (a *Allocator) First() net.IP {
+	return a.members[0].First()
+}
@@ -0,0 +1,174 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package composite
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators"
+	"github.com/coredhcp/coredhcp/plugins/allocators/bitmap"
+)
+
+func getAllocator(t *testing.T) *Allocator {
+	first, err := bitmap.NewIPv4Allocator(net.IPv4(192, 0, 2, 10), net.IPv4(192, 0, 2, 11))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := bitmap.NewIPv4Allocator(net.IPv4(192, 0, 2, 20), net.IPv4(192, 0, 2, 21))
+	if err != nil {
+		t.Fatal(err)
+	}
+	alloc, err := New(first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return alloc
+}
+
+func TestAllocate(t *testing.T) {
+	alloc := getAllocator(t)
+
+	// The hint selects the member
+	hint := net.IPNet{IP: net.IPv4(192, 0, 2, 21), Mask: net.CIDRMask(32, 32)}
+	res, err := alloc.Allocate(hint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.IP.Equal(hint.IP) {
+		t.Fatalf("Hint was not honored, got %s", res.IP)
+	}
+
+	// Then members are used in order
+	var got []string
+	for i := 0; i < 3; i++ {
+		res, err := alloc.Allocate(net.IPNet{})
+		if err != nil {
+			t.Fatalf("Error before exhaustion: %v", err)
+		}
+		got = append(got, res.IP.String())
+	}
+	want := []string{"192.0.2.10", "192.0.2.11", "192.0.2.20"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Allocated %v, expected %v", got, want)
+		}
+	}
+	if _, err := alloc.Allocate(net.IPNet{}); !errors.Is(err, allocators.ErrNoAddrAvail) {
+		t.Fatalf("Expected ErrNoAddrAvail, got %v", err)
+	}
+
+	if err := alloc.Free(hint); err != nil {
+		t.Fatal(err)
+	}
+	if err := alloc.Free(hint); err == nil {
+		t.Fatal("Expected DoubleFree error")
+	}
+	if err := alloc.Free(net.IPNet{IP: net.IPv4(192, 0, 2, 15), Mask: net.CIDRMask(32, 32)}); err == nil {
+		t.Fatal("Expected an error freeing an address outside of the pool")
+	}
+}
+
+func TestUnsorted(t *testing.T) {
+	high, err := bitmap.NewIPv4Allocator(net.IPv4(192, 0, 2, 20), net.IPv4(192, 0, 2, 21))
+	if err != nil {
+		t.Fatal(err)
+	}
+	low, err := bitmap.NewIPv4Allocator(net.IPv4(192, 0, 2, 10), net.IPv4(192, 0, 2, 11))
+	if err != nil {
+		t.Fatal(err)
+	}
+	alloc, err := New(high, low)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !alloc.First().Equal(net.IPv4(192, 0, 2, 10)) {
+		t.Fatalf("Expected the pool to start at 192.0.2.10, got %s", alloc.First())
+	}
+	// The lowest member is used first
+	if res, err := alloc.Allocate(net.IPNet{}); err != nil || res.IP.String() != "192.0.2.10" {
+		t.Fatalf("Expected 192.0.2.10, got %s (%v)", res.IP, err)
+	}
+	for _, ip := range []net.IP{net.IPv4(192, 0, 2, 21), net.IPv4(192, 0, 2, 11)} {
+		if _, err := alloc.Reserve(ip); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	alloc.Each(func(n net.IPNet) bool {
+		got = append(got, n.IP.String())
+		return true
+	})
+	want := []string{"192.0.2.10", "192.0.2.11", "192.0.2.21"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Fatalf("Expected the allocated addresses in ascending order %v, got %v", want, got)
+	}
+}
+
+func TestExclude(t *testing.T) {
+	alloc := getAllocator(t)
+
+	_, n, _ := net.ParseCIDR("192.0.2.8/29")
+	if err := alloc.Exclude(*n); err != nil {
+		t.Fatal(err)
+	}
+	res, err := alloc.Allocate(net.IPNet{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.IP.Equal(net.IPv4(192, 0, 2, 20)) {
+		t.Fatalf("Allocated %s, expected 192.0.2.20", res.IP)
+	}
+}
+
+func TestRandom(t *testing.T) {
+	alloc := getAllocator(t)
+	if err := alloc.SetStrategy(allocators.Random); err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		res, err := alloc.Allocate(net.IPNet{})
+		if err != nil {
+			t.Fatalf("Error before exhaustion: %v", err)
+		}
+		seen[res.IP.String()] = true
+	}
+	if len(seen) != 4 {
+		t.Fatalf("Expected 4 distinct addresses, got %v", seen)
+	}
+	if _, err := alloc.Allocate(net.IPNet{}); !errors.Is(err, allocators.ErrNoAddrAvail) {
+		t.Fatalf("Expected ErrNoAddrAvail, got %v", err)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	alloc := getAllocator(t)
+	if _, err := alloc.Reserve(net.IPv4(192, 0, 2, 21)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alloc.Reserve(net.IPv4(192, 0, 2, 15)); err == nil {
+		t.Fatal("Reserved an address outside of the pool")
+	}
+	if _, err := alloc.Allocate(net.IPNet{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if c, u := alloc.Capacity(), alloc.Used(); c != 4 || u != 2 {
+		t.Fatalf("Expected 2 addresses used out of 4, got %d out of %d", u, c)
+	}
+	if !alloc.IsAllocated(net.IPv4(192, 0, 2, 21)) || alloc.IsAllocated(net.IPv4(192, 0, 2, 15)) {
+		t.Fatal("IsAllocated doesn't match the allocations")
+	}
+	var got []string
+	alloc.Each(func(n net.IPNet) bool {
+		got = append(got, n.IP.String())
+		return true
+	})
+	if len(got) != 2 || got[0] != "192.0.2.10" || got[1] != "192.0.2.21" {
+		t.Fatalf("Unexpected allocated addresses %v", got)
+	}
+}
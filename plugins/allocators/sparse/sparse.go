@@ -0,0 +1,408 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package sparse implements a prefix allocator for pools too large for a
+// bitmap, such as /128s out of a /64 or /64s out of a /32. Like the bitmap
+// allocator, it only returns prefixes of a single size; but it only keeps
+// track of the allocated prefixes, so its memory use is proportional to the
+// number of allocations rather than to the size of the pool
+package sparse
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators"
+)
+
+// randomTries is the number of random blocks tried before falling back to a
+// search from a random position. Pools using this allocator are expected to
+// be mostly empty, so the first pick is almost always free
+const randomTries = 8
+
+// span is a range of block indices, bounds included
+type span struct {
+	first, last uint64
+}
+
+// Allocator is a prefix allocator allocating in blocks of a fixed size,
+// regardless of the size requested by the client
+type Allocator struct {
+	containing net.IPNet
+	page       int
+	// last is the index of the last block of the pool
+	last      uint64
+	allocated map[uint64]struct{}
+	// excluded holds sorted, disjoint spans of blocks which are never
+	// allocated, and excludedCount the number of blocks they contain
+	excluded      []span
+	excludedCount uint64
+	// All the blocks before next are taken
+	next     uint64
+	strategy allocators.Strategy
+	rng      *rand.Rand
+	l        sync.Mutex
+}
+
+// NewAllocator creates a new allocator, allocating /`size` prefixes carved
+// out of the given `pool` prefix. The pool can hold at most 2^64 prefixes
+func NewAllocator(pool net.IPNet, size int) (*Allocator, error) {
+	poolSize, bits := pool.Mask.Size()
+	if bits != 128 || pool.IP.To16() == nil {
+		return nil, fmt.Errorf("Invalid IPv6 pool %s", pool.String())
+	}
+	allocOrder := size - poolSize
+	if allocOrder < 0 || size > 128 {
+		return nil, errors.New("The size of allocated prefixes cannot be larger than the pool they're allocated from")
+	} else if allocOrder > 64 {
+		return nil, fmt.Errorf("A pool with more than 2^64 items is not representable, got 2^%d", allocOrder)
+	}
+
+	var seed [8]byte
+	if _, err := crand.Read(seed[:]); err != nil {
+		return nil, fmt.Errorf("Could not seed the random strategy: %w", err)
+	}
+	last := uint64(math.MaxUint64)
+	if allocOrder < 64 {
+		last = 1<<uint(allocOrder) - 1
+	}
+	return &Allocator{
+		containing: net.IPNet{IP: pool.IP.To16().Mask(pool.Mask), Mask: pool.Mask},
+		page:       size,
+		last:       last,
+		allocated:  make(map[uint64]struct{}),
+		rng:        rand.New(rand.NewSource(int64(binary.LittleEndian.Uint64(seed[:])))),
+	}, nil
+}
+
+func (a *Allocator) toIndex(base net.IP) (uint64, error) {
+	value, err := allocators.Offset(base, a.containing.IP, a.page)
+	if err != nil {
+		return 0, fmt.Errorf("Cannot compute prefix index: %w", err)
+	}
+	return value, nil
+}
+
+func (a *Allocator) toPrefix(idx uint64) (net.IP, error) {
+	return allocators.AddPrefixes(a.containing.IP, idx, uint64(a.page))
+}
+
+// excludedSpan returns the excluded span containing a block, if any
+func (a *Allocator) excludedSpan(idx uint64) (span, bool) {
+	i := sort.Search(len(a.excluded), func(i int) bool { return a.excluded[i].last >= idx })
+	if i < len(a.excluded) && a.excluded[i].first <= idx {
+		return a.excluded[i], true
+	}
+	return span{}, false
+}
+
+func (a *Allocator) taken(idx uint64) bool {
+	if _, ok := a.allocated[idx]; ok {
+		return true
+	}
+	_, ok := a.excludedSpan(idx)
+	return ok
+}
+
+// full returns whether all the blocks are taken
+func (a *Allocator) full() bool {
+	taken := uint64(len(a.allocated)) + a.excludedCount
+	return taken > 0 && taken-1 == a.last
+}
+
+// nextFree returns the first free block at or after from
+func (a *Allocator) nextFree(from uint64) (uint64, bool) {
+	idx := from
+	for {
+		if s, ok := a.excludedSpan(idx); ok {
+			if s.last == a.last {
+				return 0, false
+			}
+			idx = s.last + 1
+			continue
+		}
+		if _, ok := a.allocated[idx]; !ok {
+			return idx, true
+		}
+		if idx == a.last {
+			return 0, false
+		}
+		idx++
+	}
+}
+
+// advance moves next past the blocks taken from it on, so that sequential
+// allocations don't walk them again
+func (a *Allocator) advance() {
+	for a.next != a.last {
+		if s, ok := a.excludedSpan(a.next); ok {
+			if s.last == a.last {
+				a.next = a.last
+				return
+			}
+			a.next = s.last + 1
+			continue
+		}
+		if _, ok := a.allocated[a.next]; !ok {
+			return
+		}
+		a.next++
+	}
+}
+
+// randomIndex returns a block index picked uniformly at random
+func (a *Allocator) randomIndex() uint64 {
+	if a.last < math.MaxInt64 {
+		return uint64(a.rng.Int63n(int64(a.last) + 1))
+	}
+	for {
+		if idx := a.rng.Uint64(); idx <= a.last {
+			return idx
+		}
+	}
+}
+
+// Allocate reserves a block and returns it. The hinted prefix is returned if
+// it is free, and blocks are the size of the allocator regardless of the size
+// of the hint
+func (a *Allocator) Allocate(hint net.IPNet) (ret net.IPNet, err error) {
+	ret.Mask = net.CIDRMask(a.page, 128)
+
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if a.full() {
+		return ret, allocators.ErrNoAddrAvail
+	}
+
+	var (
+		idx uint64
+		ok  bool
+	)
+	if hint.IP.To16() != nil && a.containing.Contains(hint.IP) {
+		if hintIdx, hintErr := a.toIndex(hint.IP); hintErr == nil && !a.taken(hintIdx) {
+			idx, ok = hintIdx, true
+		}
+	}
+	if !ok && a.strategy == allocators.Random {
+		for i := 0; i < randomTries && !ok; i++ {
+			idx = a.randomIndex()
+			ok = !a.taken(idx)
+		}
+		if !ok {
+			idx, ok = a.nextFree(a.randomIndex())
+		}
+	}
+	if !ok {
+		idx, ok = a.nextFree(a.next)
+		if !ok {
+			return ret, allocators.ErrNoAddrAvail
+		}
+		// All the blocks before idx are taken
+		a.next = idx
+	}
+
+	ret.IP, err = a.toPrefix(idx)
+	if err != nil {
+		// This violates the assumption that every index maps back to a valid prefix
+		return ret, fmt.Errorf("BUG: could not get prefix from allocation: %w", err)
+	}
+	a.allocated[idx] = struct{}{}
+	if idx == a.next {
+		a.advance()
+	}
+	return ret, nil
+}
+
+// Free returns the given prefix to the available pool if it was taken
+func (a *Allocator) Free(prefix net.IPNet) error {
+	if !a.containing.Contains(prefix.IP) {
+		return fmt.Errorf("Could not find prefix in pool: %s", prefix.String())
+	}
+	idx, err := a.toIndex(prefix.IP.Mask(prefix.Mask))
+	if err != nil {
+		return fmt.Errorf("Could not find prefix in pool: %w", err)
+	}
+
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if _, ok := a.allocated[idx]; !ok {
+		return &allocators.ErrDoubleFree{Loc: prefix}
+	}
+	delete(a.allocated, idx)
+	if idx < a.next {
+		a.next = idx
+	}
+	return nil
+}
+
+// Exclude removes the blocks overlapping the given prefix from the pool
+func (a *Allocator) Exclude(prefix net.IPNet) error {
+	ones, bits := prefix.Mask.Size()
+	if bits != 128 || prefix.IP.To16() == nil {
+		return fmt.Errorf("Invalid IPv6 prefix %s", prefix.String())
+	}
+	poolSize, _ := a.containing.Mask.Size()
+
+	var s span
+	switch {
+	case ones <= poolSize:
+		if !prefix.Contains(a.containing.IP) {
+			return nil
+		}
+		s = span{0, a.last}
+	case !a.containing.Contains(prefix.IP):
+		return nil
+	default:
+		first, err := a.toIndex(prefix.IP.Mask(prefix.Mask))
+		if err != nil {
+			return err
+		}
+		s = span{first, first}
+		if ones < a.page {
+			s.last = first + (1<<uint(a.page-ones) - 1)
+		}
+	}
+
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	// Allocated blocks in the span are now excluded
+	for idx := range a.allocated {
+		if idx >= s.first && idx <= s.last {
+			delete(a.allocated, idx)
+		}
+	}
+	merged := []span{}
+	for _, e := range a.excluded {
+		// Merge overlapping and adjacent spans
+		if e.last < s.first && e.last+1 != s.first || e.first > s.last && s.last+1 != e.first {
+			merged = append(merged, e)
+			continue
+		}
+		if e.first < s.first {
+			s.first = e.first
+		}
+		if e.last > s.last {
+			s.last = e.last
+		}
+	}
+	merged = append(merged, s)
+	sort.Slice(merged, func(i, j int) bool { return merged[i].first < merged[j].first })
+	a.excluded = merged
+	a.excludedCount = 0
+	for _, e := range a.excluded {
+		a.excludedCount += e.last - e.first + 1
+	}
+	return nil
+}
+
+// Capacity returns the number of blocks in the pool, excluded ones aside. It
+// saturates at the largest uint64 for pools of 2^64 blocks
+func (a *Allocator) Capacity() uint64 {
+	a.l.Lock()
+	defer a.l.Unlock()
+	if a.last == math.MaxUint64 && a.excludedCount == 0 {
+		return math.MaxUint64
+	}
+	return a.last - a.excludedCount + 1
+}
+
+// Used returns the number of allocated blocks
+func (a *Allocator) Used() uint64 {
+	a.l.Lock()
+	defer a.l.Unlock()
+	return uint64(len(a.allocated))
+}
+
+// Each calls f with each allocated block, in ascending order, until it
+// returns false. f must not call the allocator
+func (a *Allocator) Each(f func(net.IPNet) bool) {
+	a.l.Lock()
+	defer a.l.Unlock()
+	indices := make([]uint64, 0, len(a.allocated))
+	for idx := range a.allocated {
+		indices = append(indices, idx)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	for _, idx := range indices {
+		ip, err := a.toPrefix(idx)
+		if err != nil {
+			continue
+		}
+		if !f(net.IPNet{IP: ip, Mask: net.CIDRMask(a.page, 128)}) {
+			return
+		}
+	}
+}
+
+// IsAllocated returns whether the block containing an address is allocated
+func (a *Allocator) IsAllocated(ip net.IP) bool {
+	if !a.containing.Contains(ip) {
+		return false
+	}
+	idx, err := a.toIndex(ip)
+	if err != nil {
+		return false
+	}
+	a.l.Lock()
+	defer a.l.Unlock()
+	_, ok := a.allocated[idx]
+	return ok
+}
+
+// Reserve allocates the block containing the given address
+func (a *Allocator) Reserve(ip net.IP) (ret net.IPNet, err error) {
+	if !a.containing.Contains(ip) {
+		return ret, fmt.Errorf("%s is not in the pool %s", ip, a.containing.String())
+	}
+	idx, err := a.toIndex(ip)
+	if err != nil {
+		return ret, err
+	}
+	a.l.Lock()
+	defer a.l.Unlock()
+	if a.taken(idx) {
+		return ret, allocators.ErrAllocated
+	}
+	ret.IP, err = a.toPrefix(idx)
+	if err != nil {
+		return ret, err
+	}
+	ret.Mask = net.CIDRMask(a.page, 128)
+	a.allocated[idx] = struct{}{}
+	if idx == a.next {
+		a.advance()
+	}
+	return ret, nil
+}
+
+// SetStrategy sets how the allocator picks prefixes
+func (a *Allocator) SetStrategy(s allocators.Strategy) error {
+	if s != allocators.Sequential && s != allocators.Random {
+		return fmt.Errorf("Unsupported allocation strategy %s", s)
+	}
+	a.l.Lock()
+	defer a.l.Unlock()
+	a.strategy = s
+	return nil
+}
+
+// Contains returns whether an address is in the pool of the allocator
+func (a *Allocator) Contains(ip net.IP) bool {
+	return a.containing.Contains(ip)
+}
+
+// First returns the lowest address in the pool of the allocator
+func (a *Allocator) First() net.IP {
+	return a.containing.IP
+}
@@ -0,0 +1,292 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package sparse
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"testing"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators"
+	"github.com/coredhcp/coredhcp/plugins/allocators/bitmap"
+)
+
+func getAllocator(pool string, size int) *Allocator {
+	_, prefix, err := net.ParseCIDR(pool)
+	if err != nil {
+		panic(err)
+	}
+	alloc, err := NewAllocator(*prefix, size)
+	if err != nil {
+		panic(err)
+	}
+	return alloc
+}
+
+func TestAlloc(t *testing.T) {
+	// /128s out of a /64, which the bitmap allocator can't handle
+	alloc := getAllocator("2001:db8::/64", 128)
+	if alloc.last != math.MaxUint64 {
+		t.Fatalf("Expected 2^64 blocks, got %d", alloc.last+1)
+	}
+
+	first, err := alloc.Allocate(net.IPNet{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.String() != "2001:db8::/128" {
+		t.Fatalf("Expected the first block, got %s", &first)
+	}
+	if err := alloc.Free(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := alloc.Free(first); err == nil {
+		t.Fatal("Expected DoubleFree error")
+	}
+
+	_, hint, _ := net.ParseCIDR("2001:db8::ffff:ffff:ffff:ffff/128")
+	res, err := alloc.Allocate(*hint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.String() != hint.String() {
+		t.Fatalf("Hint was not honored, got %s", &res)
+	}
+	if len(alloc.allocated) != 1 {
+		t.Fatalf("Expected one allocation to be tracked, got %d", len(alloc.allocated))
+	}
+}
+
+func TestExhaust(t *testing.T) {
+	alloc := getAllocator("2001:db8::/62", 64)
+
+	allocd := []net.IPNet{}
+	for i := 0; i < 4; i++ {
+		net, err := alloc.Allocate(net.IPNet{})
+		if err != nil {
+			t.Fatalf("Error before exhaustion: %v", err)
+		}
+		allocd = append(allocd, net)
+	}
+	if _, err := alloc.Allocate(net.IPNet{}); err == nil {
+		t.Fatal("Successfully allocated more prefixes than there are in the pool")
+	}
+
+	// The lowest free prefix is allocated first
+	for _, i := range []int{2, 1} {
+		if err := alloc.Free(allocd[i]); err != nil {
+			t.Fatalf("Could not free: %v", err)
+		}
+	}
+	net, err := alloc.Allocate(net.IPNet{})
+	if err != nil {
+		t.Fatalf("Could not reallocate after free: %v", err)
+	}
+	if net.String() != allocd[1].String() {
+		t.Fatalf("Did not obtain the right network after free: got %v, expected %v", net, allocd[1])
+	}
+}
+
+func TestExclude(t *testing.T) {
+	alloc := getAllocator("2001:db8::/60", 64)
+
+	allocd, err := alloc.Allocate(net.IPNet{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, excl := range []string{"2001:db8::/63", "2001:db8:0:2::/80", "2001:db8:0:4::/62", "2001:db8:0:3::/64", "2001:db8:1::/48"} {
+		_, n, _ := net.ParseCIDR(excl)
+		if err := alloc.Exclude(*n); err != nil {
+			t.Fatalf("Could not exclude %s: %v", excl, err)
+		}
+	}
+	// Adjacent spans are merged
+	if len(alloc.excluded) != 1 || alloc.excludedCount != 8 {
+		t.Fatalf("Expected one span of 8 blocks, got %v", alloc.excluded)
+	}
+	if err := alloc.Free(allocd); err == nil {
+		t.Fatal("Expected an error freeing an excluded prefix")
+	}
+
+	var got []string
+	for {
+		res, err := alloc.Allocate(net.IPNet{})
+		if err != nil {
+			break
+		}
+		got = append(got, res.String())
+	}
+	if len(got) != 8 || got[0] != "2001:db8:0:8::/64" {
+		t.Fatalf("Expected the 8 blocks after the excluded ones, got %v", got)
+	}
+}
+
+func TestNext(t *testing.T) {
+	alloc := getAllocator("2001:db8::/64", 128)
+	// Restored leases, out of order
+	for _, ip := range []string{"2001:db8::2", "2001:db8::1", "2001:db8::", "2001:db8::4"} {
+		if _, err := alloc.Reserve(net.ParseIP(ip)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if alloc.next != 3 {
+		t.Fatalf("Expected the sequential allocations to resume at 3, got %d", alloc.next)
+	}
+	res, err := alloc.Allocate(net.IPNet{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.String() != "2001:db8::3/128" || alloc.next != 5 {
+		t.Fatalf("Expected 2001:db8::3/128 and to resume at 5, got %s and %d", &res, alloc.next)
+	}
+
+	// Sequential allocations skip the excluded blocks once
+	_, excluded, _ := net.ParseCIDR("2001:db8::/120")
+	if err := alloc.Exclude(*excluded); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alloc.Allocate(net.IPNet{}); err != nil {
+		t.Fatal(err)
+	}
+	if alloc.next != 257 {
+		t.Fatalf("Expected the sequential allocations to resume at 257, got %d", alloc.next)
+	}
+}
+
+func TestRandom(t *testing.T) {
+	alloc := getAllocator("2001:db8::/60", 64)
+	if err := alloc.SetStrategy(allocators.Random); err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 16; i++ {
+		res, err := alloc.Allocate(net.IPNet{})
+		if err != nil {
+			t.Fatalf("Error before exhaustion: %v", err)
+		}
+		if seen[res.String()] {
+			t.Fatalf("Allocated %s twice", &res)
+		}
+		seen[res.String()] = true
+	}
+	if _, err := alloc.Allocate(net.IPNet{}); err == nil {
+		t.Fatal("Allocated more prefixes than there are in the pool")
+	}
+}
+
+func TestInspect(t *testing.T) {
+	alloc := getAllocator("2001:db8::/64", 128)
+	if c := alloc.Capacity(); c != math.MaxUint64 {
+		t.Fatalf("Expected a saturated capacity, got %d", c)
+	}
+	_, excluded, _ := net.ParseCIDR("2001:db8::/120")
+	if err := alloc.Exclude(*excluded); err != nil {
+		t.Fatal(err)
+	}
+	if c := alloc.Capacity(); c != math.MaxUint64-255 {
+		t.Fatalf("Expected a capacity of 2^64-256, got %d", c)
+	}
+
+	ip := net.ParseIP("2001:db8::beef")
+	if _, err := alloc.Reserve(ip); err != nil {
+		t.Fatal(err)
+	}
+	for _, taken := range []net.IP{ip, net.ParseIP("2001:db8::1")} {
+		if _, err := alloc.Reserve(taken); !errors.Is(err, allocators.ErrAllocated) {
+			t.Fatalf("Expected ErrAllocated reserving %s, got %v", taken, err)
+		}
+	}
+	first, err := alloc.Allocate(net.IPNet{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.String() != "2001:db8::100/128" {
+		t.Fatalf("Expected the first block after the excluded ones, got %s", &first)
+	}
+
+	if u := alloc.Used(); u != 2 {
+		t.Fatalf("Expected 2 blocks used, got %d", u)
+	}
+	if !alloc.IsAllocated(ip) || alloc.IsAllocated(net.ParseIP("2001:db8::1")) {
+		t.Fatal("IsAllocated doesn't match the allocations")
+	}
+	var got []string
+	alloc.Each(func(n net.IPNet) bool {
+		got = append(got, n.String())
+		return true
+	})
+	if len(got) != 2 || got[0] != "2001:db8::100/128" || got[1] != "2001:db8::beef/128" {
+		t.Fatalf("Unexpected allocated blocks %v", got)
+	}
+}
+
+// Benchmark Allocate with a sparse and a bitmap allocator, on a pool both can
+// handle, and with the sparse allocator on a pool too large for a bitmap
+// reservedBlocks is the number of blocks taken before the benchmark starts
+const reservedBlocks = 100000
+
+func BenchmarkAlloc(b *testing.B) {
+	_, pool, _ := net.ParseCIDR("2001:db8::/40")
+	for _, strategy := range []allocators.Strategy{allocators.Sequential, allocators.Random} {
+		b.Run(fmt.Sprintf("bitmap/%s", strategy), func(b *testing.B) {
+			alloc, err := bitmap.NewBitmapAllocator(*pool, 64)
+			if err != nil {
+				b.Fatal(err)
+			}
+			benchmarkAlloc(b, alloc, strategy)
+		})
+		b.Run(fmt.Sprintf("sparse/%s", strategy), func(b *testing.B) {
+			alloc, err := NewAllocator(*pool, 64)
+			if err != nil {
+				b.Fatal(err)
+			}
+			benchmarkAlloc(b, alloc, strategy)
+		})
+		b.Run(fmt.Sprintf("sparse-huge/%s", strategy), func(b *testing.B) {
+			_, huge, _ := net.ParseCIDR("2001:db8::/64")
+			alloc, err := NewAllocator(*huge, 128)
+			if err != nil {
+				b.Fatal(err)
+			}
+			benchmarkAlloc(b, alloc, strategy)
+		})
+		// As after restoring leases at startup
+		b.Run(fmt.Sprintf("sparse-reserved/%s", strategy), func(b *testing.B) {
+			_, huge, _ := net.ParseCIDR("2001:db8::/64")
+			alloc, err := NewAllocator(*huge, 128)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for i := uint64(reservedBlocks); i > 0; i-- {
+				ip, err := allocators.AddPrefixes(huge.IP, i-1, 128)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := alloc.Reserve(ip); err != nil {
+					b.Fatal(err)
+				}
+			}
+			benchmarkAlloc(b, alloc, strategy)
+		})
+	}
+}
+
+func benchmarkAlloc(b *testing.B, alloc interface {
+	allocators.Allocator
+	allocators.StrategySetter
+}, strategy allocators.Strategy) {
+	if err := alloc.SetStrategy(strategy); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if net, err := alloc.Allocate(net.IPNet{}); err != nil {
+			b.Fatalf("Could not allocate (got %v and an error): %v", net, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package broadcast
+
+import (
+	"errors"
+	"net"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+var log = logger.GetLogger("plugins/broadcast")
+
+// Plugin wraps the broadcast address plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "broadcast",
+	Setup4: setup4,
+}
+
+var (
+	broadcastAddress net.IP
+)
+
+func setup4(args ...string) (handler.Handler4, error) {
+	log.Printf("loaded plugin for DHCPv4.")
+	if len(args) != 1 {
+		return nil, errors.New("need exactly one broadcast address")
+	}
+	ip := net.ParseIP(args[0]).To4()
+	if ip == nil || ip.IsUnspecified() || ip.IsMulticast() {
+		return nil, errors.New("expected a broadcast IPv4 address, got: " + args[0])
+	}
+	broadcastAddress = ip
+	log.Infof("loaded broadcast address %s.", broadcastAddress)
+	return Handler4, nil
+}
+
+// Handler4 handles DHCPv4 packets for the broadcast plugin
+func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	if req.IsOptionRequested(dhcpv4.OptionBroadcastAddress) {
+		resp.Options.Update(dhcpv4.OptBroadcastAddress(broadcastAddress))
+	}
+	return resp, false
+}
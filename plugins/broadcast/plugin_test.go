@@ -0,0 +1,75 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package broadcast
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestSetup4(t *testing.T) {
+	for _, arg := range []string{"", "0.0.0.0", "224.0.0.1", "ff02::1", "broadcast"} {
+		if _, err := setup4(arg); err == nil {
+			t.Errorf("setup succeeded with invalid address %q", arg)
+		}
+	}
+	if _, err := setup4("192.0.2.255"); err != nil {
+		t.Fatal(err)
+	}
+	if !broadcastAddress.Equal(net.IPv4(192, 0, 2, 255)) {
+		t.Errorf("Loaded address %s, expected 192.0.2.255", broadcastAddress)
+	}
+}
+
+func TestAddBroadcast4(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionBroadcastAddress))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broadcastAddress = net.IPv4(192, 0, 2, 255).To4()
+
+	resp, stop := Handler4(req, stub)
+	if resp == nil {
+		t.Fatal("plugin did not return a message")
+	}
+	if stop {
+		t.Error("plugin interrupted processing")
+	}
+	if addr := resp.BroadcastAddress(); !addr.Equal(broadcastAddress) {
+		t.Errorf("Found broadcast address %s, expected %s", addr, broadcastAddress)
+	}
+}
+
+func TestNotRequested4(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broadcastAddress = net.IPv4(192, 0, 2, 255).To4()
+
+	resp, stop := Handler4(req, stub)
+	if resp == nil {
+		t.Fatal("plugin did not return a message")
+	}
+	if stop {
+		t.Error("plugin interrupted processing")
+	}
+	if resp.Options.Has(dhcpv4.OptionBroadcastAddress) {
+		t.Error("Broadcast address was added when not requested")
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package ddns
+
+// DHCID computation, as specified by RFC 4701. The DHCID RR is stored next to
+// the forward records and lets several DHCP servers (or the same server after a
+// restart) recognize which client owns a name.
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/rfc1035label"
+)
+
+// Identifier types, RFC 4701 section 3.3
+const (
+	dhcidTypeChaddr   uint16 = 0x0000
+	dhcidTypeClientID uint16 = 0x0001
+	dhcidTypeDUID     uint16 = 0x0002
+)
+
+// dhcidDigestSHA256 is the only digest type defined by RFC 4701
+const dhcidDigestSHA256 = 1
+
+// computeDHCID returns the base64-encoded RDATA of a DHCID RR for the given
+// client identifier and FQDN.
+// The identifier must already be in the form mandated by the identifier type:
+// htype followed by chaddr for dhcidTypeChaddr, the content of option 61 for
+// dhcidTypeClientID, and the DUID for dhcidTypeDUID
+func computeDHCID(idType uint16, identifier []byte, fqdn string) string {
+	// The FQDN is hashed in canonical wire format, RFC 4701 section 3.5
+	labels := &rfc1035label.Labels{
+		Labels: []string{strings.ToLower(strings.TrimSuffix(fqdn, "."))},
+	}
+	h := sha256.New()
+	_, _ = h.Write(identifier)
+	_, _ = h.Write(labels.ToBytes())
+
+	rdata := make([]byte, 0, 3+sha256.Size)
+	rdata = append(rdata, byte(idType>>8), byte(idType), dhcidDigestSHA256)
+	rdata = h.Sum(rdata)
+	return base64.StdEncoding.EncodeToString(rdata)
+}
@@ -0,0 +1,574 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package ddns implements dynamic DNS updates (RFC 2136) for the addresses
+// handed out by the other plugins.
+//
+// When a lease is acknowledged (DHCPACK for DHCPv4, a Reply carrying IA_NA
+// addresses for DHCPv6), the plugin adds the forward records (A or AAAA) and
+// the reverse PTR record for the client's name to an authoritative DNS server.
+// The records are removed when the lease expires or, for DHCPv6, when the
+// client sends a Release.
+//
+// The client name is taken, in order of preference, from the Client FQDN
+// option already present in the response (set by an earlier plugin), the
+// name recorded by the `fqdn` plugin, the Client FQDN option of the request
+// (DHCPv4 option 81, DHCPv6 option 39), or the Host Name option (DHCPv4 option
+// 12). Unqualified names are qualified with the configured zone. If the client
+// asks to perform the forward update itself, only the PTR record is updated
+// unless `override` is set; if the client asks for no update at all, nothing is
+// done.
+//
+// Ownership of names is tracked with DHCID records (RFC 4701) and conflicts
+// are resolved as described in RFC 4703: a name that is already used by
+// another client is never overwritten.
+//
+// The plugin needs to run after the plugin that assigns addresses (e.g.
+// `range` or `file`), and after `lease_time` if used. Arguments are given as
+// key=value pairs:
+// - server: address of the authoritative DNS server, as host:port (mandatory)
+// - zone: forward zone to update, also used to qualify client names (mandatory)
+// - reverse: reverse zone to update (e.g. 2.0.192.in-addr.arpa.). PTR records
+//   are only updated if this is set
+// - tsig: TSIG key, in the same format as `nsupdate -y`: [alg:]name:secret.
+//   The algorithm defaults to hmac-sha256
+// - ttl: TTL of the records. Defaults to a third of the lease time
+// - override: if true, perform forward updates even when the client asked to
+//   do them itself
+// - timeout: timeout of a single DNS exchange, defaults to 5s
+//
+// Example usage:
+//
+// server4:
+//   - plugins:
+//     - range: leases.txt 192.0.2.100 192.0.2.200 1h
+//     - ddns: server=192.0.2.53:53 zone=example.com. reverse=2.0.192.in-addr.arpa. tsig=hmac-sha256:dhcp-key:c2VjcmV0
+//
+package ddns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/fqdn"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/miekg/dns"
+)
+
+var log = logger.GetLogger("plugins/ddns")
+
+// Plugin wraps plugin registration information
+var Plugin = plugins.Plugin{
+	Name:   "ddns",
+	Setup6: setup6,
+	Setup4: setup4,
+}
+
+// defaultLeaseTime is used when the response doesn't carry a lease time
+const defaultLeaseTime = time.Hour
+
+// binding is the DNS state associated to a client
+type binding struct {
+	fqdn    string
+	ips     []net.IP
+	dhcid   string
+	forward bool
+	ttl     uint32
+	expires time.Time
+	timer   *time.Timer
+}
+
+func (b *binding) same(o *binding) bool {
+	if b.fqdn != o.fqdn || b.forward != o.forward || len(b.ips) != len(o.ips) {
+		return false
+	}
+	for i := range b.ips {
+		if !b.ips[i].Equal(o.ips[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// PluginState is the data held by an instance of the ddns plugin
+type PluginState struct {
+	sync.Mutex
+	updater  *updater
+	ttl      time.Duration
+	override bool
+	// bindings maps a client identifier (as a string'd []byte) to the DNS
+	// records created for it
+	bindings map[string]*binding
+	// DNS updates are performed asynchronously, in order, by a single worker
+	queue chan func()
+}
+
+func (p *PluginState) worker() {
+	for f := range p.queue {
+		f()
+	}
+}
+
+// enqueue schedules a DNS update. It is called with the lock held, which
+// keeps the updates in order, so it doesn't wait for the worker: when the DNS
+// server is too slow to keep up, updates are dropped rather than blocking the
+// DHCP handlers
+func (p *PluginState) enqueue(f func()) {
+	select {
+	case p.queue <- f:
+	default:
+		log.Warningf("Dropping a DNS update, %d updates are already pending", cap(p.queue))
+	}
+}
+
+func (p *PluginState) add(b *binding) {
+	if b.forward {
+		if err := p.updater.addForward(b.fqdn, b.ips, b.dhcid, b.ttl); err != nil {
+			// Don't touch the reverse zone for a name we don't own
+			log.Warningf("Could not add %s for %v: %v", b.fqdn, b.ips, err)
+			return
+		}
+	}
+	for _, ip := range b.ips {
+		if err := p.updater.addReverse(b.fqdn, ip, b.ttl); err != nil {
+			log.Warningf("Could not add PTR %s for %s: %v", b.fqdn, ip, err)
+		}
+	}
+	log.Debugf("Added DNS records for %s: %v", b.fqdn, b.ips)
+}
+
+func (p *PluginState) remove(b *binding) {
+	for _, ip := range b.ips {
+		if err := p.updater.removeReverse(b.fqdn, ip); err != nil {
+			log.Warningf("Could not remove PTR %s for %s: %v", b.fqdn, ip, err)
+		}
+	}
+	if b.forward {
+		if err := p.updater.removeForward(b.fqdn, b.ips, b.dhcid); err != nil {
+			log.Warningf("Could not remove %s for %v: %v", b.fqdn, b.ips, err)
+		}
+	}
+	log.Debugf("Removed DNS records for %s: %v", b.fqdn, b.ips)
+}
+
+// bind records a new binding for the client and schedules the DNS updates.
+// Bindings identical to the existing one only extend its lifetime
+func (p *PluginState) bind(key string, b *binding, lease time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+
+	expires := time.Now().Add(lease)
+	if old, ok := p.bindings[key]; ok {
+		old.timer.Stop()
+		if old.same(b) {
+			old.expires = expires
+			old.timer.Reset(lease)
+			return
+		}
+		p.enqueue(func() { p.remove(old) })
+	}
+	b.expires = expires
+	b.timer = time.AfterFunc(lease, func() { p.expire(key, b) })
+	p.bindings[key] = b
+	p.enqueue(func() { p.add(b) })
+}
+
+// unbind removes the binding of a client, if any
+func (p *PluginState) unbind(key string) {
+	p.Lock()
+	defer p.Unlock()
+
+	b, ok := p.bindings[key]
+	if !ok {
+		return
+	}
+	b.timer.Stop()
+	delete(p.bindings, key)
+	p.enqueue(func() { p.remove(b) })
+}
+
+// expire removes a binding when its lease runs out, unless it has been
+// replaced or renewed in the meantime
+func (p *PluginState) expire(key string, b *binding) {
+	p.Lock()
+	defer p.Unlock()
+
+	if cur, ok := p.bindings[key]; !ok || cur != b || time.Now().Before(b.expires) {
+		return
+	}
+	delete(p.bindings, key)
+	p.enqueue(func() { p.remove(b) })
+}
+
+func (p *PluginState) recordTTL(lease time.Duration) uint32 {
+	ttl := p.ttl
+	if ttl == 0 {
+		// RFC 4702 section 5 suggests a third of the lease time
+		ttl = lease / 3
+	}
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	return uint32(ttl.Round(time.Second) / time.Second)
+}
+
+// qualify turns a client-supplied name into a FQDN within the configured zone
+func (p *PluginState) qualify(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return ""
+	}
+	if dns.Fqdn(name) == p.updater.zone {
+		return ""
+	}
+	if dns.IsSubDomain(p.updater.zone, dns.Fqdn(name)) {
+		return dns.Fqdn(name)
+	}
+	if dns.IsFqdn(name) {
+		// We can only update our own zone, only keep the host part
+		name = strings.SplitN(name, ".", 2)[0]
+	}
+	if _, ok := dns.IsDomainName(name); !ok {
+		return ""
+	}
+	return dns.Fqdn(name + "." + p.updater.zone)
+}
+
+// fqdn4 holds the content of a DHCPv4 Client FQDN option
+type fqdn4 struct {
+	flags uint8
+	name  string
+}
+
+func parseFQDN4(data []byte) (*fqdn4, error) {
+	if len(data) < 3 {
+		return nil, errors.New("option too short")
+	}
+	opt := fqdn4{flags: data[0]}
+	if opt.flags&fqdn.FlagE != 0 {
+		labels, err := rfc1035label.FromBytes(data[3:])
+		if err != nil {
+			return nil, err
+		}
+		if len(labels.Labels) > 0 {
+			opt.name = labels.Labels[0] + "."
+		}
+	} else {
+		opt.name = string(data[3:])
+	}
+	return &opt, nil
+}
+
+// clientID4 returns the identifier of a DHCPv4 client used for its DHCID: its
+// client identifier (option 61) if it sent one, or else its htype and chaddr.
+// It is also the key of its binding
+func clientID4(req *dhcpv4.DHCPv4) (uint16, []byte) {
+	if cid := req.Options.Get(dhcpv4.OptionClientIdentifier); len(cid) > 0 {
+		return dhcidTypeClientID, cid
+	}
+	return dhcidTypeChaddr, append([]byte{byte(req.HWType)}, req.ClientHWAddr...)
+}
+
+// Handler4 handles DHCPv4 packets for the ddns plugin
+func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	idType, id := clientID4(req)
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeRelease, dhcpv4.MessageTypeDecline:
+		p.unbind(string(id))
+		return resp, false
+	}
+	if resp.MessageType() != dhcpv4.MessageTypeAck ||
+		resp.YourIPAddr == nil || resp.YourIPAddr.IsUnspecified() {
+		return resp, false
+	}
+
+	var (
+		clientOpt *fqdn4
+		name      string
+		err       error
+	)
+	if data := req.Options.Get(dhcpv4.OptionFQDN); data != nil {
+		clientOpt, err = parseFQDN4(data)
+		if err != nil {
+			log.Warningf("Ignoring malformed Client FQDN option from %s: %v", req.ClientHWAddr, err)
+		}
+	}
+	forward := true
+	if clientOpt != nil {
+		if clientOpt.flags&fqdn.FlagN4 != 0 {
+			log.Debugf("Client %s asked for no DNS update", req.ClientHWAddr)
+			return resp, false
+		}
+		forward = clientOpt.flags&fqdn.FlagS != 0 || p.override
+	}
+
+	if data := resp.Options.Get(dhcpv4.OptionFQDN); data != nil {
+		if serverOpt, err := parseFQDN4(data); err == nil {
+			name = serverOpt.name
+			// Follow the decision already taken by an earlier plugin
+			forward = serverOpt.flags&fqdn.FlagS != 0
+			if serverOpt.flags&fqdn.FlagN4 != 0 {
+				return resp, false
+			}
+		}
+	} else if r, ok := fqdn.Lookup4(req.ClientHWAddr); ok && r.IP.Equal(resp.YourIPAddr) {
+		// Name decided by the fqdn plugin
+		name = r.Name
+		forward = r.ServerUpdate || p.override
+	} else if clientOpt != nil {
+		name = clientOpt.name
+	}
+	if name == "" {
+		name = req.HostName()
+	}
+	qualified := p.qualify(name)
+	if qualified == "" {
+		return resp, false
+	}
+
+	lease := resp.IPAddressLeaseTime(defaultLeaseTime)
+	b := &binding{
+		fqdn:    qualified,
+		ips:     []net.IP{resp.YourIPAddr.To4()},
+		dhcid:   computeDHCID(idType, id, qualified),
+		forward: forward,
+		ttl:     p.recordTTL(lease),
+	}
+	p.bind(string(id), b, lease)
+
+	if clientOpt != nil && !resp.Options.Has(dhcpv4.OptionFQDN) {
+		resp.UpdateOption(fqdnReply4(clientOpt, qualified, forward))
+	}
+	return resp, false
+}
+
+// fqdnReply4 builds the Client FQDN option sent back to the client, RFC 4702
+// section 3.2
+func fqdnReply4(clientOpt *fqdn4, qualified string, forward bool) dhcpv4.Option {
+	flags := clientOpt.flags & fqdn.FlagE
+	if forward {
+		flags |= fqdn.FlagS
+		if clientOpt.flags&fqdn.FlagS == 0 {
+			flags |= fqdn.FlagO
+		}
+	}
+	// RCODE1 and RCODE2 are deprecated, servers set them to 255
+	data := []byte{flags, 255, 255}
+	if flags&fqdn.FlagE != 0 {
+		data = append(data, (&rfc1035label.Labels{Labels: []string{strings.TrimSuffix(qualified, ".")}}).ToBytes()...)
+	} else {
+		data = append(data, []byte(qualified)...)
+	}
+	return dhcpv4.OptGeneric(dhcpv4.OptionFQDN, data)
+}
+
+// Handler6 handles DHCPv6 packets for the ddns plugin
+func (p *PluginState) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	msg, err := req.GetInnerMessage()
+	if err != nil {
+		log.Errorf("Could not decapsulate request: %v", err)
+		return nil, true
+	}
+	duid := msg.Options.ClientID()
+	if duid == nil {
+		return resp, false
+	}
+	key := string(duid.ToBytes())
+
+	switch msg.MessageType {
+	case dhcpv6.MessageTypeRelease, dhcpv6.MessageTypeDecline:
+		p.unbind(key)
+		return resp, false
+	case dhcpv6.MessageTypeSolicit, dhcpv6.MessageTypeRequest,
+		dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRebind:
+	default:
+		return resp, false
+	}
+	reply, ok := resp.(*dhcpv6.Message)
+	if !ok || reply.MessageType != dhcpv6.MessageTypeReply {
+		return resp, false
+	}
+
+	var (
+		ips   []net.IP
+		lease time.Duration
+	)
+	for _, iana := range reply.Options.IANA() {
+		for _, addr := range iana.Options.Addresses() {
+			ips = append(ips, addr.IPv6Addr)
+			if addr.ValidLifetime > lease {
+				lease = addr.ValidLifetime
+			}
+		}
+	}
+	if len(ips) == 0 {
+		return resp, false
+	}
+
+	clientOpt := msg.Options.FQDN()
+	forward := true
+	if clientOpt != nil {
+		if clientOpt.Flags&fqdn.FlagN6 != 0 {
+			log.Debugf("Client %s asked for no DNS update", duid)
+			return resp, false
+		}
+		forward = clientOpt.Flags&fqdn.FlagS != 0 || p.override
+	}
+	var name string
+	if serverOpt := reply.Options.FQDN(); serverOpt != nil {
+		if serverOpt.Flags&fqdn.FlagN6 != 0 {
+			return resp, false
+		}
+		forward = serverOpt.Flags&fqdn.FlagS != 0
+		if serverOpt.DomainName != nil && len(serverOpt.DomainName.Labels) > 0 {
+			name = serverOpt.DomainName.Labels[0] + "."
+		}
+	} else if r, ok := fqdn.Lookup6(duid); ok && r.IP.Equal(ips[0]) {
+		// Name decided by the fqdn plugin
+		name = r.Name
+		forward = r.ServerUpdate || p.override
+	} else if clientOpt != nil && clientOpt.DomainName != nil && len(clientOpt.DomainName.Labels) > 0 {
+		name = clientOpt.DomainName.Labels[0] + "."
+	}
+	qualified := p.qualify(name)
+	if qualified == "" {
+		return resp, false
+	}
+
+	b := &binding{
+		fqdn:    qualified,
+		ips:     ips,
+		dhcid:   computeDHCID(dhcidTypeDUID, duid.ToBytes(), qualified),
+		forward: forward,
+		ttl:     p.recordTTL(lease),
+	}
+	p.bind(key, b, lease)
+
+	if clientOpt != nil && reply.Options.FQDN() == nil {
+		var flags uint8
+		if forward {
+			flags |= fqdn.FlagS
+			if clientOpt.Flags&fqdn.FlagS == 0 {
+				flags |= fqdn.FlagO
+			}
+		}
+		reply.AddOption(&dhcpv6.OptFQDN{
+			Flags:      flags,
+			DomainName: &rfc1035label.Labels{Labels: []string{strings.TrimSuffix(qualified, ".")}},
+		})
+	}
+	return resp, false
+}
+
+// parseKeyValues splits `key=value` arguments into a map
+func parseKeyValues(args []string) (map[string]string, error) {
+	kv := make(map[string]string, len(args))
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("expected key=value argument, got `%s`", arg)
+		}
+		kv[parts[0]] = parts[1]
+	}
+	return kv, nil
+}
+
+func setup(args ...string) (*PluginState, error) {
+	kv, err := parseKeyValues(args)
+	if err != nil {
+		return nil, err
+	}
+	server := kv["server"]
+	if server == "" {
+		return nil, errors.New("need a DNS server address")
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	zone := kv["zone"]
+	if zone == "" {
+		return nil, errors.New("need a zone to update")
+	}
+	if _, ok := dns.IsDomainName(zone); !ok {
+		return nil, fmt.Errorf("invalid zone name: %s", zone)
+	}
+	reverse := kv["reverse"]
+	if reverse != "" {
+		if _, ok := dns.IsDomainName(reverse); !ok {
+			return nil, fmt.Errorf("invalid reverse zone name: %s", reverse)
+		}
+		reverse = dns.Fqdn(strings.ToLower(reverse))
+	}
+	timeout := 5 * time.Second
+	if t, ok := kv["timeout"]; ok {
+		if timeout, err = time.ParseDuration(t); err != nil {
+			return nil, fmt.Errorf("invalid timeout: %v", err)
+		}
+	}
+
+	p := PluginState{
+		updater:  newUpdater(server, strings.ToLower(zone), reverse, timeout),
+		bindings: make(map[string]*binding),
+		queue:    make(chan func(), 128),
+	}
+	if t, ok := kv["ttl"]; ok {
+		if p.ttl, err = time.ParseDuration(t); err != nil {
+			return nil, fmt.Errorf("invalid ttl: %v", err)
+		}
+	}
+	if o, ok := kv["override"]; ok {
+		if p.override, err = strconv.ParseBool(o); err != nil {
+			return nil, fmt.Errorf("invalid value for override: %v", err)
+		}
+	}
+	if key, ok := kv["tsig"]; ok {
+		parts := strings.Split(key, ":")
+		switch len(parts) {
+		case 2:
+			parts = append([]string{dns.HmacSHA256}, parts...)
+		case 3:
+		default:
+			return nil, fmt.Errorf("invalid TSIG key, want [alg:]name:secret, got %s", key)
+		}
+		alg := dns.Fqdn(strings.ToLower(parts[0]))
+		switch alg {
+		case dns.HmacMD5, dns.HmacSHA1, dns.HmacSHA256, dns.HmacSHA512:
+		case "hmac-md5.":
+			alg = dns.HmacMD5
+		default:
+			return nil, fmt.Errorf("unsupported TSIG algorithm %s", parts[0])
+		}
+		p.updater.setKey(parts[1], alg, parts[2])
+	}
+
+	go p.worker()
+	log.Printf("loaded ddns plugin, updating zone %s on %s", p.updater.zone, server)
+	return &p, nil
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	p, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler6, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	p, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
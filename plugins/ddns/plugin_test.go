@@ -0,0 +1,537 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package ddns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/file"
+	"github.com/coredhcp/coredhcp/plugins/fqdn"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testKeyName = "dhcp-key."
+	testSecret  = "c2VjcmV0LWtleS1mb3ItdGVzdGluZw=="
+)
+
+// testZone is a minimal in-memory authoritative server that implements enough
+// of RFC 2136 to exercise the plugin
+type testZone struct {
+	sync.Mutex
+	rrs []dns.RR
+	// if true, only accept updates signed with the test key
+	requireTSIG bool
+}
+
+func sameRdata(a, b dns.RR) bool {
+	a, b = dns.Copy(a), dns.Copy(b)
+	for _, h := range []*dns.RR_Header{a.Header(), b.Header()} {
+		h.Class = dns.ClassINET
+		h.Ttl = 0
+		h.Name = strings.ToLower(h.Name)
+	}
+	return a.String() == b.String()
+}
+
+func (z *testZone) find(name string, rrtype uint16) []dns.RR {
+	var ret []dns.RR
+	for _, rr := range z.rrs {
+		h := rr.Header()
+		if strings.EqualFold(h.Name, name) && (rrtype == dns.TypeANY || h.Rrtype == rrtype) {
+			ret = append(ret, rr)
+		}
+	}
+	return ret
+}
+
+func (z *testZone) delete(match func(dns.RR) bool) {
+	kept := z.rrs[:0]
+	for _, rr := range z.rrs {
+		if !match(rr) {
+			kept = append(kept, rr)
+		}
+	}
+	z.rrs = kept
+}
+
+// Lookup returns the records of the given name and type
+func (z *testZone) Lookup(name string, rrtype uint16) []dns.RR {
+	z.Lock()
+	defer z.Unlock()
+	return z.find(name, rrtype)
+}
+
+func (z *testZone) checkPrereqs(prereqs []dns.RR) int {
+	for _, rr := range prereqs {
+		h := rr.Header()
+		existing := z.find(h.Name, h.Rrtype)
+		switch h.Class {
+		case dns.ClassANY:
+			if len(existing) == 0 {
+				if h.Rrtype == dns.TypeANY {
+					return dns.RcodeNameError
+				}
+				return dns.RcodeNXRrset
+			}
+		case dns.ClassNONE:
+			if len(existing) != 0 {
+				if h.Rrtype == dns.TypeANY {
+					return dns.RcodeYXDomain
+				}
+				return dns.RcodeYXRrset
+			}
+		default:
+			found := false
+			for _, e := range existing {
+				if sameRdata(e, rr) {
+					found = true
+				}
+			}
+			if !found {
+				return dns.RcodeNXRrset
+			}
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+func (z *testZone) apply(updates []dns.RR) {
+	for _, rr := range updates {
+		h := rr.Header()
+		switch h.Class {
+		case dns.ClassANY:
+			z.delete(func(e dns.RR) bool {
+				return strings.EqualFold(e.Header().Name, h.Name) &&
+					(h.Rrtype == dns.TypeANY || e.Header().Rrtype == h.Rrtype)
+			})
+		case dns.ClassNONE:
+			z.delete(func(e dns.RR) bool { return sameRdata(e, rr) })
+		default:
+			z.delete(func(e dns.RR) bool { return sameRdata(e, rr) })
+			z.rrs = append(z.rrs, rr)
+		}
+	}
+}
+
+func (z *testZone) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	z.Lock()
+	defer z.Unlock()
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	switch {
+	case r.Opcode != dns.OpcodeUpdate:
+		m.Rcode = dns.RcodeRefused
+	case z.requireTSIG && (r.IsTsig() == nil || w.TsigStatus() != nil):
+		m.Rcode = dns.RcodeNotAuth
+	default:
+		m.Rcode = z.checkPrereqs(r.Answer)
+		if m.Rcode == dns.RcodeSuccess {
+			z.apply(r.Ns)
+		}
+	}
+	if t := r.IsTsig(); t != nil && w.TsigStatus() == nil {
+		m.SetTsig(t.Hdr.Name, t.Algorithm, t.Fudge, time.Now().Unix())
+	}
+	_ = w.WriteMsg(m)
+}
+
+// startServer runs a DNS server for the zone on a random local port, and
+// returns its address
+func startServer(t *testing.T, z *testZone) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	started := make(chan struct{})
+	srv := &dns.Server{
+		PacketConn:        pc,
+		Handler:           z,
+		TsigSecret:        map[string]string{testKeyName: testSecret},
+		NotifyStartedFunc: func() { close(started) },
+		// The default function rejects everything but queries
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+	}
+	go func() { _ = srv.ActivateAndServe() }()
+	<-started
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func newTestPlugin(t *testing.T, extra ...string) (*PluginState, *testZone) {
+	z := &testZone{requireTSIG: true}
+	addr := startServer(t, z)
+	args := append([]string{
+		"server=" + addr,
+		"zone=example.com.",
+		"reverse=2.0.192.in-addr.arpa.",
+		"tsig=hmac-sha256:" + testKeyName + ":" + testSecret,
+		"timeout=1s",
+	}, extra...)
+	p, err := setup(args...)
+	require.NoError(t, err)
+	return p, z
+}
+
+// flush waits for all the queued updates to be sent
+func flush(p *PluginState) {
+	done := make(chan struct{})
+	p.queue <- func() { close(done) }
+	<-done
+}
+
+func ack4(t *testing.T, mac net.HardwareAddr, ip net.IP, modifiers ...dhcpv4.Modifier) (*dhcpv4.DHCPv4, *dhcpv4.DHCPv4) {
+	req, err := dhcpv4.NewDiscovery(mac, modifiers...)
+	require.NoError(t, err)
+	req.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeRequest))
+	resp, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithYourIP(ip),
+		dhcpv4.WithLeaseTime(3600),
+	)
+	require.NoError(t, err)
+	return req, resp
+}
+
+func TestComputeDHCID(t *testing.T) {
+	// Examples from RFC 4701 section 3.6
+	duid := []byte{0x00, 0x01, 0x00, 0x06, 0x41, 0x2d, 0xf1, 0x66, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06}
+	assert.Equal(t, "AAIBY2/AuCccgoJbsaxcQc9TUapptP69lOjxfNuVAA2kjEA=",
+		computeDHCID(dhcidTypeDUID, duid, "chi6.example.com."))
+
+	chaddr := []byte{0x01, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06}
+	assert.Equal(t, "AAABxLmlskllE0MVjd57zHcWmEH3pCQ6VytcKD//7es/deY=",
+		computeDHCID(dhcidTypeChaddr, chaddr, "client.example.com."))
+
+	clientID := []byte{0x01, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c}
+	assert.Equal(t, "AAEBOSD+XR3Os/0LozeXVqcNc7FwCfQdWL3b/NaiUDlW2No=",
+		computeDHCID(dhcidTypeClientID, clientID, "chi.example.com"))
+}
+
+func TestQualify(t *testing.T) {
+	p := PluginState{updater: newUpdater("", "example.com", "", time.Second)}
+	assert.Equal(t, "host.example.com.", p.qualify("Host"))
+	assert.Equal(t, "host.example.com.", p.qualify("host.example.com"))
+	assert.Equal(t, "host.sub.example.com.", p.qualify("host.sub"))
+	assert.Equal(t, "host.example.com.", p.qualify("host.example.org."))
+	assert.Equal(t, "", p.qualify(""))
+	assert.Equal(t, "", p.qualify("example.com."))
+}
+
+func TestSetupErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"zone=example.com."},
+		{"server=127.0.0.1:53"},
+		{"server=127.0.0.1:53", "zone=example.com.", "tsig=onlyone"},
+		{"server=127.0.0.1:53", "zone=example.com.", "tsig=hmac-foo:name:secret"},
+		{"server=127.0.0.1:53", "zone=example.com.", "ttl=forever"},
+		{"server=127.0.0.1:53", "zone=example.com.", "override=maybe"},
+		{"server=127.0.0.1:53", "example.com."},
+	} {
+		_, err := setup(args...)
+		assert.Error(t, err, "args: %v", args)
+	}
+}
+
+func TestAddRemove4(t *testing.T) {
+	p, z := newTestPlugin(t)
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	ip := net.IPv4(192, 0, 2, 10)
+
+	req, resp := ack4(t, mac, ip, dhcpv4.WithOption(dhcpv4.OptHostName("host1")))
+	result, stop := p.Handler4(req, resp)
+	require.NotNil(t, result)
+	assert.False(t, stop)
+	flush(p)
+
+	a := z.Lookup("host1.example.com.", dns.TypeA)
+	require.Len(t, a, 1)
+	assert.True(t, a[0].(*dns.A).A.Equal(ip))
+	assert.Equal(t, uint32(1200), a[0].Header().Ttl)
+	assert.Len(t, z.Lookup("host1.example.com.", dns.TypeDHCID), 1)
+	ptr := z.Lookup("10.2.0.192.in-addr.arpa.", dns.TypePTR)
+	require.Len(t, ptr, 1)
+	assert.Equal(t, "host1.example.com.", ptr[0].(*dns.PTR).Ptr)
+
+	// Renewing doesn't change anything
+	_, _ = p.Handler4(req, resp)
+	flush(p)
+	assert.Len(t, z.Lookup("host1.example.com.", dns.TypeA), 1)
+
+	p.unbind(string(append([]byte{byte(iana.HWTypeEthernet)}, mac...)))
+	flush(p)
+	assert.Empty(t, z.Lookup("host1.example.com.", dns.TypeANY))
+	assert.Empty(t, z.Lookup("10.2.0.192.in-addr.arpa.", dns.TypeANY))
+}
+
+func TestRelease4(t *testing.T) {
+	p, z := newTestPlugin(t)
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	cid := dhcpv4.OptClientIdentifier([]byte{0xff, 1, 2, 3})
+	req, resp := ack4(t, mac, net.IPv4(192, 0, 2, 10),
+		dhcpv4.WithOption(dhcpv4.OptHostName("host1")), dhcpv4.WithOption(cid))
+	_, _ = p.Handler4(req, resp)
+	flush(p)
+	require.Len(t, z.Lookup("host1.example.com.", dns.TypeA), 1)
+	require.Len(t, z.Lookup("10.2.0.192.in-addr.arpa.", dns.TypePTR), 1)
+
+	// The release is matched on the client identifier, like the lease
+	release, err := dhcpv4.New(
+		dhcpv4.WithHwAddr(mac),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRelease),
+		dhcpv4.WithClientIP(net.IPv4(192, 0, 2, 10)),
+		dhcpv4.WithOption(cid),
+	)
+	require.NoError(t, err)
+	stub, err := dhcpv4.NewReplyFromRequest(release)
+	require.NoError(t, err)
+	result, stop := p.Handler4(release, stub)
+	assert.NotNil(t, result)
+	assert.False(t, stop)
+	flush(p)
+	assert.Empty(t, z.Lookup("host1.example.com.", dns.TypeANY))
+	assert.Empty(t, z.Lookup("10.2.0.192.in-addr.arpa.", dns.TypeANY))
+}
+
+func TestExpiry4(t *testing.T) {
+	p, z := newTestPlugin(t)
+	req, resp := ack4(t, net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, net.IPv4(192, 0, 2, 10),
+		dhcpv4.WithOption(dhcpv4.OptHostName("host1")))
+	resp.UpdateOption(dhcpv4.OptIPAddressLeaseTime(100 * time.Millisecond))
+	_, _ = p.Handler4(req, resp)
+	flush(p)
+	require.Len(t, z.Lookup("host1.example.com.", dns.TypeA), 1)
+
+	assert.Eventually(t, func() bool {
+		flush(p)
+		return len(z.Lookup("host1.example.com.", dns.TypeANY)) == 0
+	}, 2*time.Second, 50*time.Millisecond)
+}
+
+func TestQueueFull(t *testing.T) {
+	// No worker: the queue never drains
+	p := &PluginState{
+		bindings: make(map[string]*binding),
+		queue:    make(chan func(), 1),
+	}
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			p.bind(fmt.Sprintf("client%d", i), &binding{fqdn: "host.example.com."}, time.Hour)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("bind blocked on a full queue")
+	}
+	assert.Len(t, p.queue, 1)
+	assert.Len(t, p.bindings, 3)
+}
+
+func TestConflict4(t *testing.T) {
+	p, z := newTestPlugin(t)
+
+	req, resp := ack4(t, net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, net.IPv4(192, 0, 2, 10),
+		dhcpv4.WithOption(dhcpv4.OptHostName("host")))
+	_, _ = p.Handler4(req, resp)
+	// Another client claims the same name
+	req, resp = ack4(t, net.HardwareAddr{0x02, 0, 0, 0, 0, 2}, net.IPv4(192, 0, 2, 11),
+		dhcpv4.WithOption(dhcpv4.OptHostName("host")))
+	_, _ = p.Handler4(req, resp)
+	flush(p)
+
+	a := z.Lookup("host.example.com.", dns.TypeA)
+	require.Len(t, a, 1)
+	assert.True(t, a[0].(*dns.A).A.Equal(net.IPv4(192, 0, 2, 10)))
+	assert.Empty(t, z.Lookup("11.2.0.192.in-addr.arpa.", dns.TypePTR))
+
+	// The second client going away must not remove the first one's records
+	p.unbind(string([]byte{byte(iana.HWTypeEthernet), 0x02, 0, 0, 0, 0, 2}))
+	flush(p)
+	assert.Len(t, z.Lookup("host.example.com.", dns.TypeA), 1)
+}
+
+func TestClientFQDN4(t *testing.T) {
+	p, z := newTestPlugin(t)
+
+	// The client does the forward update itself, in ASCII encoding
+	opt := dhcpv4.OptGeneric(dhcpv4.OptionFQDN, append([]byte{0, 0, 0}, []byte("laptop")...))
+	req, resp := ack4(t, net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, net.IPv4(192, 0, 2, 10),
+		dhcpv4.WithOption(opt))
+	result, _ := p.Handler4(req, resp)
+	flush(p)
+
+	assert.Empty(t, z.Lookup("laptop.example.com.", dns.TypeA))
+	assert.Len(t, z.Lookup("10.2.0.192.in-addr.arpa.", dns.TypePTR), 1)
+	reply, err := parseFQDN4(result.Options.Get(dhcpv4.OptionFQDN))
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0), reply.flags)
+	assert.Equal(t, "laptop.example.com.", reply.name)
+
+	// The client asks for no update at all
+	opt = dhcpv4.OptGeneric(dhcpv4.OptionFQDN, append([]byte{fqdn.FlagN4, 0, 0}, []byte("desktop")...))
+	req, resp = ack4(t, net.HardwareAddr{0x02, 0, 0, 0, 0, 2}, net.IPv4(192, 0, 2, 11),
+		dhcpv4.WithOption(opt))
+	_, _ = p.Handler4(req, resp)
+	flush(p)
+	assert.Empty(t, z.Lookup("11.2.0.192.in-addr.arpa.", dns.TypePTR))
+}
+
+func TestFQDNPlugin4(t *testing.T) {
+	p, z := newTestPlugin(t)
+	names, err := fqdn.Plugin.Setup4("domain=example.com", "template=host-{ip-dashes}")
+	require.NoError(t, err)
+
+	// The name is generated by the fqdn plugin, found in its lease record
+	req, resp := ack4(t, net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, net.IPv4(192, 0, 2, 20))
+	resp, _ = names(req, resp)
+	_, _ = p.Handler4(req, resp)
+	flush(p)
+
+	assert.Len(t, z.Lookup("host-192-0-2-20.example.com.", dns.TypeA), 1)
+	assert.Len(t, z.Lookup("20.2.0.192.in-addr.arpa.", dns.TypePTR), 1)
+}
+
+func TestOverride4(t *testing.T) {
+	p, z := newTestPlugin(t, "override=true")
+
+	name := (&rfc1035label.Labels{Labels: []string{"laptop.example.com"}}).ToBytes()
+	opt := dhcpv4.OptGeneric(dhcpv4.OptionFQDN, append([]byte{fqdn.FlagE, 0, 0}, name...))
+	req, resp := ack4(t, net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, net.IPv4(192, 0, 2, 10),
+		dhcpv4.WithOption(opt))
+	result, _ := p.Handler4(req, resp)
+	flush(p)
+
+	assert.Len(t, z.Lookup("laptop.example.com.", dns.TypeA), 1)
+	reply, err := parseFQDN4(result.Options.Get(dhcpv4.OptionFQDN))
+	require.NoError(t, err)
+	assert.Equal(t, uint8(fqdn.FlagS|fqdn.FlagO|fqdn.FlagE), reply.flags)
+	assert.Equal(t, "laptop.example.com.", reply.name)
+}
+
+func TestBadKey(t *testing.T) {
+	z := &testZone{requireTSIG: true}
+	addr := startServer(t, z)
+	p, err := setup("server="+addr, "zone=example.com.", "timeout=1s",
+		"tsig="+testKeyName+":"+"d3Jvbmcgc2VjcmV0")
+	require.NoError(t, err)
+
+	req, resp := ack4(t, net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, net.IPv4(192, 0, 2, 10),
+		dhcpv4.WithOption(dhcpv4.OptHostName("host1")))
+	_, _ = p.Handler4(req, resp)
+	flush(p)
+	assert.Empty(t, z.Lookup("host1.example.com.", dns.TypeANY))
+}
+
+func TestAddRemove6(t *testing.T) {
+	z := &testZone{requireTSIG: true}
+	addr := startServer(t, z)
+	p, err := setup("server="+addr, "zone=example.com.", "reverse=8.b.d.0.1.0.0.2.ip6.arpa.",
+		"tsig=hmac-sha256:"+testKeyName+":"+testSecret, "timeout=1s")
+	require.NoError(t, err)
+
+	duid := dhcpv6.Duid{
+		Type:          dhcpv6.DUID_LL,
+		HwType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+	}
+	req, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeRequest
+	req.AddOption(dhcpv6.OptClientID(duid))
+	req.AddOption(&dhcpv6.OptFQDN{
+		Flags:      fqdn.FlagS,
+		DomainName: &rfc1035label.Labels{Labels: []string{"host6"}},
+	})
+	resp, err := dhcpv6.NewReplyFromMessage(req)
+	require.NoError(t, err)
+	ip := net.ParseIP("2001:db8::10")
+	resp.AddOption(&dhcpv6.OptIANA{
+		Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+			&dhcpv6.OptIAAddress{IPv6Addr: ip, PreferredLifetime: time.Hour, ValidLifetime: time.Hour},
+		}},
+	})
+
+	result, stop := p.Handler6(req, resp)
+	require.NotNil(t, result)
+	assert.False(t, stop)
+	flush(p)
+
+	aaaa := z.Lookup("host6.example.com.", dns.TypeAAAA)
+	require.Len(t, aaaa, 1)
+	assert.True(t, aaaa[0].(*dns.AAAA).AAAA.Equal(ip))
+	arpa, _ := dns.ReverseAddr(ip.String())
+	assert.Len(t, z.Lookup(arpa, dns.TypePTR), 1)
+	reply := result.(*dhcpv6.Message).Options.FQDN()
+	require.NotNil(t, reply)
+	assert.Equal(t, uint8(fqdn.FlagS), reply.Flags)
+
+	release, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	release.MessageType = dhcpv6.MessageTypeRelease
+	release.AddOption(dhcpv6.OptClientID(duid))
+	releaseResp, err := dhcpv6.NewReplyFromMessage(release)
+	require.NoError(t, err)
+	_, _ = p.Handler6(release, releaseResp)
+	flush(p)
+	assert.Empty(t, z.Lookup("host6.example.com.", dns.TypeANY))
+	assert.Empty(t, z.Lookup(arpa, dns.TypeANY))
+}
+
+func TestReserved6(t *testing.T) {
+	z := &testZone{requireTSIG: true}
+	addr := startServer(t, z)
+	p, err := setup("server="+addr, "zone=example.com.", "reverse=8.b.d.0.1.0.0.2.ip6.arpa.",
+		"tsig=hmac-sha256:"+testKeyName+":"+testSecret, "timeout=1s")
+	require.NoError(t, err)
+
+	duid := dhcpv6.Duid{
+		Type:          dhcpv6.DUID_LL,
+		HwType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+	}
+	ip := net.ParseIP("2001:db8::20")
+	file.DHCPv6Records = file.NewRecords([]*file.Reservation{{
+		Match:     file.Identifiers{file.IDDUID: duid.ToBytes()},
+		Addresses: []net.IP{ip},
+		Hostname:  "printer",
+	}})
+	defer func() { file.DHCPv6Records = nil }()
+
+	req, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeRequest
+	req.AddOption(dhcpv6.OptClientID(duid))
+	req.AddOption(&dhcpv6.OptIANA{IaId: [4]byte{1, 2, 3, 4}})
+	req.AddOption(&dhcpv6.OptFQDN{DomainName: &rfc1035label.Labels{Labels: []string{"client"}}})
+	resp, err := dhcpv6.NewReplyFromMessage(req)
+	require.NoError(t, err)
+
+	// The reserved name given by the file plugin is registered
+	result, _ := file.Handler6(req, resp)
+	result, _ = p.Handler6(req, result)
+	require.NotNil(t, result)
+	flush(p)
+
+	aaaa := z.Lookup("printer.example.com.", dns.TypeAAAA)
+	require.Len(t, aaaa, 1)
+	assert.True(t, aaaa[0].(*dns.AAAA).AAAA.Equal(ip))
+	assert.Empty(t, z.Lookup("client.example.com.", dns.TypeANY))
+	arpa, _ := dns.ReverseAddr(ip.String())
+	assert.Len(t, z.Lookup(arpa, dns.TypePTR), 1)
+}
@@ -0,0 +1,245 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package ddns
+
+// This file implements the DNS side of the plugin: building RFC 2136 UPDATE
+// messages following the conflict resolution procedure of RFC 4703, and
+// sending them to the authoritative server, signed with TSIG if configured.
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// tsigFudge is the allowed clock skew for TSIG-signed messages, in seconds.
+// 300 is the value recommended by RFC 8945
+const tsigFudge = 300
+
+// errConflict is returned when a name is already in use by another client, as
+// determined by a non-matching DHCID RR
+var errConflict = errors.New("name is in use by another client")
+
+// updater sends dynamic updates to a single authoritative server
+type updater struct {
+	server      string
+	zone        string
+	reverseZone string
+
+	// TSIG key name, algorithm and base64-encoded secret. keyName is empty
+	// when updates are not signed
+	keyName   string
+	algorithm string
+	secret    string
+
+	client *dns.Client
+}
+
+func newUpdater(server, zone, reverseZone string, timeout time.Duration) *updater {
+	return &updater{
+		server:      server,
+		zone:        dns.Fqdn(zone),
+		reverseZone: reverseZone,
+		client:      &dns.Client{Net: "udp", Timeout: timeout},
+	}
+}
+
+// setKey configures TSIG signing of all the updates
+func (u *updater) setKey(name, algorithm, secret string) {
+	u.keyName = dns.Fqdn(name)
+	u.algorithm = dns.Fqdn(algorithm)
+	u.secret = secret
+	u.client.TsigSecret = map[string]string{u.keyName: secret}
+}
+
+// exchange signs and sends an update message, and returns the RCODE of the
+// answer
+func (u *updater) exchange(m *dns.Msg) (int, error) {
+	if u.keyName != "" {
+		m.SetTsig(u.keyName, u.algorithm, tsigFudge, time.Now().Unix())
+	}
+	r, _, err := u.client.Exchange(m, u.server)
+	if err != nil {
+		return 0, err
+	}
+	return r.Rcode, nil
+}
+
+func addressRR(name string, ip net.IP, ttl uint32) dns.RR {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+			A:   ip4,
+		}
+	}
+	return &dns.AAAA{
+		Hdr:  dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl},
+		AAAA: ip,
+	}
+}
+
+func dhcidRR(name, digest string, ttl uint32) dns.RR {
+	return &dns.DHCID{
+		Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeDHCID, Class: dns.ClassINET, Ttl: ttl},
+		Digest: digest,
+	}
+}
+
+// addForward adds the address records of a client, following RFC 4703
+// section 5.3.
+// The first attempt only succeeds if the name is unused; if it isn't, a second
+// attempt replaces the existing records only if the DHCID RR proves they belong
+// to the same client. Otherwise errConflict is returned.
+func (u *updater) addForward(fqdn string, ips []net.IP, dhcid string, ttl uint32) error {
+	if len(ips) == 0 {
+		return nil
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate(u.zone)
+	m.NameNotUsed([]dns.RR{dhcidRR(fqdn, dhcid, 0)})
+	for _, ip := range ips {
+		m.Insert([]dns.RR{addressRR(fqdn, ip, ttl)})
+	}
+	m.Insert([]dns.RR{dhcidRR(fqdn, dhcid, ttl)})
+	rcode, err := u.exchange(m)
+	if err != nil {
+		return err
+	}
+	switch rcode {
+	case dns.RcodeSuccess:
+		return nil
+	case dns.RcodeYXDomain:
+		// The name exists, check whether we own it
+	default:
+		return fmt.Errorf("adding %s failed: %s", fqdn, dns.RcodeToString[rcode])
+	}
+
+	m = new(dns.Msg)
+	m.SetUpdate(u.zone)
+	m.Used([]dns.RR{dhcidRR(fqdn, dhcid, 0)})
+	// Only replace the records of the same family as the addresses we're adding
+	m.RemoveRRset([]dns.RR{addressRR(fqdn, ips[0], 0)})
+	for _, ip := range ips {
+		m.Insert([]dns.RR{addressRR(fqdn, ip, ttl)})
+	}
+	rcode, err = u.exchange(m)
+	if err != nil {
+		return err
+	}
+	switch rcode {
+	case dns.RcodeSuccess:
+		return nil
+	case dns.RcodeNXRrset:
+		return errConflict
+	default:
+		return fmt.Errorf("replacing %s failed: %s", fqdn, dns.RcodeToString[rcode])
+	}
+}
+
+// removeForward removes the address records of a client, following RFC 4703
+// section 5.5: the addresses are only removed if the DHCID RR matches, and the
+// DHCID RR itself is only removed once no address records are left
+func (u *updater) removeForward(fqdn string, ips []net.IP, dhcid string) error {
+	m := new(dns.Msg)
+	m.SetUpdate(u.zone)
+	m.Used([]dns.RR{dhcidRR(fqdn, dhcid, 0)})
+	for _, ip := range ips {
+		m.Remove([]dns.RR{addressRR(fqdn, ip, 0)})
+	}
+	rcode, err := u.exchange(m)
+	if err != nil {
+		return err
+	}
+	switch rcode {
+	case dns.RcodeSuccess:
+	case dns.RcodeNXRrset:
+		// Someone else owns the name by now, leave it alone
+		return errConflict
+	default:
+		return fmt.Errorf("removing %s failed: %s", fqdn, dns.RcodeToString[rcode])
+	}
+
+	m = new(dns.Msg)
+	m.SetUpdate(u.zone)
+	m.Used([]dns.RR{dhcidRR(fqdn, dhcid, 0)})
+	m.RRsetNotUsed([]dns.RR{
+		addressRR(fqdn, net.IPv4zero, 0),
+		addressRR(fqdn, net.IPv6zero, 0),
+	})
+	m.RemoveRRset([]dns.RR{dhcidRR(fqdn, dhcid, 0)})
+	rcode, err = u.exchange(m)
+	if err != nil {
+		return err
+	}
+	// YXRRSET means addresses of the other family are still there, which is fine
+	if rcode != dns.RcodeSuccess && rcode != dns.RcodeYXRrset && rcode != dns.RcodeNXRrset {
+		return fmt.Errorf("removing DHCID of %s failed: %s", fqdn, dns.RcodeToString[rcode])
+	}
+	return nil
+}
+
+// reverseName returns the PTR name for the given IP, or an empty string if it
+// isn't covered by the configured reverse zone
+func (u *updater) reverseName(ip net.IP) string {
+	if u.reverseZone == "" {
+		return ""
+	}
+	arpa, err := dns.ReverseAddr(ip.String())
+	if err != nil || !dns.IsSubDomain(u.reverseZone, arpa) {
+		return ""
+	}
+	return arpa
+}
+
+// addReverse replaces the PTR record of an address, RFC 4703 section 5.4
+func (u *updater) addReverse(fqdn string, ip net.IP, ttl uint32) error {
+	arpa := u.reverseName(ip)
+	if arpa == "" {
+		return nil
+	}
+	ptr := &dns.PTR{
+		Hdr: dns.RR_Header{Name: arpa, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl},
+		Ptr: fqdn,
+	}
+	m := new(dns.Msg)
+	m.SetUpdate(u.reverseZone)
+	m.RemoveRRset([]dns.RR{ptr})
+	m.Insert([]dns.RR{ptr})
+	rcode, err := u.exchange(m)
+	if err != nil {
+		return err
+	}
+	if rcode != dns.RcodeSuccess {
+		return fmt.Errorf("adding PTR for %s failed: %s", ip, dns.RcodeToString[rcode])
+	}
+	return nil
+}
+
+// removeReverse removes the PTR record of an address, if it still points to
+// the given name
+func (u *updater) removeReverse(fqdn string, ip net.IP) error {
+	arpa := u.reverseName(ip)
+	if arpa == "" {
+		return nil
+	}
+	m := new(dns.Msg)
+	m.SetUpdate(u.reverseZone)
+	m.Remove([]dns.RR{&dns.PTR{
+		Hdr: dns.RR_Header{Name: arpa, Rrtype: dns.TypePTR, Class: dns.ClassINET},
+		Ptr: fqdn,
+	}})
+	rcode, err := u.exchange(m)
+	if err != nil {
+		return err
+	}
+	if rcode != dns.RcodeSuccess {
+		return fmt.Errorf("removing PTR for %s failed: %s", ip, dns.RcodeToString[rcode])
+	}
+	return nil
+}
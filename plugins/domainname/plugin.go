@@ -0,0 +1,72 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package domainname
+
+// This plugin sets the domain name that clients should use when resolving
+// host names (option 15). For the search list, see the searchdomains plugin.
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+var log = logger.GetLogger("plugins/domain_name")
+
+// Plugin wraps the domain name plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "domain_name",
+	Setup4: setup4,
+}
+
+var (
+	domainName string
+)
+
+// validDomain checks the syntax of a domain name, as in RFC 1035 section 2.3.1
+// relaxed by RFC 1123 to allow leading digits
+func validDomain(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 ||
+			label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	log.Printf("loaded plugin for DHCPv4.")
+	if len(args) != 1 {
+		return nil, errors.New("need exactly one domain name")
+	}
+	name := strings.TrimSuffix(args[0], ".")
+	if !validDomain(name) {
+		return nil, errors.New("expected a domain name, got: " + args[0])
+	}
+	domainName = name
+	log.Infof("loaded domain name %s.", domainName)
+	return Handler4, nil
+}
+
+// Handler4 handles DHCPv4 packets for the domain_name plugin
+func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	if req.IsOptionRequested(dhcpv4.OptionDomainName) {
+		resp.Options.Update(dhcpv4.OptDomainName(domainName))
+	}
+	return resp, false
+}
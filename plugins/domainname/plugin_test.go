@@ -0,0 +1,79 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package domainname
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestSetup4(t *testing.T) {
+	for _, arg := range []string{"", "-example.com", "exam_ple.com", "example..com", "a.b-"} {
+		if _, err := setup4(arg); err == nil {
+			t.Errorf("setup succeeded with invalid domain %q", arg)
+		}
+	}
+	if _, err := setup4("example.com", "example.org"); err == nil {
+		t.Error("setup succeeded with two domains")
+	}
+	if _, err := setup4("corp.example.com."); err != nil {
+		t.Fatal(err)
+	}
+	if domainName != "corp.example.com" {
+		t.Errorf("Loaded domain %s, expected corp.example.com", domainName)
+	}
+}
+
+func TestAddDomainName4(t *testing.T) {
+	// NewDiscovery requests the domain name by default
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domainName = "example.com"
+
+	resp, stop := Handler4(req, stub)
+	if resp == nil {
+		t.Fatal("plugin did not return a message")
+	}
+	if stop {
+		t.Error("plugin interrupted processing")
+	}
+	if name := resp.DomainName(); name != domainName {
+		t.Errorf("Found domain name %q, expected %q", name, domainName)
+	}
+}
+
+func TestNotRequested4(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domainName = "example.com"
+	req.UpdateOption(dhcpv4.OptParameterRequestList(dhcpv4.OptionBroadcastAddress))
+
+	resp, stop := Handler4(req, stub)
+	if resp == nil {
+		t.Fatal("plugin did not return a message")
+	}
+	if stop {
+		t.Error("plugin interrupted processing")
+	}
+	if resp.Options.Has(dhcpv4.OptionDomainName) {
+		t.Error("Domain name was added when not requested")
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package file
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/options"
+	"gopkg.in/yaml.v2"
+)
+
+// hostEntry is a host in a structured reservation file
+type hostEntry struct {
+	MAC          string   `yaml:"mac"`
+	DUID         string   `yaml:"duid"`
+	ClientID     string   `yaml:"client-id"`
+	CircuitID    string   `yaml:"circuit-id"`
+	RemoteID     string   `yaml:"remote-id"`
+	SubscriberID string   `yaml:"subscriber-id"`
+	Address      string   `yaml:"address"`
+	Addresses    []string `yaml:"addresses"`
+	Prefixes     []string `yaml:"prefixes"`
+	Hostname     string   `yaml:"hostname"`
+	BootFile     string   `yaml:"boot-file"`
+	LeaseTime    string   `yaml:"lease-time"`
+	Options      []string `yaml:"options"`
+}
+
+// hostFile is the content of a structured reservation file
+type hostFile struct {
+	Hosts []hostEntry `yaml:"hosts"`
+}
+
+// structured returns whether a reservation file is in the structured format,
+// based on its extension. JSON is parsed as YAML, which it is a subset of
+func structured(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yml", ".yaml", ".json":
+		return true
+	}
+	return false
+}
+
+// reservation converts a host entry into a reservation
+func (h *hostEntry) reservation(fam family) (*Reservation, error) {
+	res := &Reservation{
+		Match:    make(Identifiers),
+		Hostname: h.Hostname,
+		BootFile: h.BootFile,
+	}
+	for _, id := range []struct{ kind, value string }{
+		{IDMAC, h.MAC},
+		{IDDUID, h.DUID},
+		{IDClientID, h.ClientID},
+		{IDCircuitID, h.CircuitID},
+		{IDRemoteID, h.RemoteID},
+		{IDSubscriberID, h.SubscriberID},
+	} {
+		if id.value == "" {
+			continue
+		}
+		value, err := parseIdentifier(id.kind, id.value)
+		if err != nil {
+			return nil, err
+		}
+		res.Match[id.kind] = value
+	}
+	if len(res.Match) == 0 {
+		return nil, errors.New("no client identifier")
+	}
+
+	addresses := h.Addresses
+	if h.Address != "" {
+		addresses = append([]string{h.Address}, addresses...)
+	}
+	for _, addr := range addresses {
+		ip := net.ParseIP(addr)
+		if !fam.validIP(ip) {
+			return nil, fmt.Errorf("expected an %s address, got: %v", fam, addr)
+		}
+		res.Addresses = append(res.Addresses, ip)
+	}
+	if fam == family4 && len(res.Addresses) != 1 {
+		return nil, fmt.Errorf("expected one IPv4 address, got %d", len(res.Addresses))
+	}
+	if fam == family6 {
+		for _, p := range h.Prefixes {
+			_, prefix, err := net.ParseCIDR(p)
+			if err != nil || prefix.IP.To4() != nil {
+				return nil, fmt.Errorf("expected an IPv6 prefix, got: %v", p)
+			}
+			res.Prefixes = append(res.Prefixes, prefix)
+		}
+	} else if len(h.Prefixes) > 0 {
+		return nil, errors.New("prefixes can only be delegated with DHCPv6")
+	}
+	if len(res.Addresses) == 0 && len(res.Prefixes) == 0 {
+		return nil, errors.New("no address or prefix")
+	}
+
+	if h.LeaseTime != "" {
+		lt, err := time.ParseDuration(h.LeaseTime)
+		if err != nil || lt <= 0 {
+			return nil, fmt.Errorf("invalid lease time: %v", h.LeaseTime)
+		}
+		res.LeaseTime = lt
+	}
+	for _, o := range h.Options {
+		if fam == family4 {
+			opt, err := options.Parse4(o)
+			if err != nil {
+				return nil, err
+			}
+			res.Options4 = append(res.Options4, opt)
+		} else {
+			opt, err := options.Parse6(o)
+			if err != nil {
+				return nil, err
+			}
+			res.Options6 = append(res.Options6, opt)
+		}
+	}
+	return res, nil
+}
+
+// parseHostFile parses a structured reservation file
+func parseHostFile(data []byte, fam family) ([]*Reservation, error) {
+	var hf hostFile
+	if err := yaml.UnmarshalStrict(data, &hf); err != nil {
+		return nil, err
+	}
+	reservations := make([]*Reservation, 0, len(hf.Hosts))
+	for i := range hf.Hosts {
+		res, err := hf.Hosts[i].reservation(fam)
+		if err != nil {
+			return nil, fmt.Errorf("host %d: %v", i+1, err)
+		}
+		reservations = append(reservations, res)
+	}
+	return reservations, nil
+}
+
+// parseLines parses a reservation file with one "<client identifiers> <IP>"
+// reservation per line. Empty lines and comments starting with # are ignored
+func parseLines(data []byte, fam family) ([]*Reservation, error) {
+	var reservations []*Reservation
+	for _, lineBytes := range bytes.Split(data, []byte{'\n'}) {
+		line := string(lineBytes)
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		tokens := strings.Fields(line)
+		if len(tokens) == 0 {
+			continue
+		}
+		if len(tokens) != 2 {
+			return nil, fmt.Errorf("malformed line, want 2 fields, got %d: %s", len(tokens), line)
+		}
+		ids, err := ParseIdentifiers(tokens[0])
+		if err != nil {
+			return nil, err
+		}
+		ipaddr := net.ParseIP(tokens[1])
+		if !fam.validIP(ipaddr) {
+			return nil, fmt.Errorf("expected an %s address, got: %v", fam, tokens[1])
+		}
+		reservations = append(reservations, &Reservation{Match: ids, Addresses: []net.IP{ipaddr}})
+	}
+	return reservations, nil
+}
@@ -2,13 +2,43 @@
 // This source code is licensed under the MIT license found in the
 // LICENSE file in the root directory of this source tree.
 
-// Package file enables static mapping of MAC <--> IP addresses.
+// Package file enables static mapping of clients to IP addresses.
 // The mapping is stored in a text file, where each mapping is described by one line containing
-// two fields separated by spaces: MAC address, and IP address. For example:
+// two fields separated by spaces: client identifiers, and IP address. For example:
 //
 //  $ cat file_leases.txt
 //  00:11:22:33:44:55 10.0.0.1
 //  01:23:45:67:89:01 10.0.10.10
+//  client-id=01:02:03:04:05:06:07 10.0.10.11
+//  circuit-id=eth0/1,remote-id=switch1 10.0.10.12
+//
+// The client identifiers are either a MAC address, or a comma-separated list of
+// <kind>=<value>; a client matches a line when it has all of them. The kinds are:
+// - mac: the client hardware address
+// - duid: the DHCPv6 DUID, or the DUID in a DHCPv4 client identifier (RFC 4361), in hex
+// - client-id: the DHCPv4 client identifier (option 61) or the DHCPv6 DUID, in hex
+// - circuit-id: the DHCPv4 relay agent circuit ID, or the DHCPv6 interface ID
+// - remote-id: the DHCPv4 relay agent remote ID, or the DHCPv6 remote ID
+// - subscriber-id: the DHCPv4 relay agent subscriber ID, or the DHCPv6 subscriber ID
+// Hex values can be colon-separated; relay identifiers are given either as
+// colon-separated hex or as plain strings, and DHCPv6 clients get them from the relay
+// closest to them that sets them. A client is looked up by DUID, then
+// client identifier, MAC address, circuit ID, remote ID and subscriber ID. Empty
+// lines and comments starting with # are ignored.
+//
+// Files with a .yml, .yaml or .json extension hold a list of hosts instead, which
+// can carry more than an address:
+//
+//  $ cat file_leases.yml
+//  hosts:
+//    - mac: 00:11:22:33:44:55      # and/or duid, client-id, circuit-id, remote-id, subscriber-id
+//      address: 10.0.0.1           # or addresses, a list (DHCPv6 only)
+//      prefixes: [2001:db8:1::/56] # delegated prefixes (DHCPv6 only)
+//      hostname: printer
+//      boot-file: http://boot.example.com/printer.efi
+//      lease-time: 12h
+//      options:                    # in the format of the options plugin
+//        - 42:ip-list:10.0.0.123
 //
 // To specify the plugin configuration in the server6/server4 sections of the config file, just
 // pass the leases file name as plugin argument, e.g.:
@@ -22,22 +52,31 @@
 //     ...
 //
 // If the file path is not absolute, it is relative to the cwd where coredhcp is run.
+//
+// With the optional `autorefresh` argument, the file is reloaded whenever it changes,
+// e.g. `- file: "file_leases.txt" autorefresh`. The file is reloaded once it has been
+// left unchanged for a short while, so that it isn't read while being written. When
+// the new content is invalid, an error is logged and the previous reservations are kept.
 package file
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
-	"strings"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/coredhcp/coredhcp/handler"
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/fqdn"
+	"github.com/coredhcp/coredhcp/plugins/leasetime"
+	"github.com/fsnotify/fsnotify"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
 )
 
 var log = logger.GetLogger("plugins/file")
@@ -49,80 +88,76 @@ var Plugin = plugins.Plugin{
 	Setup4: setup4,
 }
 
-// StaticRecords holds a MAC -> IP address mapping
-var StaticRecords map[string]net.IP
-
-// DHCPv6Records and DHCPv4Records are mappings between MAC addresses in
-// form of a string, to network configurations.
+// DHCPv6Records and DHCPv4Records are the reservations of DHCPv6 and DHCPv4
+// clients. They are replaced as a whole when the file is reloaded, under
+// recLock; the Records themselves are never modified.
 var (
-	DHCPv6Records map[string]net.IP
-	DHCPv4Records map[string]net.IP
+	DHCPv6Records *Records
+	DHCPv4Records *Records
+	recLock       sync.RWMutex
 )
 
-// LoadDHCPv4Records loads the DHCPv4Records global map with records stored on
-// the specified file. The records have to be one per line, a mac address and an
-// IPv4 address.
-func LoadDHCPv4Records(filename string) (map[string]net.IP, error) {
-	log.Infof("reading leases from %s", filename)
-	data, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return nil, err
-	}
-	records := make(map[string]net.IP)
-	for _, lineBytes := range bytes.Split(data, []byte{'\n'}) {
-		line := string(lineBytes)
-		if len(line) == 0 {
-			continue
-		}
-		tokens := strings.Fields(line)
-		if len(tokens) != 2 {
-			return nil, fmt.Errorf("malformed line, want 2 fields, got %d: %s", len(tokens), line)
-		}
-		hwaddr, err := net.ParseMAC(tokens[0])
-		if err != nil {
-			return nil, fmt.Errorf("malformed hardware address: %s", tokens[0])
-		}
-		ipaddr := net.ParseIP(tokens[1])
-		if ipaddr.To4() == nil {
-			return nil, fmt.Errorf("expected an IPv4 address, got: %v", ipaddr)
-		}
-		records[hwaddr.String()] = ipaddr
+// autoRefreshArg makes the plugin reload the file when it changes
+const autoRefreshArg = "autorefresh"
+
+// reloadDelay is how long the file must stay unchanged before it is reloaded.
+// Files written in place are truncated first, and must not be read before the
+// new content is written
+const reloadDelay = 200 * time.Millisecond
+
+// records returns the current reservations of a family
+func records(v6 bool) *Records {
+	recLock.RLock()
+	defer recLock.RUnlock()
+	if v6 {
+		return DHCPv6Records
 	}
+	return DHCPv4Records
+}
 
-	return records, nil
+// Lookup4 returns the reservation of a DHCPv4 client, if it has one. This lets
+// other plugins, such as range, take reservations into account
+func Lookup4(req *dhcpv4.DHCPv4) (*Reservation, bool) {
+	return records(false).Lookup(Identifiers4(req))
+}
+
+// Reserved4 returns whether an IPv4 address is reserved for a client
+func Reserved4(ip net.IP) bool {
+	_, ok := records(false).Owner(ip)
+	return ok
 }
 
-// LoadDHCPv6Records loads the DHCPv6Records global map with records stored on
-// the specified file. The records have to be one per line, a mac address and an
-// IPv6 address.
-func LoadDHCPv6Records(filename string) (map[string]net.IP, error) {
+// loadRecords reads reservations for the given family from a file
+func loadRecords(filename string, fam family) (*Records, error) {
 	log.Infof("reading leases from %s", filename)
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
-	records := make(map[string]net.IP)
-	// TODO ignore comments
-	for _, lineBytes := range bytes.Split(data, []byte{'\n'}) {
-		line := string(lineBytes)
-		if len(line) == 0 {
-			continue
-		}
-		tokens := strings.Fields(line)
-		if len(tokens) != 2 {
-			return nil, fmt.Errorf("malformed line: %s", line)
-		}
-		hwaddr, err := net.ParseMAC(tokens[0])
-		if err != nil {
-			return nil, fmt.Errorf("malformed hardware address: %s", tokens[0])
-		}
-		ipaddr := net.ParseIP(tokens[1])
-		if ipaddr.To16() == nil {
-			return nil, fmt.Errorf("expected an IPv6 address, got: %v", ipaddr)
-		}
-		records[hwaddr.String()] = ipaddr
+	var reservations []*Reservation
+	if structured(filename) {
+		reservations, err = parseHostFile(data, fam)
+	} else {
+		reservations, err = parseLines(data, fam)
 	}
-	return records, nil
+	if err != nil {
+		return nil, err
+	}
+	return NewRecords(reservations), nil
+}
+
+// LoadDHCPv4Records loads reservations from the specified file. The records
+// are either one per line, client identifiers and an IPv4 address, or hosts
+// in a YAML or JSON file.
+func LoadDHCPv4Records(filename string) (*Records, error) {
+	return loadRecords(filename, family4)
+}
+
+// LoadDHCPv6Records loads reservations from the specified file. The records
+// are either one per line, client identifiers and an IPv6 address, or hosts
+// in a YAML or JSON file.
+func LoadDHCPv6Records(filename string) (*Records, error) {
+	return loadRecords(filename, family6)
 }
 
 // Handler6 handles DHCPv6 packets for the file plugin
@@ -133,47 +168,97 @@ func Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 		return nil, true
 	}
 
-	if m.Options.OneIANA() == nil {
-		log.Debug("No address requested")
+	ids := Identifiers6(req)
+	record, ok := records(true).Lookup(ids)
+	if !ok {
+		log.Warningf("Client %s is unknown", ids)
 		return resp, false
 	}
+	log.Debugf("found reservation %v for client %s", record.Addresses, ids)
 
-	mac, err := dhcpv6.ExtractMAC(req)
-	if err != nil {
-		log.Warningf("Could not find client MAC, passing")
-		return resp, false
+	timers := leasetime.V6()
+	preferred, valid := timers.Preferred, timers.Valid
+	if record.LeaseTime != 0 {
+		preferred, valid = record.LeaseTime, record.LeaseTime
 	}
-	log.Debugf("looking up an IP address for MAC %s", mac.String())
+	t1, t2 := timers.Times(preferred)
 
-	ipaddr, ok := StaticRecords[mac.String()]
-	if !ok {
-		log.Warningf("MAC address %s is unknown", mac.String())
-		return resp, false
+	if iana := m.Options.OneIANA(); iana != nil && len(record.Addresses) > 0 {
+		ianaResp := &dhcpv6.OptIANA{IaId: iana.IaId, T1: t1, T2: t2}
+		for _, ip := range record.Addresses {
+			ianaResp.Options.Add(&dhcpv6.OptIAAddress{
+				IPv6Addr:          ip,
+				PreferredLifetime: preferred,
+				ValidLifetime:     valid,
+			})
+		}
+		resp.AddOption(ianaResp)
+	}
+	// All the reserved prefixes go to the first IA_PD
+	if iapds := m.Options.IAPD(); len(iapds) > 0 && len(record.Prefixes) > 0 {
+		iapdResp := &dhcpv6.OptIAPD{IaId: iapds[0].IaId, T1: t1, T2: t2}
+		for _, prefix := range record.Prefixes {
+			iapdResp.Options.Add(&dhcpv6.OptIAPrefix{
+				PreferredLifetime: preferred,
+				ValidLifetime:     valid,
+				Prefix:            prefix,
+			})
+		}
+		resp.AddOption(iapdResp)
+	}
+	if record.Hostname != "" {
+		if clientOpt := m.Options.FQDN(); clientOpt != nil && resp.GetOneOption(dhcpv6.OptionFQDN) == nil {
+			// The reserved name is registered by the server, the ddns plugin
+			// does the update, unless the client asked for none. RFC 4704
+			// section 5.2
+			flags := uint8(fqdn.FlagS)
+			if clientOpt.Flags&fqdn.FlagN6 != 0 {
+				flags = fqdn.FlagN6
+			} else if clientOpt.Flags&fqdn.FlagS == 0 {
+				flags |= fqdn.FlagO
+			}
+			resp.AddOption(&dhcpv6.OptFQDN{
+				Flags:      flags,
+				DomainName: &rfc1035label.Labels{Labels: []string{record.Hostname}},
+			})
+		}
+	}
+	if record.BootFile != "" {
+		resp.UpdateOption(dhcpv6.OptBootFileURL(record.BootFile))
+	}
+	for _, opt := range record.Options6 {
+		resp.UpdateOption(opt)
 	}
-	log.Debugf("found IP address %s for MAC %s", ipaddr, mac.String())
-
-	resp.AddOption(&dhcpv6.OptIANA{
-		IaId: m.Options.OneIANA().IaId,
-		Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
-			&dhcpv6.OptIAAddress{
-				IPv6Addr:          ipaddr,
-				PreferredLifetime: 3600 * time.Second,
-				ValidLifetime:     3600 * time.Second,
-			},
-		}},
-	})
 	return resp, false
 }
 
 // Handler4 handles DHCPv4 packets for the file plugin
 func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
-	ipaddr, ok := StaticRecords[req.ClientHWAddr.String()]
+	ids := Identifiers4(req)
+	record, ok := records(false).Lookup(ids)
 	if !ok {
-		log.Warningf("MAC address %s is unknown", req.ClientHWAddr.String())
+		log.Warningf("Client %s is unknown", ids)
 		return resp, false
 	}
-	resp.YourIPAddr = ipaddr
-	log.Debugf("found IP address %s for MAC %s", ipaddr, req.ClientHWAddr.String())
+	resp.YourIPAddr = record.Addresses[0]
+	log.Debugf("found IP address %s for client %s", resp.YourIPAddr, ids)
+
+	if record.Hostname != "" {
+		resp.UpdateOption(dhcpv4.OptHostName(record.Hostname))
+	}
+	if record.BootFile != "" {
+		resp.BootFileName = record.BootFile
+		resp.UpdateOption(dhcpv4.OptBootFileName(record.BootFile))
+	}
+	if record.LeaseTime != 0 {
+		// Renewal and rebinding times set for another lease time no longer apply
+		delete(resp.Options, dhcpv4.OptionRenewTimeValue.Code())
+		delete(resp.Options, dhcpv4.OptionRebindingTimeValue.Code())
+		leasetime.NewPolicy(record.LeaseTime).Apply(resp, record.LeaseTime)
+	}
+	for _, opt := range record.Options4 {
+		resp.UpdateOption(opt)
+	}
 	return resp, true
 }
 
@@ -187,9 +272,85 @@ func setup4(args ...string) (handler.Handler4, error) {
 	return h4, err
 }
 
+// loadFromFile loads the records of a family from a file, and installs them
+// in place of the current ones
+func loadFromFile(v6 bool, filename string) (*Records, error) {
+	if v6 {
+		records, err := LoadDHCPv6Records(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load DHCPv6 records: %v", err)
+		}
+		recLock.Lock()
+		DHCPv6Records = records
+		recLock.Unlock()
+		return records, nil
+	}
+	records, err := LoadDHCPv4Records(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load DHCPv4 records: %v", err)
+	}
+	recLock.Lock()
+	DHCPv4Records = records
+	recLock.Unlock()
+	return records, nil
+}
+
+// watchFile reloads the records whenever the file changes, once it has stayed
+// unchanged for reloadDelay. When the new content is invalid, the current
+// records are kept until the next change
+func watchFile(v6 bool, filename string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// Watch the directory rather than the file itself, so that files replaced
+	// by renaming a new version over them, as most editors do, are followed
+	if err := watcher.Add(filepath.Dir(filename)); err != nil {
+		watcher.Close()
+		return err
+	}
+	target := filepath.Clean(filename)
+	go func() {
+		reload := time.NewTimer(reloadDelay)
+		reload.Stop()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					reload.Stop()
+					return
+				}
+				if filepath.Clean(event.Name) != target || event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				// Only this goroutine receives from the timer, so it can
+				// be drained without blocking
+				if !reload.Stop() {
+					select {
+					case <-reload.C:
+					default:
+					}
+				}
+				reload.Reset(reloadDelay)
+			case <-reload.C:
+				records, err := loadFromFile(v6, filename)
+				if err != nil {
+					log.Errorf("Failed to reload %s, keeping the previous records: %v", filename, err)
+					continue
+				}
+				log.Infof("reloaded %d leases from %s", records.Len(), filename)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Errorf("Error watching %s: %v", filename, err)
+			}
+		}
+	}()
+	return nil
+}
+
 func setupFile(v6 bool, args ...string) (handler.Handler6, handler.Handler4, error) {
-	var err error
-	var records map[string]net.IP
 	if len(args) < 1 {
 		return nil, nil, errors.New("need a file name")
 	}
@@ -197,15 +358,22 @@ func setupFile(v6 bool, args ...string) (handler.Handler6, handler.Handler4, err
 	if filename == "" {
 		return nil, nil, errors.New("got empty file name")
 	}
-	if v6 {
-		records, err = LoadDHCPv6Records(filename)
-	} else {
-		records, err = LoadDHCPv4Records(filename)
+	autoRefresh := false
+	for _, arg := range args[1:] {
+		if arg != autoRefreshArg {
+			return nil, nil, fmt.Errorf("unknown argument `%s`", arg)
+		}
+		autoRefresh = true
 	}
+	records, err := loadFromFile(v6, filename)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to load DHCPv6 records: %v", err)
+		return nil, nil, err
+	}
+	log.Infof("loaded %d leases from %s", records.Len(), filename)
+	if autoRefresh {
+		if err := watchFile(v6, filename); err != nil {
+			return nil, nil, fmt.Errorf("failed to watch %s: %v", filename, err)
+		}
 	}
-	StaticRecords = records
-	log.Infof("loaded %d leases from %s", len(records), filename)
 	return Handler6, Handler4, nil
 }
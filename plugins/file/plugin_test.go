@@ -0,0 +1,386 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package file
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/fqdn"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, content string) string {
+	tmp, err := ioutil.TempFile("", "coredhcp_file_test")
+	require.NoError(t, err)
+	_, err = tmp.WriteString(content)
+	require.NoError(t, err)
+	require.NoError(t, tmp.Close())
+	return tmp.Name()
+}
+
+func TestParseIdentifiers(t *testing.T) {
+	ids, err := ParseIdentifiers("00:11:22:33:44:55")
+	require.NoError(t, err)
+	assert.Equal(t, Identifiers{IDMAC: net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x55}}, ids)
+
+	ids, err = ParseIdentifiers("duid=00:04:0102,circuit-id=eth0/1,remote-id=01:02")
+	require.NoError(t, err)
+	assert.Equal(t, Identifiers{
+		IDDUID:      []byte{0, 4, 1, 2},
+		IDCircuitID: []byte("eth0/1"),
+		IDRemoteID:  []byte{1, 2},
+	}, ids)
+	assert.Equal(t, "duid=00:04:01:02,circuit-id=65:74:68:30:2f:31,remote-id=01:02", ids.String())
+
+	for _, field := range []string{
+		"foo=bar",
+		"mac=zz",
+		"client-id=xyz",
+		"circuit-id=",
+		"remote-id=a,remote-id=b",
+		"00:11:22:33:44",
+	} {
+		_, err := ParseIdentifiers(field)
+		assert.Error(t, err, "field: %s", field)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	mac := net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x55}
+	byMAC := &Reservation{Match: Identifiers{IDMAC: mac}, Addresses: []net.IP{net.IPv4(10, 0, 0, 1)}}
+	byCID := &Reservation{Match: Identifiers{IDClientID: []byte{1, 2, 3}}, Addresses: []net.IP{net.IPv4(10, 0, 0, 2)}}
+	byCircuit := &Reservation{Match: Identifiers{IDCircuitID: []byte("a")}, Addresses: []net.IP{net.IPv4(10, 0, 0, 3)}}
+	byRelay := &Reservation{
+		Match:     Identifiers{IDCircuitID: []byte("a"), IDRemoteID: []byte("b")},
+		Addresses: []net.IP{net.IPv4(10, 0, 0, 4)},
+	}
+	records := NewRecords([]*Reservation{byMAC, byCID, byCircuit, byRelay})
+	assert.Equal(t, 4, records.Len())
+
+	for _, tc := range []struct {
+		ids      Identifiers
+		expected *Reservation
+	}{
+		{Identifiers{IDMAC: mac}, byMAC},
+		{Identifiers{IDMAC: mac, IDClientID: []byte{1, 2, 3}}, byCID},
+		{Identifiers{IDMAC: mac, IDClientID: []byte{4}}, byMAC},
+		{Identifiers{IDCircuitID: []byte("a")}, byCircuit},
+		{Identifiers{IDCircuitID: []byte("a"), IDRemoteID: []byte("b")}, byRelay},
+		{Identifiers{IDCircuitID: []byte("a"), IDRemoteID: []byte("c")}, byCircuit},
+		{Identifiers{IDRemoteID: []byte("b")}, nil},
+	} {
+		res, ok := records.Lookup(tc.ids)
+		assert.Equal(t, tc.expected != nil, ok, "ids: %s", tc.ids)
+		assert.Equal(t, tc.expected, res, "ids: %s", tc.ids)
+	}
+
+	var empty *Records
+	_, ok := empty.Lookup(Identifiers{IDMAC: mac})
+	assert.False(t, ok)
+}
+
+func TestHandler4(t *testing.T) {
+	filename := writeFile(t, `00:11:22:33:44:55 10.0.0.1
+client-id=ff:00:00:00:01:00:04:aa:bb 10.0.0.2
+circuit-id=eth0/1,remote-id=switch1 10.0.0.3
+subscriber-id=customer42 10.0.0.4
+`)
+	defer os.Remove(filename)
+	_, err := setup4(filename)
+	require.NoError(t, err)
+	assert.Equal(t, 4, DHCPv4Records.Len())
+
+	for _, tc := range []struct {
+		modifiers []dhcpv4.Modifier
+		expected  net.IP
+	}{
+		{nil, net.IPv4(10, 0, 0, 1)},
+		{[]dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptClientIdentifier(
+			[]byte{0xff, 0, 0, 0, 1, 0, 4, 0xaa, 0xbb}))}, net.IPv4(10, 0, 0, 2)},
+		{[]dhcpv4.Modifier{
+			dhcpv4.WithHwAddr(net.HardwareAddr{0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa}),
+			dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(
+				dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("eth0/1")),
+				dhcpv4.OptGeneric(dhcpv4.AgentRemoteIDSubOption, []byte("switch1")),
+			)),
+		}, net.IPv4(10, 0, 0, 3)},
+		{[]dhcpv4.Modifier{
+			dhcpv4.WithHwAddr(net.HardwareAddr{0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa}),
+			dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(
+				dhcpv4.OptGeneric(dhcpv4.SubscriberIDSubOption, []byte("customer42")),
+			)),
+		}, net.IPv4(10, 0, 0, 4)},
+	} {
+		req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x55}, tc.modifiers...)
+		require.NoError(t, err)
+		stub, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		resp, stop := Handler4(req, stub)
+		assert.True(t, stop)
+		assert.True(t, resp.YourIPAddr.Equal(tc.expected), "got %s, expected %s", resp.YourIPAddr, tc.expected)
+	}
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa})
+	require.NoError(t, err)
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	resp, stop := Handler4(req, stub)
+	assert.False(t, stop)
+	assert.True(t, resp.YourIPAddr.IsUnspecified())
+}
+
+func TestHandler6(t *testing.T) {
+	filename := writeFile(t, `duid=00:04:00:01:02:03:04:05:06:07:08:09:0a:0b:0c:0d:0e:0f 2001:db8::1
+circuit-id=port1 2001:db8::2
+subscriber-id=customer42 2001:db8::3
+`)
+	defer os.Remove(filename)
+	_, err := setup6(filename)
+	require.NoError(t, err)
+
+	newSolicit := func(duid dhcpv6.Duid) *dhcpv6.Message {
+		msg, err := dhcpv6.NewMessage()
+		require.NoError(t, err)
+		msg.MessageType = dhcpv6.MessageTypeSolicit
+		msg.AddOption(dhcpv6.OptClientID(duid))
+		msg.AddOption(&dhcpv6.OptIANA{IaId: [4]byte{1, 2, 3, 4}})
+		return msg
+	}
+	uuid := dhcpv6.Duid{Type: dhcpv6.DUID_UUID, Uuid: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0xa, 0xb, 0xc, 0xd, 0xe, 0xf}}
+	other := dhcpv6.Duid{Type: dhcpv6.DUID_LL, HwType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{0xaa, 0, 0, 0, 0, 1}}
+
+	req := newSolicit(uuid)
+	stub, err := dhcpv6.NewAdvertiseFromSolicit(req)
+	require.NoError(t, err)
+	resp, _ := Handler6(req, stub)
+	ia := resp.(*dhcpv6.Message).Options.OneIANA()
+	require.NotNil(t, ia)
+	assert.True(t, ia.Options.OneAddress().IPv6Addr.Equal(net.ParseIP("2001:db8::1")))
+
+	inner := newSolicit(other)
+	relayed, err := dhcpv6.EncapsulateRelay(inner, dhcpv6.MessageTypeRelayForward, net.IPv6loopback, net.IPv6loopback)
+	require.NoError(t, err)
+	relayed.AddOption(dhcpv6.OptInterfaceID([]byte("port1")))
+	stub, err = dhcpv6.NewAdvertiseFromSolicit(inner)
+	require.NoError(t, err)
+	resp, _ = Handler6(relayed, stub)
+	ia = resp.(*dhcpv6.Message).Options.OneIANA()
+	require.NotNil(t, ia)
+	assert.True(t, ia.Options.OneAddress().IPv6Addr.Equal(net.ParseIP("2001:db8::2")))
+
+	// Relay identifiers are taken from any relay
+	relayed, err = dhcpv6.EncapsulateRelay(inner, dhcpv6.MessageTypeRelayForward, net.IPv6loopback, net.IPv6loopback)
+	require.NoError(t, err)
+	relayed, err = dhcpv6.EncapsulateRelay(relayed, dhcpv6.MessageTypeRelayForward, net.IPv6loopback, net.IPv6loopback)
+	require.NoError(t, err)
+	relayed.AddOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionRelayAgentSubscriberID, OptionData: []byte("customer42")})
+	stub, err = dhcpv6.NewAdvertiseFromSolicit(inner)
+	require.NoError(t, err)
+	resp, _ = Handler6(relayed, stub)
+	ia = resp.(*dhcpv6.Message).Options.OneIANA()
+	require.NotNil(t, ia)
+	assert.True(t, ia.Options.OneAddress().IPv6Addr.Equal(net.ParseIP("2001:db8::3")))
+
+	req = newSolicit(other)
+	stub, err = dhcpv6.NewAdvertiseFromSolicit(req)
+	require.NoError(t, err)
+	resp, _ = Handler6(req, stub)
+	assert.Nil(t, resp.(*dhcpv6.Message).Options.OneIANA())
+}
+
+func TestParseLinesComments(t *testing.T) {
+	reservations, err := parseLines([]byte(`# reserved hosts
+00:11:22:33:44:55 10.0.0.1 # printer
+
+  client-id=01:02 10.0.0.2
+`), family4)
+	require.NoError(t, err)
+	require.Len(t, reservations, 2)
+	assert.True(t, reservations[1].Addresses[0].Equal(net.IPv4(10, 0, 0, 2)))
+
+	_, err = parseLines([]byte("00:11:22:33:44:55 2001:db8::1\n"), family4)
+	assert.Error(t, err)
+}
+
+func TestParseHostFile(t *testing.T) {
+	reservations, err := parseHostFile([]byte(`hosts:
+  - mac: 00:11:22:33:44:55
+    circuit-id: eth0/1
+    address: 10.0.0.1
+    hostname: printer
+    boot-file: http://boot.example.com/printer.efi
+    lease-time: 12h
+    options:
+      - 42:ip-list:10.0.0.123
+`), family4)
+	require.NoError(t, err)
+	require.Len(t, reservations, 1)
+	res := reservations[0]
+	assert.Equal(t, Identifiers{
+		IDMAC:       net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x55},
+		IDCircuitID: []byte("eth0/1"),
+	}, res.Match)
+	assert.True(t, res.Addresses[0].Equal(net.IPv4(10, 0, 0, 1)))
+	assert.Equal(t, "printer", res.Hostname)
+	assert.Equal(t, "http://boot.example.com/printer.efi", res.BootFile)
+	assert.Equal(t, 12*time.Hour, res.LeaseTime)
+	require.Len(t, res.Options4, 1)
+	assert.Equal(t, uint8(42), res.Options4[0].Code.Code())
+
+	// JSON is accepted as well
+	reservations, err = parseHostFile([]byte(`{"hosts": [{
+		"duid": "00:04:00:01:02:03:04:05:06:07:08:09:0a:0b:0c:0d:0e:0f",
+		"addresses": ["2001:db8::1", "2001:db8::2"],
+		"prefixes": ["2001:db8:1::/56"],
+		"options": ["31:ip-list:2001:db8::123"]
+	}]}`), family6)
+	require.NoError(t, err)
+	require.Len(t, reservations, 1)
+	assert.Len(t, reservations[0].Addresses, 2)
+	assert.Equal(t, "2001:db8:1::/56", reservations[0].Prefixes[0].String())
+	assert.Len(t, reservations[0].Options6, 1)
+
+	for _, content := range []string{
+		"hosts:\n  - address: 10.0.0.1\n",
+		"hosts:\n  - mac: 00:11:22:33:44:55\n",
+		"hosts:\n  - mac: 00:11:22:33:44:55\n    addresses: [10.0.0.1, 10.0.0.2]\n",
+		"hosts:\n  - mac: 00:11:22:33:44:55\n    address: 10.0.0.1\n    prefixes: [2001:db8::/56]\n",
+		"hosts:\n  - mac: 00:11:22:33:44:55\n    address: 10.0.0.1\n    lease-time: soon\n",
+		"hosts:\n  - mac: 00:11:22:33:44:55\n    address: 10.0.0.1\n    options: [53:uint8:1]\n",
+		"hosts:\n  - mac: 00:11:22:33:44:55\n    address: 10.0.0.1\n    color: blue\n",
+	} {
+		_, err := parseHostFile([]byte(content), family4)
+		assert.Error(t, err, "content: %s", content)
+	}
+}
+
+func TestHandler4HostOptions(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "coredhcp_file_test*.yml")
+	require.NoError(t, err)
+	defer os.Remove(tmp.Name())
+	_, err = tmp.WriteString(`hosts:
+  - mac: 00:11:22:33:44:55
+    address: 10.0.0.1
+    hostname: printer
+    boot-file: printer.efi
+    lease-time: 2h
+    options: [42:ip-list:10.0.0.123]
+`)
+	require.NoError(t, err)
+	require.NoError(t, tmp.Close())
+	_, err = setup4(tmp.Name())
+	require.NoError(t, err)
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x55})
+	require.NoError(t, err)
+	stub, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithLeaseTime(600),
+		dhcpv4.WithOption(dhcpv4.Option{Code: dhcpv4.OptionRenewTimeValue, Value: dhcpv4.Duration(300 * time.Second)}))
+	require.NoError(t, err)
+	resp, stop := Handler4(req, stub)
+	assert.True(t, stop)
+	assert.True(t, resp.YourIPAddr.Equal(net.IPv4(10, 0, 0, 1)))
+	assert.Equal(t, "printer", resp.HostName())
+	assert.Equal(t, "printer.efi", resp.BootFileName)
+	assert.Equal(t, "printer.efi", resp.BootFileNameOption())
+	assert.Equal(t, 2*time.Hour, resp.IPAddressLeaseTime(0))
+	assert.Equal(t, time.Hour, resp.IPAddressRenewalTime(0))
+	assert.Equal(t, []net.IP{net.IPv4(10, 0, 0, 123).To4()}, resp.NTPServers())
+}
+
+func TestHandler6HostPrefixes(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "coredhcp_file_test*.yaml")
+	require.NoError(t, err)
+	defer os.Remove(tmp.Name())
+	_, err = tmp.WriteString(`hosts:
+  - client-id: 00:03:00:01:aa:00:00:00:00:01
+    prefixes: [2001:db8:1::/56]
+    boot-file: http://[2001:db8::1]/boot.efi
+    hostname: printer.example.com
+`)
+	require.NoError(t, err)
+	require.NoError(t, tmp.Close())
+	_, err = setup6(tmp.Name())
+	require.NoError(t, err)
+
+	req, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeSolicit
+	req.AddOption(dhcpv6.OptClientID(dhcpv6.Duid{
+		Type:          dhcpv6.DUID_LL,
+		HwType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0xaa, 0, 0, 0, 0, 1},
+	}))
+	req.AddOption(&dhcpv6.OptIAPD{IaId: [4]byte{1, 2, 3, 4}})
+	req.AddOption(&dhcpv6.OptFQDN{DomainName: &rfc1035label.Labels{Labels: []string{"client"}}})
+	stub, err := dhcpv6.NewAdvertiseFromSolicit(req)
+	require.NoError(t, err)
+	resp, _ := Handler6(req, stub)
+	// Through the wire format, as the client sees it
+	decoded, err := dhcpv6.FromBytes(resp.ToBytes())
+	require.NoError(t, err)
+	msg := decoded.(*dhcpv6.Message)
+	iapds := msg.Options.IAPD()
+	require.Len(t, iapds, 1)
+	assert.Equal(t, [4]byte{1, 2, 3, 4}, iapds[0].IaId)
+	require.Len(t, iapds[0].Options.Prefixes(), 1)
+	assert.Equal(t, "2001:db8:1::/56", iapds[0].Options.Prefixes()[0].Prefix.String())
+	assert.Equal(t, "http://[2001:db8::1]/boot.efi", msg.Options.BootFileURL())
+	assert.Nil(t, msg.Options.OneIANA())
+	opt := msg.Options.FQDN()
+	require.NotNil(t, opt)
+	assert.Equal(t, []string{"printer.example.com"}, opt.DomainName.Labels)
+	// The server updates DNS, overriding the client
+	assert.Equal(t, uint8(fqdn.FlagS|fqdn.FlagO), opt.Flags)
+}
+
+func TestAutoRefresh(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcp_file_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "leases.txt")
+	require.NoError(t, ioutil.WriteFile(filename, []byte("00:11:22:33:44:55 10.0.0.1\n"), 0644))
+
+	_, err = setup4(filename, "autorefresh")
+	require.NoError(t, err)
+	mac := Identifiers{IDMAC: net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x55}}
+	lookup := func() net.IP {
+		if res, ok := records(false).Lookup(mac); ok {
+			return res.Addresses[0]
+		}
+		return nil
+	}
+	require.True(t, lookup().Equal(net.IPv4(10, 0, 0, 1)))
+
+	// Written in place
+	require.NoError(t, ioutil.WriteFile(filename, []byte("00:11:22:33:44:55 10.0.0.2\n"), 0644))
+	assert.Eventually(t, func() bool { return lookup().Equal(net.IPv4(10, 0, 0, 2)) },
+		5*time.Second, 10*time.Millisecond)
+
+	// Replaced by renaming
+	tmp := filepath.Join(dir, "leases.txt.new")
+	require.NoError(t, ioutil.WriteFile(tmp, []byte("00:11:22:33:44:55 10.0.0.3\n"), 0644))
+	require.NoError(t, os.Rename(tmp, filename))
+	assert.Eventually(t, func() bool { return lookup().Equal(net.IPv4(10, 0, 0, 3)) },
+		5*time.Second, 10*time.Millisecond)
+
+	// Malformed content keeps the previous records, although writing in place
+	// truncates the file first, which is a valid empty file
+	require.NoError(t, ioutil.WriteFile(filename, []byte("00:11:22:33:44:55\n"), 0644))
+	time.Sleep(2 * reloadDelay)
+	assert.True(t, lookup().Equal(net.IPv4(10, 0, 0, 3)))
+
+	_, err = setup4(filename, "autorefresh", "now")
+	assert.Error(t, err)
+}
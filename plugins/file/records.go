@@ -0,0 +1,304 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package file
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	"github.com/coredhcp/coredhcp/plugins/relayinfo"
+)
+
+// Kinds of client identifiers a reservation can be matched on
+const (
+	// IDMAC is the client hardware address
+	IDMAC = "mac"
+	// IDDUID is the DHCPv6 client DUID, or the DUID of a DHCPv4 client
+	// identifier following RFC 4361
+	IDDUID = "duid"
+	// IDClientID is the DHCPv4 client identifier (option 61), or the DHCPv6
+	// client DUID
+	IDClientID = "client-id"
+	// IDCircuitID is the DHCPv4 relay agent circuit ID (option 82
+	// suboption 1), or the DHCPv6 interface ID (option 18)
+	IDCircuitID = "circuit-id"
+	// IDRemoteID is the DHCPv4 relay agent remote ID (option 82 suboption
+	// 2), or the DHCPv6 remote ID (option 37)
+	IDRemoteID = "remote-id"
+	// IDSubscriberID is the DHCPv4 relay agent subscriber ID (option 82
+	// suboption 6), or the DHCPv6 subscriber ID (option 38)
+	IDSubscriberID = "subscriber-id"
+)
+
+// idPrecedence is the order in which the identifiers of a client are tried
+var idPrecedence = []string{IDDUID, IDClientID, IDMAC, IDCircuitID, IDRemoteID, IDSubscriberID}
+
+// Identifiers holds identifiers of a client, by kind
+type Identifiers map[string][]byte
+
+// String formats identifiers the way they are written in the file, with
+// values other than the MAC address in colon-separated hexadecimal
+func (ids Identifiers) String() string {
+	var items []string
+	for _, kind := range idPrecedence {
+		value, ok := ids[kind]
+		if !ok {
+			continue
+		}
+		if kind == IDMAC {
+			items = append(items, kind+"="+net.HardwareAddr(value).String())
+			continue
+		}
+		digits := make([]string, len(value))
+		for i, b := range value {
+			digits[i] = fmt.Sprintf("%02x", b)
+		}
+		items = append(items, kind+"="+strings.Join(digits, ":"))
+	}
+	return strings.Join(items, ",")
+}
+
+// Reservation is a host reservation. A client matches it when it has all of
+// its identifiers. Only the addresses are mandatory
+type Reservation struct {
+	Match     Identifiers
+	Addresses []net.IP
+	// Prefixes are delegated to DHCPv6 clients requesting prefixes
+	Prefixes []*net.IPNet
+	Hostname string
+	BootFile string
+	// LeaseTime overrides the lease time of DHCPv4 clients, and the
+	// preferred and valid lifetimes of DHCPv6 clients
+	LeaseTime time.Duration
+	Options4  []dhcpv4.Option
+	Options6  []dhcpv6.Option
+}
+
+// family is the address family of a reservation file
+type family int
+
+const (
+	family4 family = 4
+	family6 family = 6
+)
+
+// validIP returns whether an address can be handed out to clients of the
+// family
+func (f family) validIP(ip net.IP) bool {
+	if f == family4 {
+		return ip.To4() != nil
+	}
+	return ip.To16() != nil
+}
+
+func (f family) String() string {
+	return fmt.Sprintf("IPv%d", int(f))
+}
+
+// Records is a set of reservations, indexed by identifier
+type Records struct {
+	count int
+	index map[string][]*Reservation
+	// addresses holds the reserved addresses, as 16-byte strings
+	addresses map[string]*Reservation
+}
+
+func indexKey(kind string, value []byte) string {
+	return kind + "=" + string(value)
+}
+
+// NewRecords indexes a list of reservations
+func NewRecords(reservations []*Reservation) *Records {
+	r := &Records{
+		count:     len(reservations),
+		index:     make(map[string][]*Reservation),
+		addresses: make(map[string]*Reservation),
+	}
+	for _, res := range reservations {
+		for _, ip := range res.Addresses {
+			r.addresses[string(ip.To16())] = res
+		}
+		for kind, value := range res.Match {
+			key := indexKey(kind, value)
+			r.index[key] = append(r.index[key], res)
+		}
+	}
+	// Try the most specific reservations first
+	for _, list := range r.index {
+		sort.SliceStable(list, func(i, j int) bool {
+			return len(list[i].Match) > len(list[j].Match)
+		})
+	}
+	return r
+}
+
+// Len returns the number of reservations
+func (r *Records) Len() int {
+	if r == nil {
+		return 0
+	}
+	return r.count
+}
+
+// Lookup returns the reservation matching a client with the given
+// identifiers. Identifiers are tried in order: DUID, client ID, MAC, circuit
+// ID, remote ID and subscriber ID
+func (r *Records) Lookup(ids Identifiers) (*Reservation, bool) {
+	if r == nil {
+		return nil, false
+	}
+	for _, kind := range idPrecedence {
+		value, ok := ids[kind]
+		if !ok {
+			continue
+		}
+	candidates:
+		for _, res := range r.index[indexKey(kind, value)] {
+			for k, v := range res.Match {
+				if got, ok := ids[k]; !ok || string(got) != string(v) {
+					continue candidates
+				}
+			}
+			return res, true
+		}
+	}
+	return nil, false
+}
+
+// Owner returns the reservation of an address, if it is reserved
+func (r *Records) Owner(ip net.IP) (*Reservation, bool) {
+	if r == nil {
+		return nil, false
+	}
+	res, ok := r.addresses[string(ip.To16())]
+	return res, ok
+}
+
+// parseHex parses bytes written in hexadecimal, optionally separated by
+// colons
+func parseHex(s string) ([]byte, error) {
+	return hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+}
+
+// parseOpaque parses a relay identifier: bytes in hexadecimal separated by
+// colons, or a plain string
+func parseOpaque(s string) []byte {
+	if strings.Contains(s, ":") {
+		if b, err := parseHex(s); err == nil {
+			return b
+		}
+	}
+	return []byte(s)
+}
+
+// parseIdentifier parses the value of an identifier. MAC addresses are
+// written as usual, DUIDs and client identifiers in hexadecimal (optionally
+// colon-separated), and relay identifiers either in colon-separated
+// hexadecimal or as plain strings
+func parseIdentifier(kind, value string) ([]byte, error) {
+	if value == "" {
+		return nil, fmt.Errorf("empty %s", kind)
+	}
+	switch kind {
+	case IDMAC:
+		hwaddr, err := net.ParseMAC(value)
+		if err != nil {
+			return nil, fmt.Errorf("malformed hardware address: %s", value)
+		}
+		return hwaddr, nil
+	case IDDUID, IDClientID:
+		b, err := parseHex(value)
+		if err != nil {
+			return nil, fmt.Errorf("malformed %s: %s", kind, value)
+		}
+		return b, nil
+	case IDCircuitID, IDRemoteID, IDSubscriberID:
+		return parseOpaque(value), nil
+	default:
+		return nil, fmt.Errorf("unknown identifier kind %s", kind)
+	}
+}
+
+// ParseIdentifiers parses the identifiers field of a reservation: either a
+// MAC address, or a comma-separated list of <kind>=<value>
+func ParseIdentifiers(field string) (Identifiers, error) {
+	if hwaddr, err := net.ParseMAC(field); err == nil {
+		return Identifiers{IDMAC: hwaddr}, nil
+	}
+	ids := make(Identifiers)
+	for _, item := range strings.Split(field, ",") {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("malformed identifier, want <kind>=<value>: %s", item)
+		}
+		if _, ok := ids[kv[0]]; ok {
+			return nil, fmt.Errorf("duplicate identifier %s", kv[0])
+		}
+		value, err := parseIdentifier(kv[0], kv[1])
+		if err != nil {
+			return nil, err
+		}
+		ids[kv[0]] = value
+	}
+	return ids, nil
+}
+
+// Identifiers4 returns the identifiers of a DHCPv4 client
+func Identifiers4(req *dhcpv4.DHCPv4) Identifiers {
+	ids := Identifiers{IDMAC: req.ClientHWAddr}
+	if cid := req.Options.Get(dhcpv4.OptionClientIdentifier); len(cid) > 0 {
+		ids[IDClientID] = cid
+		// RFC 4361: type 255, followed by an IAID and a DUID
+		if cid[0] == 255 && len(cid) > 5 {
+			ids[IDDUID] = cid[5:]
+		}
+	}
+	if info := relayinfo.Get4(req); info != nil {
+		if len(info.CircuitID) > 0 {
+			ids[IDCircuitID] = info.CircuitID
+		}
+		if len(info.RemoteID) > 0 {
+			ids[IDRemoteID] = info.RemoteID
+		}
+		if info.SubscriberID != "" {
+			ids[IDSubscriberID] = []byte(info.SubscriberID)
+		}
+	}
+	return ids
+}
+
+// Identifiers6 returns the identifiers of a DHCPv6 client. Each relay
+// identifier is taken from the relay closest to the client that sets it
+func Identifiers6(req dhcpv6.DHCPv6) Identifiers {
+	ids := make(Identifiers)
+	if mac, err := dhcpv6.ExtractMAC(req); err == nil {
+		ids[IDMAC] = mac
+	}
+	for _, hop := range relayinfo.Get6(req) {
+		if _, ok := ids[IDCircuitID]; !ok && len(hop.InterfaceID) > 0 {
+			ids[IDCircuitID] = hop.InterfaceID
+		}
+		if _, ok := ids[IDRemoteID]; !ok && hop.RemoteID != nil && len(hop.RemoteID.RemoteID) > 0 {
+			ids[IDRemoteID] = hop.RemoteID.RemoteID
+		}
+		if _, ok := ids[IDSubscriberID]; !ok && hop.SubscriberID != "" {
+			ids[IDSubscriberID] = []byte(hop.SubscriberID)
+		}
+	}
+	if msg, err := req.GetInnerMessage(); err == nil {
+		if duid := msg.Options.ClientID(); duid != nil {
+			ids[IDDUID] = duid.ToBytes()
+			ids[IDClientID] = ids[IDDUID]
+		}
+	}
+	return ids
+}
@@ -0,0 +1,486 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package fqdn handles the names of the clients: the Host Name option (DHCPv4
+// option 12) and the Client FQDN option (DHCPv4 option 81, RFC 4702 and
+// DHCPv6 option 39, RFC 4704).
+//
+// The plugin decides the name of each client, from the name the client
+// supplied or from a template, records it in a lease record that other plugins
+// can look up with Lookup4 and Lookup6 until the lease ends or is released, and
+// sends it back to the client with the appropriate flags. The plugin must come
+// after the plugin that assigns addresses, since templates can use the
+// assigned address, and before the plugins that consume names (e.g. `ddns`).
+//
+// Arguments are given as key=value pairs, all optional:
+// - domain: domain used to qualify the names, e.g. example.com.
+// - sanitize: if true (the default), client-supplied names are lowercased and
+//   characters that are not valid in a host name are replaced with dashes.
+//   Otherwise invalid names are ignored
+// - template: template of the name given to clients that don't supply one.
+//   The following placeholders are replaced: {ip-dashes} is the assigned
+//   address with dots or colons replaced with dashes, {mac} is the client
+//   hardware address in hexadecimal without separators
+// - replace: if true, always use the template, ignoring client-supplied names
+// - updates: who performs the DNS updates, as signaled in the Client FQDN
+//   option flags. `client` (the default) follows the client preference,
+//   `server` always makes the server do the updates, `none` tells the client
+//   the server doesn't do any update
+//
+// Example usage:
+//
+// server4:
+//   - plugins:
+//     - range: leases.txt 192.0.2.100 192.0.2.200 1h
+//     - fqdn: domain=example.com. template=host-{ip-dashes}
+//     - ddns: server=192.0.2.53:53 zone=example.com.
+//
+package fqdn
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+)
+
+var log = logger.GetLogger("plugins/fqdn")
+
+// Plugin wraps plugin registration information
+var Plugin = plugins.Plugin{
+	Name:   "fqdn",
+	Setup6: setup6,
+	Setup4: setup4,
+}
+
+// Flags of the Client FQDN option, RFC 4702 section 2.1 and RFC 4704 section 4.1
+const (
+	FlagS  = 0x01
+	FlagO  = 0x02
+	FlagE  = 0x04 // DHCPv4 only
+	FlagN4 = 0x08
+	FlagN6 = 0x04
+)
+
+// DNS update policies
+const (
+	updatesClient = "client"
+	updatesServer = "server"
+	updatesNone   = "none"
+)
+
+// maxLabelLength is the maximum length of a DNS label, RFC 1035 section 2.3.4
+const maxLabelLength = 63
+
+// defaultLifetime is how long a name is recorded when the response carries no
+// lease time, and pruneInterval how often expired records are dropped
+const (
+	defaultLifetime = time.Hour
+	pruneInterval   = time.Minute
+)
+
+// Record is the lease record of a client's name
+type Record struct {
+	// ClientName is the name supplied by the client, if any, as received
+	ClientName string
+	// Name is the name decided by the server. It is fully qualified (with a
+	// trailing dot) if a domain is configured
+	Name string
+	// IP is the address the name was decided for
+	IP net.IP
+	// ServerUpdate is true if the server is expected to do the forward DNS
+	// update
+	ServerUpdate bool
+	// expires is when the lease of the client ends
+	expires time.Time
+}
+
+var (
+	recordsLock sync.RWMutex
+	// records4 is keyed by hardware address, records6 by the DUID bytes.
+	// Records are dropped when the client releases its lease, and expired
+	// ones every pruneInterval
+	records4  = make(map[string]Record)
+	records6  = make(map[string]Record)
+	lastPrune time.Time
+)
+
+// lookup returns an unexpired record
+func lookup(records map[string]Record, key string) (Record, bool) {
+	recordsLock.RLock()
+	defer recordsLock.RUnlock()
+	r, ok := records[key]
+	if !ok || !time.Now().Before(r.expires) {
+		return Record{}, false
+	}
+	return r, true
+}
+
+// Lookup4 returns the lease record of the name of a DHCPv4 client
+func Lookup4(hwaddr net.HardwareAddr) (Record, bool) {
+	return lookup(records4, hwaddr.String())
+}
+
+// Lookup6 returns the lease record of the name of a DHCPv6 client
+func Lookup6(duid *dhcpv6.Duid) (Record, bool) {
+	return lookup(records6, string(duid.ToBytes()))
+}
+
+// config is the configuration of one instance of the plugin
+type config struct {
+	domain   string
+	sanitize bool
+	template string
+	replace  bool
+	updates  string
+}
+
+// sanitizeLabel turns an arbitrary string into a valid host name label. It
+// returns an empty string if nothing valid is left
+func sanitizeLabel(s string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(s) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-':
+			b.WriteRune(c)
+		default:
+			b.WriteByte('-')
+		}
+	}
+	label := b.String()
+	if len(label) > maxLabelLength {
+		label = label[:maxLabelLength]
+	}
+	return strings.Trim(label, "-")
+}
+
+// validLabel returns whether s is a valid host name label (RFC 1123)
+func validLabel(s string) bool {
+	return s != "" && sanitizeLabel(s) == s
+}
+
+// normalize validates (or sanitizes, depending on the configuration) a
+// client-supplied name, and returns it without trailing dot
+func (c *config) normalize(name string) string {
+	name = strings.TrimSuffix(strings.TrimSpace(name), ".")
+	if name == "" {
+		return ""
+	}
+	labels := strings.Split(name, ".")
+	for i, l := range labels {
+		if c.sanitize {
+			l = sanitizeLabel(l)
+		} else if !validLabel(l) {
+			return ""
+		}
+		if l == "" {
+			return ""
+		}
+		labels[i] = l
+	}
+	return strings.Join(labels, ".")
+}
+
+// expand fills in the template for the given client
+func (c *config) expand(ip net.IP, mac net.HardwareAddr) string {
+	name := c.template
+	if strings.Contains(name, "{ip-dashes}") {
+		if ip == nil {
+			return ""
+		}
+		dashes := strings.NewReplacer(".", "-", ":", "-").Replace(ip.String())
+		name = strings.Replace(name, "{ip-dashes}", dashes, -1)
+	}
+	if strings.Contains(name, "{mac}") {
+		if mac == nil {
+			return ""
+		}
+		name = strings.Replace(name, "{mac}", hex.EncodeToString(mac), -1)
+	}
+	return c.normalize(name)
+}
+
+// decide returns the fully qualified name of a client, given its supplied
+// name, without trailing dot
+func (c *config) decide(clientName string, ip net.IP, mac net.HardwareAddr) string {
+	var name string
+	if !c.replace {
+		name = c.normalize(clientName)
+	}
+	if name == "" && c.template != "" {
+		name = c.expand(ip, mac)
+	}
+	if name == "" {
+		return ""
+	}
+	if c.domain != "" {
+		// Only keep the host part of names outside of our domain
+		if name != c.domain && !strings.HasSuffix(name, "."+c.domain) {
+			name = strings.SplitN(name, ".", 2)[0] + "." + c.domain
+		}
+	}
+	return name
+}
+
+// flags computes the flags of the Client FQDN option sent back to the
+// client, from the flags it sent, RFC 4702 section 3.2 and RFC 4704 section 5
+func (c *config) flags(clientFlags, flagN uint8) uint8 {
+	switch c.updates {
+	case updatesNone:
+		return flagN
+	case updatesServer:
+		if clientFlags&FlagS == 0 {
+			return FlagS | FlagO
+		}
+		return FlagS
+	default:
+		if clientFlags&flagN != 0 {
+			return flagN
+		}
+		return clientFlags & FlagS
+	}
+}
+
+func (c *config) fqdn(name string) string {
+	if c.domain == "" {
+		return name
+	}
+	return name + "."
+}
+
+// store records the name of a client for the duration of its lease
+func store(records map[string]Record, key string, r Record, lifetime time.Duration) {
+	if lifetime <= 0 {
+		lifetime = defaultLifetime
+	}
+	now := time.Now()
+	r.expires = now.Add(lifetime)
+
+	recordsLock.Lock()
+	defer recordsLock.Unlock()
+	records[key] = r
+	if now.Sub(lastPrune) < pruneInterval {
+		return
+	}
+	lastPrune = now
+	for _, m := range []map[string]Record{records4, records6} {
+		for k, r := range m {
+			if !now.Before(r.expires) {
+				delete(m, k)
+			}
+		}
+	}
+}
+
+// forget drops the record of a client that released its lease
+func forget(records map[string]Record, key string) {
+	recordsLock.Lock()
+	defer recordsLock.Unlock()
+	delete(records, key)
+}
+
+// Handler4 handles DHCPv4 packets for the fqdn plugin
+func (c *config) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeRelease, dhcpv4.MessageTypeDecline:
+		forget(records4, req.ClientHWAddr.String())
+		return resp, false
+	}
+	// Without an address, as for an INFORM or a NAK, there is nothing to name
+	if resp.YourIPAddr == nil || resp.YourIPAddr.IsUnspecified() {
+		return resp, false
+	}
+	var (
+		clientName  string
+		clientFlags uint8
+		hasFQDN     bool
+	)
+	if data := req.Options.Get(dhcpv4.OptionFQDN); data != nil {
+		if len(data) < 3 {
+			log.Warningf("Ignoring malformed Client FQDN option from %s", req.ClientHWAddr)
+		} else {
+			hasFQDN = true
+			clientFlags = data[0]
+			if clientFlags&FlagE != 0 {
+				labels, err := rfc1035label.FromBytes(data[3:])
+				if err == nil && len(labels.Labels) > 0 {
+					clientName = labels.Labels[0]
+				}
+			} else {
+				clientName = string(data[3:])
+			}
+		}
+	}
+	if clientName == "" {
+		clientName = req.HostName()
+	}
+
+	name := c.decide(clientName, resp.YourIPAddr, req.ClientHWAddr)
+	if name == "" {
+		return resp, false
+	}
+	flags := c.flags(clientFlags, FlagN4)
+	// Offers are sent the name, which is only bound to the address once the
+	// lease is acknowledged
+	if resp.MessageType() == dhcpv4.MessageTypeAck {
+		store(records4, req.ClientHWAddr.String(), Record{
+			ClientName:   clientName,
+			Name:         c.fqdn(name),
+			IP:           resp.YourIPAddr,
+			ServerUpdate: flags&FlagS != 0 || (!hasFQDN && c.updates != updatesNone),
+		}, resp.IPAddressLeaseTime(0))
+	}
+
+	if hasFQDN {
+		flags |= clientFlags & FlagE
+		// RCODE1 and RCODE2 are deprecated, servers set them to 255
+		data := []byte{flags, 255, 255}
+		if flags&FlagE != 0 {
+			data = append(data, (&rfc1035label.Labels{Labels: []string{name}}).ToBytes()...)
+		} else {
+			data = append(data, []byte(c.fqdn(name))...)
+		}
+		resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionFQDN, data))
+	} else {
+		resp.UpdateOption(dhcpv4.OptHostName(strings.SplitN(name, ".", 2)[0]))
+	}
+	log.Debugf("Name of %s is %s", req.ClientHWAddr, name)
+	return resp, false
+}
+
+// Handler6 handles DHCPv6 packets for the fqdn plugin
+func (c *config) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	msg, err := req.GetInnerMessage()
+	if err != nil {
+		log.Errorf("Could not decapsulate request: %v", err)
+		return nil, true
+	}
+	duid := msg.Options.ClientID()
+	if duid == nil {
+		return resp, false
+	}
+	switch msg.MessageType {
+	case dhcpv6.MessageTypeRelease, dhcpv6.MessageTypeDecline:
+		forget(records6, string(duid.ToBytes()))
+		return resp, false
+	}
+
+	var (
+		ip          net.IP
+		lifetime    time.Duration
+		clientName  string
+		clientFlags uint8
+	)
+	clientOpt := msg.Options.FQDN()
+	if clientOpt != nil {
+		clientFlags = clientOpt.Flags
+		if clientOpt.DomainName != nil && len(clientOpt.DomainName.Labels) > 0 {
+			clientName = clientOpt.DomainName.Labels[0]
+		}
+	}
+	if reply, ok := resp.(*dhcpv6.Message); ok {
+		if iana := reply.Options.OneIANA(); iana != nil {
+			if addr := iana.Options.OneAddress(); addr != nil {
+				ip = addr.IPv6Addr
+				lifetime = addr.ValidLifetime
+			}
+		}
+	}
+	mac, _ := dhcpv6.ExtractMAC(req)
+
+	name := c.decide(clientName, ip, mac)
+	if name == "" {
+		return resp, false
+	}
+	flags := c.flags(clientFlags, FlagN6)
+	store(records6, string(duid.ToBytes()), Record{
+		ClientName:   clientName,
+		Name:         c.fqdn(name),
+		IP:           ip,
+		ServerUpdate: flags&FlagS != 0 || (clientOpt == nil && c.updates != updatesNone),
+	}, lifetime)
+
+	// RFC 4704 section 5: only send the option to clients that sent it
+	if clientOpt != nil {
+		resp.UpdateOption(&dhcpv6.OptFQDN{
+			Flags:      flags,
+			DomainName: &rfc1035label.Labels{Labels: []string{name}},
+		})
+	}
+	log.Debugf("Name of %s is %s", duid, name)
+	return resp, false
+}
+
+func setup(args ...string) (*config, error) {
+	c := config{sanitize: true, updates: updatesClient}
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("expected key=value argument, got `%s`", arg)
+		}
+		var err error
+		switch kv[0] {
+		case "domain":
+			c.domain = strings.ToLower(strings.Trim(kv[1], "."))
+			for _, l := range strings.Split(c.domain, ".") {
+				if !validLabel(l) {
+					return nil, fmt.Errorf("invalid domain: %s", kv[1])
+				}
+			}
+		case "sanitize":
+			c.sanitize, err = strconv.ParseBool(kv[1])
+		case "template":
+			c.template = kv[1]
+			if c.expand(net.IPv4zero, net.HardwareAddr{0}) == "" {
+				return nil, fmt.Errorf("template doesn't yield a valid name: %s", kv[1])
+			}
+		case "replace":
+			c.replace, err = strconv.ParseBool(kv[1])
+		case "updates":
+			switch kv[1] {
+			case updatesClient, updatesServer, updatesNone:
+				c.updates = kv[1]
+			default:
+				return nil, fmt.Errorf("invalid updates policy `%s`, want client, server or none", kv[1])
+			}
+		default:
+			return nil, fmt.Errorf("unknown argument `%s`", kv[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %v", kv[0], err)
+		}
+	}
+	if c.replace && c.template == "" {
+		return nil, errors.New("replace needs a template")
+	}
+	log.Printf("loaded fqdn plugin (domain: %q, template: %q)", c.domain, c.template)
+	return &c, nil
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	c, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return c.Handler6, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	c, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return c.Handler4, nil
+}
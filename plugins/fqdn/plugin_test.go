@@ -0,0 +1,273 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package fqdn
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ack4(t *testing.T, req *dhcpv4.DHCPv4, ip net.IP) *dhcpv4.DHCPv4 {
+	resp, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithYourIP(ip),
+	)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestSanitize(t *testing.T) {
+	c := config{sanitize: true}
+	assert.Equal(t, "my-laptop", c.normalize("My_Laptop"))
+	assert.Equal(t, "host.example.com", c.normalize("host.Example.com."))
+	assert.Equal(t, "a-b", c.normalize("--a b--"))
+	assert.Equal(t, "", c.normalize("host..example"))
+	assert.Equal(t, "", c.normalize("___"))
+
+	c.sanitize = false
+	assert.Equal(t, "", c.normalize("My_Laptop"))
+	assert.Equal(t, "host.example.com", c.normalize("host.example.com"))
+}
+
+func TestDecide(t *testing.T) {
+	c, err := setup("domain=example.com.", "template=host-{ip-dashes}")
+	require.NoError(t, err)
+	ip := net.IPv4(192, 0, 2, 10)
+	assert.Equal(t, "laptop.example.com", c.decide("laptop", ip, nil))
+	assert.Equal(t, "laptop.example.com", c.decide("laptop.example.com", ip, nil))
+	assert.Equal(t, "laptop.example.com", c.decide("laptop.example.org", ip, nil))
+	assert.Equal(t, "host-192-0-2-10.example.com", c.decide("", ip, nil))
+	assert.Equal(t, "host-2001-db8--10.example.com", c.decide("", net.ParseIP("2001:db8::10"), nil))
+
+	c, err = setup("template=dev-{mac}", "replace=true")
+	require.NoError(t, err)
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	assert.Equal(t, "dev-001122334455", c.decide("laptop", ip, mac))
+}
+
+func TestFlags(t *testing.T) {
+	c := config{updates: updatesClient}
+	assert.Equal(t, uint8(FlagS), c.flags(FlagS, FlagN4))
+	assert.Equal(t, uint8(0), c.flags(0, FlagN4))
+	assert.Equal(t, uint8(FlagN4), c.flags(FlagN4, FlagN4))
+
+	c.updates = updatesServer
+	assert.Equal(t, uint8(FlagS), c.flags(FlagS, FlagN4))
+	assert.Equal(t, uint8(FlagS|FlagO), c.flags(0, FlagN4))
+	assert.Equal(t, uint8(FlagS|FlagO), c.flags(FlagN6, FlagN6))
+
+	c.updates = updatesNone
+	assert.Equal(t, uint8(FlagN6), c.flags(FlagS, FlagN6))
+}
+
+func TestSetupErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"domain"},
+		{"domain=exa_mple.com"},
+		{"sanitize=maybe"},
+		{"template=_"},
+		{"replace=true"},
+		{"updates=sometimes"},
+		{"foo=bar"},
+	} {
+		_, err := setup(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestHandler4HostName(t *testing.T) {
+	c, err := setup("domain=example.com")
+	require.NoError(t, err)
+
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x01}
+	req, err := dhcpv4.NewDiscovery(mac, dhcpv4.WithOption(dhcpv4.OptHostName("My Laptop")))
+	require.NoError(t, err)
+	ip := net.IPv4(192, 0, 2, 11).To4()
+	resp, stop := c.Handler4(req, ack4(t, req, ip))
+	assert.False(t, stop)
+	assert.Equal(t, "my-laptop", resp.HostName())
+	assert.False(t, resp.Options.Has(dhcpv4.OptionFQDN))
+
+	r, ok := Lookup4(mac)
+	require.True(t, ok)
+	assert.Equal(t, "My Laptop", r.ClientName)
+	assert.Equal(t, "my-laptop.example.com.", r.Name)
+	assert.True(t, r.IP.Equal(ip))
+	assert.True(t, r.ServerUpdate)
+}
+
+func TestHandler4FQDN(t *testing.T) {
+	c, err := setup("domain=example.com", "updates=server")
+	require.NoError(t, err)
+
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x02}
+	// Canonical wire format encoding, client wants to do the update itself
+	name := (&rfc1035label.Labels{Labels: []string{"laptop"}}).ToBytes()
+	req, err := dhcpv4.NewDiscovery(mac, dhcpv4.WithOption(
+		dhcpv4.OptGeneric(dhcpv4.OptionFQDN, append([]byte{FlagE, 0, 0}, name...)),
+	))
+	require.NoError(t, err)
+	resp, _ := c.Handler4(req, ack4(t, req, net.IPv4(192, 0, 2, 12)))
+
+	data := resp.Options.Get(dhcpv4.OptionFQDN)
+	require.True(t, len(data) > 3)
+	assert.Equal(t, uint8(FlagS|FlagO|FlagE), data[0])
+	assert.Equal(t, []byte{255, 255}, data[1:3])
+	labels, err := rfc1035label.FromBytes(data[3:])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"laptop.example.com"}, labels.Labels)
+	assert.False(t, resp.Options.Has(dhcpv4.OptionHostName))
+
+	// ASCII encoding
+	req, err = dhcpv4.NewDiscovery(mac, dhcpv4.WithOption(
+		dhcpv4.OptGeneric(dhcpv4.OptionFQDN, append([]byte{FlagS, 0, 0}, "laptop"...)),
+	))
+	require.NoError(t, err)
+	resp, _ = c.Handler4(req, ack4(t, req, net.IPv4(192, 0, 2, 12)))
+	data = resp.Options.Get(dhcpv4.OptionFQDN)
+	assert.Equal(t, append([]byte{FlagS, 255, 255}, "laptop.example.com."...), data)
+}
+
+func TestHandler4NoName(t *testing.T) {
+	c, err := setup()
+	require.NoError(t, err)
+
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x03}
+	req, err := dhcpv4.NewDiscovery(mac)
+	require.NoError(t, err)
+	resp, _ := c.Handler4(req, ack4(t, req, net.IPv4(192, 0, 2, 13)))
+	assert.False(t, resp.Options.Has(dhcpv4.OptionHostName))
+	_, ok := Lookup4(mac)
+	assert.False(t, ok)
+}
+
+func TestHandler4NoLease(t *testing.T) {
+	c, err := setup("domain=example.com", "template=host-{ip-dashes}")
+	require.NoError(t, err)
+
+	// INFORM clients already have an address, the reply doesn't carry one
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x07}
+	inform, err := dhcpv4.New(
+		dhcpv4.WithHwAddr(mac),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeInform),
+		dhcpv4.WithClientIP(net.IPv4(192, 0, 2, 17)),
+	)
+	require.NoError(t, err)
+	resp, stop := c.Handler4(inform, ack4(t, inform, net.IPv4zero))
+	assert.False(t, stop)
+	assert.False(t, resp.Options.Has(dhcpv4.OptionHostName))
+	_, ok := Lookup4(mac)
+	assert.False(t, ok)
+
+	// Offers get a name, but it isn't recorded before the ACK
+	discover, err := dhcpv4.NewDiscovery(mac)
+	require.NoError(t, err)
+	offer, err := dhcpv4.NewReplyFromRequest(discover,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer),
+		dhcpv4.WithYourIP(net.IPv4(192, 0, 2, 17)),
+	)
+	require.NoError(t, err)
+	resp, _ = c.Handler4(discover, offer)
+	assert.Equal(t, "host-192-0-2-17", resp.HostName())
+	_, ok = Lookup4(mac)
+	assert.False(t, ok)
+}
+
+func TestHandler6(t *testing.T) {
+	c, err := setup("domain=example.com", "template=host-{ip-dashes}")
+	require.NoError(t, err)
+
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x04}
+	req, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeRequest
+	duid := dhcpv6.Duid{Type: dhcpv6.DUID_LL, HwType: 1, LinkLayerAddr: mac}
+	req.AddOption(dhcpv6.OptClientID(duid))
+	req.AddOption(&dhcpv6.OptFQDN{
+		Flags:      FlagS,
+		DomainName: &rfc1035label.Labels{Labels: []string{"Work_Station"}},
+	})
+
+	ip := net.ParseIP("2001:db8::14")
+	resp, err := dhcpv6.NewReplyFromMessage(req)
+	require.NoError(t, err)
+	resp.AddOption(&dhcpv6.OptIANA{Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+		&dhcpv6.OptIAAddress{IPv6Addr: ip},
+	}}})
+
+	result, stop := c.Handler6(req, resp)
+	assert.False(t, stop)
+	opt := result.(*dhcpv6.Message).Options.FQDN()
+	require.NotNil(t, opt)
+	assert.Equal(t, uint8(FlagS), opt.Flags)
+	assert.Equal(t, []string{"work-station.example.com"}, opt.DomainName.Labels)
+
+	r, ok := Lookup6(&duid)
+	require.True(t, ok)
+	assert.Equal(t, "work-station.example.com.", r.Name)
+	assert.True(t, r.IP.Equal(ip))
+
+	// Without a Client FQDN option, the name is generated and recorded but not
+	// sent to the client
+	req.Options.Del(dhcpv6.OptionFQDN)
+	resp, err = dhcpv6.NewReplyFromMessage(req)
+	require.NoError(t, err)
+	resp.AddOption(&dhcpv6.OptIANA{Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+		&dhcpv6.OptIAAddress{IPv6Addr: ip},
+	}}})
+	result, _ = c.Handler6(req, resp)
+	assert.Nil(t, result.(*dhcpv6.Message).Options.FQDN())
+	r, ok = Lookup6(&duid)
+	require.True(t, ok)
+	assert.Equal(t, "host-2001-db8--14.example.com.", r.Name)
+}
+
+func TestRecordLifetime(t *testing.T) {
+	c, err := setup("domain=example.com")
+	require.NoError(t, err)
+
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x05}
+	req, err := dhcpv4.NewDiscovery(mac, dhcpv4.WithOption(dhcpv4.OptHostName("laptop")))
+	require.NoError(t, err)
+	resp := ack4(t, req, net.IPv4(192, 0, 2, 15))
+	resp.UpdateOption(dhcpv4.OptIPAddressLeaseTime(time.Hour))
+	c.Handler4(req, resp)
+	r, ok := Lookup4(mac)
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), r.expires, time.Minute)
+
+	// Released names are forgotten
+	release, err := dhcpv4.NewDiscovery(mac, dhcpv4.WithMessageType(dhcpv4.MessageTypeRelease))
+	require.NoError(t, err)
+	c.Handler4(release, ack4(t, release, nil))
+	_, ok = Lookup4(mac)
+	assert.False(t, ok)
+
+	// Expired names are ignored, and eventually dropped
+	c.Handler4(req, resp)
+	recordsLock.Lock()
+	r = records4[mac.String()]
+	r.expires = time.Now()
+	records4[mac.String()] = r
+	lastPrune = time.Time{}
+	recordsLock.Unlock()
+	_, ok = Lookup4(mac)
+	assert.False(t, ok)
+	other := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x06}
+	req, err = dhcpv4.NewDiscovery(other, dhcpv4.WithOption(dhcpv4.OptHostName("desktop")))
+	require.NoError(t, err)
+	c.Handler4(req, ack4(t, req, net.IPv4(192, 0, 2, 16)))
+	recordsLock.RLock()
+	assert.NotContains(t, records4, mac.String())
+	assert.Contains(t, records4, other.String())
+	recordsLock.RUnlock()
+}
@@ -4,6 +4,16 @@
 
 package leasetime
 
+// This plugin sets the lease time of DHCPv4 leases (option 51), along with the
+// renewal (58) and rebinding (59) times. Besides the default lease time, it
+// can honour the lease time requested by clients within bounds, and use
+// different lease times per class or per host; see Policy.
+// When an earlier plugin such as range already set the lease time, only the
+// renewal and rebinding times are added.
+// For DHCPv6, it configures the lifetimes and T1/T2 ratios used by the plugins
+// handing out addresses and prefixes (see Policy6 and V6), and sends the
+// Information Refresh Time, SOL_MAX_RT and INF_MAX_RT options.
+
 import (
 	"errors"
 	"time"
@@ -12,33 +22,65 @@ import (
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
 	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
 )
 
 // Plugin wraps plugin registration information
 var Plugin = plugins.Plugin{
-	Name: "lease_time",
-	// currently not supported for DHCPv6
-	Setup6: nil,
+	Name:   "lease_time",
+	Setup6: setup6,
 	Setup4: setup4,
 }
 
 var (
-	log         = logger.GetLogger("plugins/lease_time")
-	v4LeaseTime time.Duration
+	log    = logger.GetLogger("plugins/lease_time")
+	policy *Policy
 )
 
+// Handler6 handles DHCPv6 packets for the lease_time plugin.
+func Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	msg, err := req.GetInnerMessage()
+	if err != nil {
+		log.Errorf("Could not decapsulate relayed message, aborting: %v", err)
+		return nil, true
+	}
+	V6().ApplyMessage(msg, resp)
+	return resp, false
+}
+
 // Handler4 handles DHCPv4 packets for the lease_time plugin.
 func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 	if req.OpCode != dhcpv4.OpcodeBootRequest {
 		return resp, false
 	}
 	// Set lease time unless it has already been set
-	if !resp.Options.Has(dhcpv4.OptionIPAddressLeaseTime) {
-		resp.Options.Update(dhcpv4.OptIPAddressLeaseTime(v4LeaseTime))
+	leaseTime := resp.IPAddressLeaseTime(0)
+	if leaseTime == 0 {
+		leaseTime = policy.LeaseTime(req)
 	}
+	policy.Apply(resp, leaseTime)
 	return resp, false
 }
 
+func setup6(args ...string) (handler.Handler6, error) {
+	log.Print("loading `lease_time` plugin for DHCPv6")
+	p := NewPolicy6()
+	// An optional leading duration sets both lifetimes
+	if len(args) > 0 {
+		if lifetime, err := time.ParseDuration(args[0]); err == nil {
+			p.Preferred, p.Valid = lifetime, lifetime
+			args = args[1:]
+		}
+	}
+	if err := p.Parse(args...); err != nil {
+		log.Errorf("invalid arguments: %v", err)
+		return nil, errors.New("lease_time failed to initialize")
+	}
+	SetV6(p)
+
+	return Handler6, nil
+}
+
 func setup4(args ...string) (handler.Handler4, error) {
 	log.Print("loading `lease_time` plugin for DHCPv4")
 	if len(args) < 1 {
@@ -51,7 +93,12 @@ func setup4(args ...string) (handler.Handler4, error) {
 		log.Errorf("invalid duration: %v", args[0])
 		return nil, errors.New("lease_time failed to initialize")
 	}
-	v4LeaseTime = leaseTime
+	p := NewPolicy(leaseTime)
+	if err := p.Parse(args[1:]...); err != nil {
+		log.Errorf("invalid arguments: %v", err)
+		return nil, errors.New("lease_time failed to initialize")
+	}
+	policy = p
 
 	return Handler4, nil
 }
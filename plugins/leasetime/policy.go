@@ -0,0 +1,206 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package leasetime
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// Default ratios of the renewal (T1) and rebinding (T2) times to the lease
+// time, RFC 2131 section 4.4.5
+const (
+	DefaultT1Ratio = 0.5
+	DefaultT2Ratio = 0.875
+)
+
+// Policy decides the lease time of DHCPv4 clients, and the renewal and
+// rebinding times derived from it. It is shared by the plugins that hand out
+// lease times, so that they can be configured the same way.
+//
+// The lease time of a client is, in order of preference: the one configured
+// for its hardware address, the one configured for its class, the one it
+// requested if bounds are configured (clamped to the bounds), and the default
+type Policy struct {
+	Default  time.Duration
+	Min, Max time.Duration
+	T1Ratio  float64
+	T2Ratio  float64
+	// Classes maps vendor class identifier prefixes (option 60) or user
+	// classes (option 77) to lease times
+	Classes map[string]time.Duration
+	// Hosts maps hardware addresses to lease times
+	Hosts map[string]time.Duration
+}
+
+// NewPolicy returns a policy with the given default lease time and the
+// default T1 and T2 ratios
+func NewPolicy(def time.Duration) *Policy {
+	return &Policy{
+		Default: def,
+		T1Ratio: DefaultT1Ratio,
+		T2Ratio: DefaultT2Ratio,
+		Classes: make(map[string]time.Duration),
+		Hosts:   make(map[string]time.Duration),
+	}
+}
+
+// splitLast splits a <name>:<value> argument, where name can contain colons
+func splitLast(arg string) (string, string, error) {
+	i := strings.LastIndex(arg, ":")
+	if i <= 0 {
+		return "", "", fmt.Errorf("expected <name>:<duration>, got `%s`", arg)
+	}
+	return arg[:i], arg[i+1:], nil
+}
+
+func parsePositiveDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("duration must be positive, got %s", s)
+	}
+	return d, nil
+}
+
+func parseRatio(s string) (float64, error) {
+	r, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if r <= 0 || r >= 1 {
+		return 0, fmt.Errorf("ratio must be between 0 and 1, got %s", s)
+	}
+	return r, nil
+}
+
+// Parse updates the policy from key=value arguments:
+//   - min, max: bounds of the lease time requested by clients. Requested lease
+//     times are ignored unless at least one bound is set
+//   - t1, t2: ratios of the renewal and rebinding times to the lease time
+//   - class=<class>:<duration>: lease time of the clients whose vendor class
+//     identifier starts with, or whose user class is, <class>
+//   - host=<hardware address>:<duration>: lease time of a client
+func (p *Policy) Parse(args ...string) error {
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("expected key=value argument, got `%s`", arg)
+		}
+		var err error
+		switch kv[0] {
+		case "min":
+			p.Min, err = parsePositiveDuration(kv[1])
+		case "max":
+			p.Max, err = parsePositiveDuration(kv[1])
+		case "t1":
+			p.T1Ratio, err = parseRatio(kv[1])
+		case "t2":
+			p.T2Ratio, err = parseRatio(kv[1])
+		case "class":
+			var name, value string
+			if name, value, err = splitLast(kv[1]); err == nil {
+				p.Classes[name], err = parsePositiveDuration(value)
+			}
+		case "host":
+			var name, value string
+			if name, value, err = splitLast(kv[1]); err == nil {
+				var hwaddr net.HardwareAddr
+				if hwaddr, err = net.ParseMAC(name); err == nil {
+					p.Hosts[hwaddr.String()], err = parsePositiveDuration(value)
+				}
+			}
+		default:
+			return fmt.Errorf("unknown argument `%s`", kv[0])
+		}
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %v", kv[0], err)
+		}
+	}
+	if p.Min != 0 && p.Max != 0 && p.Min > p.Max {
+		return errors.New("min lease time is greater than max lease time")
+	}
+	if p.T1Ratio >= p.T2Ratio {
+		return errors.New("t1 must be lower than t2")
+	}
+	return nil
+}
+
+// LeaseTime returns the lease time to give to the client sending req
+func (p *Policy) LeaseTime(req *dhcpv4.DHCPv4) time.Duration {
+	if d, ok := p.Hosts[req.ClientHWAddr.String()]; ok {
+		return d
+	}
+	if len(p.Classes) > 0 {
+		vendorClass := req.ClassIdentifier()
+		userClasses := req.UserClass()
+		// Look for the longest matching class, so that the result doesn't
+		// depend on the map ordering
+		var (
+			best  string
+			found bool
+		)
+		for class := range p.Classes {
+			match := vendorClass != "" && strings.HasPrefix(vendorClass, class)
+			for _, uc := range userClasses {
+				match = match || uc == class
+			}
+			if match && (!found || len(class) > len(best) || (len(class) == len(best) && class < best)) {
+				best, found = class, true
+			}
+		}
+		if found {
+			return p.Classes[best]
+		}
+	}
+	if p.Min != 0 || p.Max != 0 {
+		if requested := req.IPAddressLeaseTime(0); requested != 0 {
+			if p.Min != 0 && requested < p.Min {
+				return p.Min
+			}
+			if p.Max != 0 && requested > p.Max {
+				return p.Max
+			}
+			return requested
+		}
+	}
+	return p.Default
+}
+
+// Apply sets the lease time in the response, along with the renewal and
+// rebinding times unless they were already set. Infinite leases get no
+// renewal and rebinding times
+func (p *Policy) Apply(resp *dhcpv4.DHCPv4, lease time.Duration) {
+	if lease >= dhcpv4.MaxLeaseTime {
+		resp.Options.Update(dhcpv4.OptIPAddressLeaseTime(dhcpv4.MaxLeaseTime))
+		return
+	}
+	lease = lease.Round(time.Second)
+	resp.Options.Update(dhcpv4.OptIPAddressLeaseTime(lease))
+	if !resp.Options.Has(dhcpv4.OptionRenewTimeValue) {
+		resp.Options.Update(dhcpv4.Option{
+			Code:  dhcpv4.OptionRenewTimeValue,
+			Value: dhcpv4.Duration(p.ratio(lease, p.T1Ratio)),
+		})
+	}
+	if !resp.Options.Has(dhcpv4.OptionRebindingTimeValue) {
+		resp.Options.Update(dhcpv4.Option{
+			Code:  dhcpv4.OptionRebindingTimeValue,
+			Value: dhcpv4.Duration(p.ratio(lease, p.T2Ratio)),
+		})
+	}
+}
+
+func (p *Policy) ratio(lease time.Duration, ratio float64) time.Duration {
+	return time.Duration(float64(lease) * ratio).Round(time.Second)
+}
@@ -0,0 +1,170 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package leasetime
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// Default DHCPv6 timers. The T1 and T2 ratios are the ones recommended by
+// RFC 8415 section 21.4
+const (
+	DefaultLifetime6 = 3600 * time.Second
+	DefaultT1Ratio6  = 0.5
+	DefaultT2Ratio6  = 0.8
+)
+
+// Bounds of SOL_MAX_RT and INF_MAX_RT, RFC 8415 sections 21.24 and 21.25
+const (
+	minMaxRT = 60 * time.Second
+	maxMaxRT = 86400 * time.Second
+)
+
+// Policy6 holds the DHCPv6 timers: the lifetimes of addresses and prefixes,
+// the renewal (T1) and rebinding (T2) times of their IAs, and the optional
+// Information Refresh Time (option 32), SOL_MAX_RT (82) and INF_MAX_RT (83)
+type Policy6 struct {
+	Preferred time.Duration
+	Valid     time.Duration
+	T1Ratio   float64
+	T2Ratio   float64
+	// The following are not sent when zero
+	InformationRefresh time.Duration
+	SolMaxRT           time.Duration
+	InfMaxRT           time.Duration
+}
+
+// NewPolicy6 returns a policy with the default DHCPv6 timers
+func NewPolicy6() *Policy6 {
+	return &Policy6{
+		Preferred: DefaultLifetime6,
+		Valid:     DefaultLifetime6,
+		T1Ratio:   DefaultT1Ratio6,
+		T2Ratio:   DefaultT2Ratio6,
+	}
+}
+
+var policy6 atomic.Value
+
+func init() {
+	policy6.Store(NewPolicy6())
+}
+
+// V6 returns the DHCPv6 timers configured with the lease_time plugin, or the
+// default ones. All the plugins handing out DHCPv6 addresses or prefixes use
+// it, so that the timers are consistent across them.
+func V6() *Policy6 {
+	return policy6.Load().(*Policy6)
+}
+
+// SetV6 replaces the DHCPv6 timers returned by V6
+func SetV6(p *Policy6) {
+	policy6.Store(p)
+}
+
+func parseMaxRT(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < minMaxRT || d > maxMaxRT {
+		return 0, fmt.Errorf("must be between %s and %s, got %s", minMaxRT, maxMaxRT, s)
+	}
+	return d, nil
+}
+
+// Parse updates the policy from key=value arguments:
+//   - preferred, valid: lifetimes of the addresses and prefixes
+//   - t1, t2: ratios of the renewal and rebinding times to the preferred lifetime
+//   - refresh: information refresh time sent to stateless clients
+//   - sol-max-rt, inf-max-rt: maximum Solicit and Information-request
+//     retransmission times, between 60s and 86400s
+func (p *Policy6) Parse(args ...string) error {
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("expected key=value argument, got `%s`", arg)
+		}
+		var err error
+		switch kv[0] {
+		case "preferred":
+			p.Preferred, err = parsePositiveDuration(kv[1])
+		case "valid":
+			p.Valid, err = parsePositiveDuration(kv[1])
+		case "t1":
+			p.T1Ratio, err = parseRatio(kv[1])
+		case "t2":
+			p.T2Ratio, err = parseRatio(kv[1])
+		case "refresh":
+			p.InformationRefresh, err = parsePositiveDuration(kv[1])
+		case "sol-max-rt":
+			p.SolMaxRT, err = parseMaxRT(kv[1])
+		case "inf-max-rt":
+			p.InfMaxRT, err = parseMaxRT(kv[1])
+		default:
+			return fmt.Errorf("unknown argument `%s`", kv[0])
+		}
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %v", kv[0], err)
+		}
+	}
+	if p.Preferred > p.Valid {
+		return errors.New("preferred lifetime is greater than valid lifetime")
+	}
+	if p.T1Ratio >= p.T2Ratio {
+		return errors.New("t1 must be lower than t2")
+	}
+	return nil
+}
+
+// Lifetimes returns the preferred and valid lifetimes of a lease expiring at
+// the given time. The preferred lifetime never exceeds the configured one
+func (p *Policy6) Lifetimes(expire time.Time) (preferred, valid time.Duration) {
+	valid = time.Until(expire).Round(time.Second)
+	if valid < 0 {
+		valid = 0
+	}
+	preferred = valid
+	if preferred > p.Preferred {
+		preferred = p.Preferred
+	}
+	return preferred, valid
+}
+
+// Times returns the renewal and rebinding times of an IA whose shortest
+// preferred lifetime is the given one
+func (p *Policy6) Times(preferred time.Duration) (t1, t2 time.Duration) {
+	return time.Duration(float64(preferred) * p.T1Ratio).Round(time.Second),
+		time.Duration(float64(preferred) * p.T2Ratio).Round(time.Second)
+}
+
+func optSeconds(code dhcpv6.OptionCode, d time.Duration) dhcpv6.Option {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, uint32(d/time.Second))
+	return &dhcpv6.OptionGeneric{OptionCode: code, OptionData: data}
+}
+
+// ApplyMessage adds the configured Information Refresh Time, SOL_MAX_RT and
+// INF_MAX_RT options to a response. The Information Refresh Time and
+// INF_MAX_RT only apply to replies to Information-request messages
+func (p *Policy6) ApplyMessage(msg *dhcpv6.Message, resp dhcpv6.DHCPv6) {
+	infoRequest := msg.MessageType == dhcpv6.MessageTypeInformationRequest
+	if p.InformationRefresh != 0 && infoRequest {
+		resp.UpdateOption(dhcpv6.OptInformationRefreshTime(p.InformationRefresh))
+	}
+	if p.SolMaxRT != 0 && !infoRequest && msg.IsOptionRequested(dhcpv6.OptionSolMaxRT) {
+		resp.UpdateOption(optSeconds(dhcpv6.OptionSolMaxRT, p.SolMaxRT))
+	}
+	if p.InfMaxRT != 0 && infoRequest && msg.IsOptionRequested(dhcpv6.OptionInfMaxRT) {
+		resp.UpdateOption(optSeconds(dhcpv6.OptionInfMaxRT, p.InfMaxRT))
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package leasetime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePolicy6(t *testing.T) {
+	p := NewPolicy6()
+	require.NoError(t, p.Parse("preferred=30m", "valid=1h", "t1=0.4", "t2=0.7",
+		"refresh=12h", "sol-max-rt=1h", "inf-max-rt=2h"))
+	assert.Equal(t, &Policy6{
+		Preferred:          30 * time.Minute,
+		Valid:              time.Hour,
+		T1Ratio:            0.4,
+		T2Ratio:            0.7,
+		InformationRefresh: 12 * time.Hour,
+		SolMaxRT:           time.Hour,
+		InfMaxRT:           2 * time.Hour,
+	}, p)
+
+	for _, args := range [][]string{
+		{"valid"},
+		{"foo=1h"},
+		{"preferred=2h"},
+		{"t2=0.4"},
+		{"refresh=0s"},
+		{"sol-max-rt=10s"},
+		{"inf-max-rt=48h"},
+	} {
+		assert.Error(t, NewPolicy6().Parse(args...), "args: %v", args)
+	}
+}
+
+func TestPolicy6Timers(t *testing.T) {
+	p := NewPolicy6()
+	require.NoError(t, p.Parse("preferred=30m", "valid=1h"))
+
+	preferred, valid := p.Lifetimes(time.Now().Add(time.Hour))
+	assert.Equal(t, 30*time.Minute, preferred)
+	assert.Equal(t, time.Hour, valid)
+	preferred, valid = p.Lifetimes(time.Now().Add(10 * time.Minute))
+	assert.Equal(t, 10*time.Minute, preferred)
+	assert.Equal(t, 10*time.Minute, valid)
+	preferred, valid = p.Lifetimes(time.Now().Add(-time.Minute))
+	assert.Zero(t, preferred)
+	assert.Zero(t, valid)
+
+	t1, t2 := p.Times(30 * time.Minute)
+	assert.Equal(t, 15*time.Minute, t1)
+	assert.Equal(t, 24*time.Minute, t2)
+}
+
+func TestHandler6(t *testing.T) {
+	defer SetV6(NewPolicy6())
+	_, err := setup6("2h", "preferred=1h", "refresh=12h", "sol-max-rt=1h", "inf-max-rt=2h")
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, V6().Preferred)
+	assert.Equal(t, 2*time.Hour, V6().Valid)
+
+	newMessage := func(typ dhcpv6.MessageType) *dhcpv6.Message {
+		msg, err := dhcpv6.NewMessage()
+		require.NoError(t, err)
+		msg.MessageType = typ
+		msg.AddOption(dhcpv6.OptRequestedOption(dhcpv6.OptionSolMaxRT, dhcpv6.OptionInfMaxRT))
+		return msg
+	}
+
+	req := newMessage(dhcpv6.MessageTypeInformationRequest)
+	resp, stop := Handler6(req, newMessage(dhcpv6.MessageTypeReply))
+	assert.False(t, stop)
+	assert.Equal(t, 12*time.Hour, resp.(*dhcpv6.Message).Options.InformationRefreshTime(0))
+	assert.Nil(t, resp.GetOneOption(dhcpv6.OptionSolMaxRT))
+	require.NotNil(t, resp.GetOneOption(dhcpv6.OptionInfMaxRT))
+	assert.Equal(t, []byte{0, 0, 0x1c, 0x20}, resp.GetOneOption(dhcpv6.OptionInfMaxRT).ToBytes())
+
+	req = newMessage(dhcpv6.MessageTypeSolicit)
+	resp, _ = Handler6(req, newMessage(dhcpv6.MessageTypeAdvertise))
+	assert.Nil(t, resp.GetOneOption(dhcpv6.OptionInformationRefreshTime))
+	assert.Nil(t, resp.GetOneOption(dhcpv6.OptionInfMaxRT))
+	require.NotNil(t, resp.GetOneOption(dhcpv6.OptionSolMaxRT))
+	assert.Equal(t, []byte{0, 0, 0x0e, 0x10}, resp.GetOneOption(dhcpv6.OptionSolMaxRT).ToBytes())
+}
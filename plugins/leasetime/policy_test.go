@@ -0,0 +1,117 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package leasetime
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testMAC = net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+
+func TestParsePolicy(t *testing.T) {
+	p := NewPolicy(time.Hour)
+	require.NoError(t, p.Parse("min=10m", "max=2h", "t1=0.4", "t2=0.8",
+		"class=PXEClient:Arch:00007:5m", "host=AA:BB:CC:DD:EE:FF:24h"))
+	assert.Equal(t, 10*time.Minute, p.Min)
+	assert.Equal(t, 2*time.Hour, p.Max)
+	assert.Equal(t, 0.4, p.T1Ratio)
+	assert.Equal(t, 0.8, p.T2Ratio)
+	assert.Equal(t, map[string]time.Duration{"PXEClient:Arch:00007": 5 * time.Minute}, p.Classes)
+	assert.Equal(t, map[string]time.Duration{"aa:bb:cc:dd:ee:ff": 24 * time.Hour}, p.Hosts)
+
+	for _, args := range [][]string{
+		{"min"},
+		{"foo=bar"},
+		{"min=-1s"},
+		{"t1=1.5"},
+		{"t1=0.9", "t2=0.8"},
+		{"min=2h", "max=1h"},
+		{"class=5m"},
+		{"host=aa:bb:cc:dd:ee:ff"},
+		{"host=not-a-mac:1h"},
+	} {
+		assert.Error(t, NewPolicy(time.Hour).Parse(args...), "args: %v", args)
+	}
+}
+
+func TestPolicyLeaseTime(t *testing.T) {
+	p := NewPolicy(time.Hour)
+	require.NoError(t, p.Parse("min=10m", "max=2h",
+		"class=PXEClient:5m", "class=PXEClient:Arch:00007:6m", "class=iPXE:7m"))
+
+	newRequest := func(modifiers ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+		req, err := dhcpv4.NewDiscovery(testMAC, modifiers...)
+		require.NoError(t, err)
+		return req
+	}
+
+	assert.Equal(t, time.Hour, p.LeaseTime(newRequest()))
+	assert.Equal(t, 30*time.Minute, p.LeaseTime(newRequest(dhcpv4.WithLeaseTime(1800))))
+	assert.Equal(t, 10*time.Minute, p.LeaseTime(newRequest(dhcpv4.WithLeaseTime(60))))
+	assert.Equal(t, 2*time.Hour, p.LeaseTime(newRequest(dhcpv4.WithLeaseTime(86400))))
+	assert.Equal(t, 5*time.Minute, p.LeaseTime(newRequest(
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00000")))))
+	assert.Equal(t, 6*time.Minute, p.LeaseTime(newRequest(
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00007:UNDI:003016")))))
+	assert.Equal(t, 7*time.Minute, p.LeaseTime(newRequest(
+		dhcpv4.WithOption(dhcpv4.OptUserClass("iPXE")))))
+
+	p.Hosts[testMAC.String()] = 24 * time.Hour
+	assert.Equal(t, 24*time.Hour, p.LeaseTime(newRequest(
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient")))))
+
+	// Requested lease times are ignored without bounds
+	p = NewPolicy(time.Hour)
+	assert.Equal(t, time.Hour, p.LeaseTime(newRequest(dhcpv4.WithLeaseTime(1800))))
+}
+
+func TestPolicyApply(t *testing.T) {
+	p := NewPolicy(time.Hour)
+	resp, err := dhcpv4.New()
+	require.NoError(t, err)
+	p.Apply(resp, time.Hour)
+	assert.Equal(t, time.Hour, resp.IPAddressLeaseTime(0))
+	assert.Equal(t, 30*time.Minute, resp.IPAddressRenewalTime(0))
+	assert.Equal(t, 52*time.Minute+30*time.Second, resp.IPAddressRebindingTime(0))
+
+	// Renewal and rebinding times set earlier are kept
+	resp.Options.Update(dhcpv4.Option{Code: dhcpv4.OptionRenewTimeValue, Value: dhcpv4.Duration(time.Minute)})
+	p.Apply(resp, 2*time.Hour)
+	assert.Equal(t, 2*time.Hour, resp.IPAddressLeaseTime(0))
+	assert.Equal(t, time.Minute, resp.IPAddressRenewalTime(0))
+
+	resp, err = dhcpv4.New()
+	require.NoError(t, err)
+	p.Apply(resp, dhcpv4.MaxLeaseTime)
+	assert.Equal(t, dhcpv4.MaxLeaseTime, resp.IPAddressLeaseTime(0))
+	assert.False(t, resp.Options.Has(dhcpv4.OptionRenewTimeValue))
+	assert.False(t, resp.Options.Has(dhcpv4.OptionRebindingTimeValue))
+}
+
+func TestHandler4(t *testing.T) {
+	policy = NewPolicy(time.Hour)
+	req, err := dhcpv4.NewDiscovery(testMAC)
+	require.NoError(t, err)
+
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	resp, stop := Handler4(req, stub)
+	assert.False(t, stop)
+	assert.Equal(t, time.Hour, resp.IPAddressLeaseTime(0))
+	assert.Equal(t, 30*time.Minute, resp.IPAddressRenewalTime(0))
+
+	// A lease time set by an earlier plugin is kept
+	stub, err = dhcpv4.NewReplyFromRequest(req, dhcpv4.WithLeaseTime(600))
+	require.NoError(t, err)
+	resp, _ = Handler4(req, stub)
+	assert.Equal(t, 10*time.Minute, resp.IPAddressLeaseTime(0))
+	assert.Equal(t, 5*time.Minute, resp.IPAddressRenewalTime(0))
+}
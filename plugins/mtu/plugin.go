@@ -0,0 +1,55 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package mtu
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+var log = logger.GetLogger("plugins/mtu")
+
+// Plugin wraps the MTU plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "mtu",
+	Setup4: setup4,
+}
+
+// minMTU is the smallest MTU allowed by RFC 2132 section 5.1
+const minMTU = 68
+
+var (
+	mtu uint16
+)
+
+func setup4(args ...string) (handler.Handler4, error) {
+	log.Printf("loaded plugin for DHCPv4.")
+	if len(args) != 1 {
+		return nil, errors.New("need exactly one MTU value")
+	}
+	value, err := strconv.ParseUint(args[0], 10, 16)
+	if err != nil || value < minMTU {
+		return nil, errors.New("expected an MTU between 68 and 65535, got: " + args[0])
+	}
+	mtu = uint16(value)
+	log.Infof("loaded interface MTU %d.", mtu)
+	return Handler4, nil
+}
+
+// Handler4 handles DHCPv4 packets for the mtu plugin
+func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	if req.IsOptionRequested(dhcpv4.OptionInterfaceMTU) {
+		data := make([]byte, 2)
+		binary.BigEndian.PutUint16(data, mtu)
+		resp.Options.Update(dhcpv4.OptGeneric(dhcpv4.OptionInterfaceMTU, data))
+	}
+	return resp, false
+}
@@ -0,0 +1,76 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package mtu
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestSetup4(t *testing.T) {
+	for _, arg := range []string{"", "67", "65536", "-1", "jumbo"} {
+		if _, err := setup4(arg); err == nil {
+			t.Errorf("setup succeeded with invalid MTU %q", arg)
+		}
+	}
+	if _, err := setup4("9000"); err != nil {
+		t.Fatal(err)
+	}
+	if mtu != 9000 {
+		t.Errorf("Loaded MTU %d, expected 9000", mtu)
+	}
+}
+
+func TestAddMTU4(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionInterfaceMTU))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mtu = 1400
+
+	resp, stop := Handler4(req, stub)
+	if resp == nil {
+		t.Fatal("plugin did not return a message")
+	}
+	if stop {
+		t.Error("plugin interrupted processing")
+	}
+	if value := resp.Options.Get(dhcpv4.OptionInterfaceMTU); !bytes.Equal(value, []byte{0x05, 0x78}) {
+		t.Errorf("Found MTU option %v, expected [5 120]", value)
+	}
+}
+
+func TestNotRequested4(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mtu = 1400
+
+	resp, stop := Handler4(req, stub)
+	if resp == nil {
+		t.Fatal("plugin did not return a message")
+	}
+	if stop {
+		t.Error("plugin interrupted processing")
+	}
+	if resp.Options.Has(dhcpv4.OptionInterfaceMTU) {
+		t.Error("MTU was added when not requested")
+	}
+}
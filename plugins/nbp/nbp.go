@@ -6,16 +6,55 @@
 // URL, e.g. http://[fe80::abcd:efff:fe12:3456]/my-nbp or tftp://10.0.0.1/my-nbp .
 // The NBP information is only added if it is requested by the client.
 //
+// Different NBPs can be served depending on the client system architecture
+// (DHCPv4 option 93, DHCPv6 option 61) and vendor class (`PXEClient` or
+// `HTTPClient`), with arguments in the form <selector>=<URL>. The selector is
+// one of:
+// - an architecture, by number (RFC 4578 and IANA registry) or by name: bios,
+//   efi-ia32, efi-x86_64, efi-bc, efi-arm32, efi-arm64, http-ia32,
+//   http-x86_64, http-arm32, http-arm64
+// - http or pxe, for clients with a vendor class starting with `HTTPClient`
+//   or `PXEClient` respectively
+// - ipxe, for clients that already run iPXE, identified by their user class
+//   (`iPXE` in DHCPv4 option 77 or DHCPv6 option 15) or by the iPXE
+//   encapsulated options (DHCPv4 option 175). This is the second stage of
+//   iPXE chainloading: the first stage serves the iPXE binary, which then gets
+//   e.g. an HTTP script URL instead of loading itself again
+// - default, for all the other clients. A URL without a selector is the
+//   default
+// The argument `mac-file=<file>` loads per-client overrides from a file, with
+// one client per line: MAC address, URL, and optionally the URL served once
+// the client runs iPXE. Lines starting with # are ignored. For example:
+//
+//  $ cat nbp_overrides.txt
+//  00:11:22:33:44:55 tftp://10.0.0.1/special.kpxe http://10.0.0.1/special.ipxe
+//  00:11:22:33:44:56 http://10.0.0.1/rescue.efi
+//
+// For iPXE clients, the per-client iPXE URL takes precedence over the ipxe
+// selector. Otherwise per-client URLs take precedence over architectures,
+// which take precedence over vendor classes, which take precedence over the
+// default. Clients that don't match any selector get no NBP.
+//
 // Note that for DHCPv4 the URL will be split into TFTP server name (option 66)
 // and Bootfile name (option 67), so the scheme will be stripped out, and it
 // will be treated as a TFTP URL. Anything other than host name and file path
-// will be ignored (no port, no query string, etc).
+// will be ignored (no port, no query string, etc). The BOOTP header fields are
+// set as well: `siaddr` to the server address if the host is an IP address,
+// and `file` to the file path.
+// HTTP and HTTPS URLs are passed whole as Bootfile name and `file`, as
+// expected by UEFI HTTP boot clients. Boot files longer than 127 bytes don't
+// fit in `file`, they are only sent as Bootfile name, even to clients that
+// don't request it; those longer than 255 bytes are rejected.
 //
 // For DHCPv6 OPT_BOOTFILE_URL (option 59) is used, and the value is passed
 // unmodified. If the query string is specified and contains a "param" key,
 // its value is also passed as OPT_BOOTFILE_PARAM (option 60), so it will be
 // duplicated between option 59 and 60.
 //
+// UEFI HTTP boot clients only accept replies carrying the `HTTPClient` vendor
+// class, so it is added to the replies sent to them (DHCPv4 option 60, DHCPv6
+// option 16).
+//
 // Example usage:
 //
 // server6:
@@ -24,19 +63,26 @@
 //
 // server4:
 //   - plugins:
-//     - nbp: tftp://10.0.0.254/nbp
+//     - nbp: bios=tftp://10.0.0.254/undionly.kpxe efi-x86_64=tftp://10.0.0.254/ipxe.efi ipxe=http://10.0.0.254/boot.ipxe
 //
 package nbp
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/coredhcp/coredhcp/handler"
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
 )
 
 var log = logger.GetLogger("plugins/nbp")
@@ -48,85 +94,351 @@ var Plugin = plugins.Plugin{
 	Setup4: setup4,
 }
 
-var (
-	opt59, opt60 dhcpv6.Option
+// Selectors that aren't architectures
+const (
+	selectorDefault = "default"
+	selectorHTTP    = "http"
+	selectorPXE     = "pxe"
+	selectorIPXE    = "ipxe"
+)
+
+// ipxeSuffix is appended to MAC addresses to form the selector of the
+// per-client iPXE URL
+const ipxeSuffix = "/ipxe"
+
+// userClassIPXE is the user class sent by iPXE
+const userClassIPXE = "iPXE"
+
+// optionIPXEEncap is the DHCPv4 option encapsulating the iPXE options
+const optionIPXEEncap = dhcpv4.GenericOptionCode(175)
+
+// Vendor classes of network boot clients, PXE specification and UEFI
+// specification section 24.7
+const (
+	vendorClassPXE  = "PXEClient"
+	vendorClassHTTP = "HTTPClient"
+)
+
+// enterpriseIntel is the enterprise number used in the DHCPv6 vendor class of
+// network boot clients
+const enterpriseIntel = 343
+
+// archNames maps the architecture names accepted as selectors to their type
+var archNames = map[string]iana.Arch{
+	"bios":        iana.INTEL_X86PC,
+	"efi-ia32":    iana.EFI_IA32,
+	"efi-x86_64":  iana.EFI_X86_64,
+	"efi-bc":      iana.EFI_BC,
+	"efi-arm32":   iana.EFI_ARM32,
+	"efi-arm64":   iana.EFI_ARM64,
+	"http-ia32":   iana.EFI_X86_HTTP,
+	"http-x86_64": iana.EFI_X86_64_HTTP,
+	"http-arm32":  iana.EFI_ARM32_HTTP,
+	"http-arm64":  iana.EFI_ARM64_HTTP,
+}
+
+// Lengths of the boot file name in the BOOTP `file` field, which is NUL
+// terminated, and in DHCPv4 option 67
+const (
+	maxFileField  = 127
+	maxBootFile67 = 255
+)
+
+// bootFile4 is an NBP and the way it is served to DHCPv4 clients
+type bootFile4 struct {
 	opt66, opt67 *dhcpv4.Option
+	siaddr       net.IP
+	// file is the BOOTP `file` field, empty when the boot file doesn't fit
+	file string
+}
+
+// bootFile6 is an NBP and the way it is served to DHCPv6 clients
+type bootFile6 struct {
+	opt59, opt60 dhcpv6.Option
+}
+
+var (
+	bootFiles6 map[string]*bootFile6
+	bootFiles4 map[string]*bootFile4
 )
 
-func parseArgs(args ...string) (*url.URL, error) {
-	if len(args) != 1 {
-		return nil, fmt.Errorf("Exactly one argument must be passed to NBP plugin, got %d", len(args))
+// parseSelector returns the canonical form of a selector: the architecture
+// number for architectures, the selector itself otherwise
+func parseSelector(s string) (string, error) {
+	switch s {
+	case selectorDefault, selectorHTTP, selectorPXE, selectorIPXE:
+		return s, nil
+	}
+	if arch, ok := archNames[s]; ok {
+		return strconv.Itoa(int(arch)), nil
+	}
+	if n, err := strconv.ParseUint(s, 10, 16); err == nil {
+		return strconv.Itoa(int(n)), nil
+	}
+	return "", fmt.Errorf("unknown selector `%s`", s)
+}
+
+// loadOverrides reads the per-client URLs from a file, keyed by MAC address,
+// and by MAC address followed by ipxeSuffix for iPXE URLs
+func loadOverrides(filename string) (map[string]*url.URL, error) {
+	log.Infof("reading NBP overrides from %s", filename)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	urls := make(map[string]*url.URL)
+	for _, lineBytes := range bytes.Split(data, []byte{'\n'}) {
+		line := strings.TrimSpace(string(lineBytes))
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens := strings.Fields(line)
+		if len(tokens) != 2 && len(tokens) != 3 {
+			return nil, fmt.Errorf("malformed line, want 2 or 3 fields, got %d: %s", len(tokens), line)
+		}
+		hwaddr, err := net.ParseMAC(tokens[0])
+		if err != nil {
+			return nil, fmt.Errorf("malformed hardware address: %s", tokens[0])
+		}
+		if _, ok := urls[hwaddr.String()]; ok {
+			return nil, fmt.Errorf("duplicate hardware address: %s", tokens[0])
+		}
+		for i, key := range []string{hwaddr.String(), hwaddr.String() + ipxeSuffix}[:len(tokens)-1] {
+			u, err := url.Parse(tokens[i+1])
+			if err != nil {
+				return nil, err
+			}
+			urls[key] = u
+		}
+	}
+	return urls, nil
+}
+
+// parseArgs returns the URLs given as arguments, keyed by selector
+func parseArgs(args ...string) (map[string]*url.URL, error) {
+	if len(args) < 1 {
+		return nil, errors.New("at least one argument must be passed to NBP plugin")
+	}
+	urls := make(map[string]*url.URL, len(args))
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "mac-file=") {
+			overrides, err := loadOverrides(strings.TrimPrefix(arg, "mac-file="))
+			if err != nil {
+				return nil, err
+			}
+			for k, u := range overrides {
+				urls[k] = u
+			}
+			continue
+		}
+		selector, rawURL := selectorDefault, arg
+		// Selectors never contain the characters that start a URL
+		if i := strings.Index(arg, "="); i > 0 && !strings.ContainsAny(arg[:i], ":/") {
+			var err error
+			if selector, err = parseSelector(arg[:i]); err != nil {
+				return nil, err
+			}
+			rawURL = arg[i+1:]
+		}
+		if _, ok := urls[selector]; ok {
+			return nil, fmt.Errorf("duplicate NBP for selector `%s`", selector)
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		urls[selector] = u
+	}
+	return urls, nil
+}
+
+// selectBootFile returns the selector of the NBP matching the client, or an
+// empty string if none does
+func selectBootFile(has func(string) bool, mac net.HardwareAddr, ipxe bool, archs []iana.Arch, vendorClass string) string {
+	if ipxe {
+		if mac != nil && has(mac.String()+ipxeSuffix) {
+			return mac.String() + ipxeSuffix
+		}
+		if has(selectorIPXE) {
+			return selectorIPXE
+		}
 	}
-	return url.Parse(args[0])
+	if mac != nil && has(mac.String()) {
+		return mac.String()
+	}
+	for _, arch := range archs {
+		if s := strconv.Itoa(int(arch)); has(s) {
+			return s
+		}
+	}
+	if strings.HasPrefix(vendorClass, vendorClassHTTP) && has(selectorHTTP) {
+		return selectorHTTP
+	}
+	if strings.HasPrefix(vendorClass, vendorClassPXE) && has(selectorPXE) {
+		return selectorPXE
+	}
+	if has(selectorDefault) {
+		return selectorDefault
+	}
+	return ""
+}
+
+func isHTTP(u *url.URL) bool {
+	return u.Scheme == "http" || u.Scheme == "https"
 }
 
 func setup6(args ...string) (handler.Handler6, error) {
-	u, err := parseArgs(args...)
+	urls, err := parseArgs(args...)
 	if err != nil {
 		return nil, err
 	}
-	opt59 = dhcpv6.OptBootFileURL(u.String())
-	params := u.Query().Get("params")
-	if params != "" {
-		opt60 = &dhcpv6.OptionGeneric{
-			OptionCode: dhcpv6.OptionBootfileParam,
-			OptionData: []byte(params),
+	bootFiles6 = make(map[string]*bootFile6, len(urls))
+	for selector, u := range urls {
+		bf := bootFile6{opt59: dhcpv6.OptBootFileURL(u.String())}
+		params := u.Query().Get("params")
+		if params != "" {
+			bf.opt60 = &dhcpv6.OptionGeneric{
+				OptionCode: dhcpv6.OptionBootfileParam,
+				OptionData: []byte(params),
+			}
 		}
+		bootFiles6[selector] = &bf
 	}
-	log.Printf("loaded NBP plugin for DHCPv6.")
+	log.Printf("loaded %d NBPs for DHCPv6.", len(bootFiles6))
 	return nbpHandler6, nil
 }
 
 func setup4(args ...string) (handler.Handler4, error) {
-	u, err := parseArgs(args...)
+	urls, err := parseArgs(args...)
 	if err != nil {
 		return nil, err
 	}
-	otsn := dhcpv4.OptTFTPServerName(u.Host)
-	opt66 = &otsn
-	obfn := dhcpv4.OptBootFileName(u.Path)
-	opt67 = &obfn
-	log.Printf("loaded NBP plugin for DHCPv4.")
+	bootFiles4 = make(map[string]*bootFile4, len(urls))
+	for selector, u := range urls {
+		var (
+			bf   bootFile4
+			name string
+		)
+		if isHTTP(u) {
+			name = u.String()
+		} else {
+			otsn := dhcpv4.OptTFTPServerName(u.Host)
+			bf.opt66 = &otsn
+			name = u.Path
+			bf.siaddr = net.ParseIP(u.Hostname()).To4()
+		}
+		switch {
+		case len(name) > maxBootFile67:
+			return nil, fmt.Errorf("boot file of %s is too long for DHCPv4, %d bytes at most: %s", selector, maxBootFile67, name)
+		case len(name) > maxFileField:
+			log.Warningf("Boot file of %s doesn't fit in the BOOTP file field, it is only sent in option 67: %s", selector, name)
+		default:
+			bf.file = name
+		}
+		obfn := dhcpv4.OptBootFileName(name)
+		bf.opt67 = &obfn
+		bootFiles4[selector] = &bf
+	}
+	log.Printf("loaded %d NBPs for DHCPv4.", len(bootFiles4))
 	return nbpHandler4, nil
 }
 
-func nbpHandler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
-	if opt59 == nil {
-		// nothing to do
-		return resp, true
+// vendorClass6 returns the network boot vendor class of a DHCPv6 client, if
+// any
+func vendorClass6(msg *dhcpv6.Message) string {
+	for _, opt := range msg.Options.Get(dhcpv6.OptionVendorClass) {
+		vc, ok := opt.(*dhcpv6.OptVendorClass)
+		if !ok || vc.EnterpriseNumber != enterpriseIntel {
+			continue
+		}
+		for _, data := range vc.Data {
+			if s := string(data); strings.HasPrefix(s, vendorClassPXE) || strings.HasPrefix(s, vendorClassHTTP) {
+				return s
+			}
+		}
 	}
+	return ""
+}
+
+func nbpHandler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 	decap, err := req.GetInnerMessage()
 	if err != nil {
 		log.Errorf("Could not decapsulate request: %v", err)
 		// drop the request, this is probably a critical error in the packet.
 		return nil, true
 	}
+	vendorClass := vendorClass6(decap)
+	ipxe := false
+	for _, uc := range decap.Options.UserClasses() {
+		ipxe = ipxe || string(uc) == userClassIPXE
+	}
+	mac, err := dhcpv6.ExtractMAC(req)
+	if err != nil {
+		mac = nil
+	}
+	selector := selectBootFile(func(s string) bool {
+		_, ok := bootFiles6[s]
+		return ok
+	}, mac, ipxe, decap.Options.ArchTypes(), vendorClass)
+	if selector == "" {
+		// nothing to do
+		return resp, true
+	}
+	bf := bootFiles6[selector]
 	for _, code := range decap.Options.RequestedOptions() {
 		if code == dhcpv6.OptionBootfileURL {
 			// bootfile URL is requested
-			resp.AddOption(opt59)
+			resp.AddOption(bf.opt59)
 		} else if code == dhcpv6.OptionBootfileParam {
 			// optionally add opt60, bootfile params, if requested
-			if opt60 != nil {
-				resp.AddOption(opt60)
+			if bf.opt60 != nil {
+				resp.AddOption(bf.opt60)
 			}
 		}
 	}
-	log.Debugf("Added NBP %s to request", opt59)
+	if strings.HasPrefix(vendorClass, vendorClassHTTP) {
+		resp.AddOption(&dhcpv6.OptVendorClass{
+			EnterpriseNumber: enterpriseIntel,
+			Data:             [][]byte{[]byte(vendorClassHTTP)},
+		})
+	}
+	log.Debugf("Added NBP %s to request (selector %s)", bf.opt59, selector)
 	return resp, true
 }
 
 func nbpHandler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
-	if opt66 == nil || opt67 == nil {
+	vendorClass := req.ClassIdentifier()
+	archs := req.ClientArch()
+	ipxe := req.Options.Has(optionIPXEEncap)
+	for _, uc := range req.UserClass() {
+		ipxe = ipxe || uc == userClassIPXE
+	}
+	selector := selectBootFile(func(s string) bool {
+		_, ok := bootFiles4[s]
+		return ok
+	}, req.ClientHWAddr, ipxe, archs, vendorClass)
+	if selector == "" {
 		// nothing to do
 		return resp, true
 	}
-	if req.IsOptionRequested(dhcpv4.OptionTFTPServerName) {
-		resp.Options.Update(*opt66)
+	bf := bootFiles4[selector]
+	if bf.opt66 != nil && req.IsOptionRequested(dhcpv4.OptionTFTPServerName) {
+		resp.Options.Update(*bf.opt66)
+	}
+	// Only network boot clients get the BOOTP header fields
+	netboot := len(archs) > 0 || ipxe || strings.HasPrefix(vendorClass, vendorClassPXE) || strings.HasPrefix(vendorClass, vendorClassHTTP)
+	if req.IsOptionRequested(dhcpv4.OptionBootfileName) || (netboot && bf.file == "") {
+		resp.Options.Update(*bf.opt67)
+	}
+	if netboot {
+		if bf.siaddr != nil {
+			resp.ServerIPAddr = bf.siaddr
+		}
+		resp.BootFileName = bf.file
 	}
-	if req.IsOptionRequested(dhcpv4.OptionBootfileName) {
-		resp.Options.Update(*opt67)
+	if strings.HasPrefix(vendorClass, vendorClassHTTP) {
+		resp.Options.Update(dhcpv4.OptClassIdentifier(vendorClassHTTP))
 	}
-	log.Debugf("Added NBP %s / %s to request", opt66, opt67)
+	log.Debugf("Added NBP %s / %s to request (selector %s)", bf.opt66, bf.opt67, selector)
 	return resp, true
 }
@@ -0,0 +1,312 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package nbp
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testMAC = net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+
+func TestParseArgs(t *testing.T) {
+	urls, err := parseArgs("tftp://10.0.0.1/default.kpxe")
+	require.NoError(t, err)
+	assert.Equal(t, "tftp://10.0.0.1/default.kpxe", urls[selectorDefault].String())
+
+	urls, err = parseArgs("bios=tftp://10.0.0.1/undionly.kpxe", "11=tftp://10.0.0.1/arm64.efi",
+		"http=http://10.0.0.1/boot.efi?a=b", "http://10.0.0.1/x?c=d")
+	require.NoError(t, err)
+	assert.Len(t, urls, 4)
+	assert.Equal(t, "tftp://10.0.0.1/undionly.kpxe", urls["0"].String())
+	assert.Equal(t, "tftp://10.0.0.1/arm64.efi", urls["11"].String())
+	assert.Equal(t, "http://10.0.0.1/boot.efi?a=b", urls[selectorHTTP].String())
+	assert.Equal(t, "http://10.0.0.1/x?c=d", urls[selectorDefault].String())
+
+	for _, args := range [][]string{
+		{},
+		{"foo=tftp://10.0.0.1/x"},
+		{"tftp://10.0.0.1/x", "default=tftp://10.0.0.1/y"},
+		{"bios=tftp://10.0.0.1/x", "0=tftp://10.0.0.1/y"},
+	} {
+		_, err := parseArgs(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestSelectBootFile(t *testing.T) {
+	configured := map[string]bool{"0": true, "7": true, selectorHTTP: true, selectorDefault: true}
+	has := func(s string) bool { return configured[s] }
+
+	assert.Equal(t, "0", selectBootFile(has, nil, false, []iana.Arch{iana.INTEL_X86PC}, "PXEClient:Arch:00000:UNDI:002001"))
+	assert.Equal(t, "7", selectBootFile(has, nil, false, []iana.Arch{iana.EFI_ARM64, iana.EFI_X86_64}, ""))
+	assert.Equal(t, selectorHTTP, selectBootFile(has, nil, false, []iana.Arch{iana.EFI_X86_64_HTTP}, "HTTPClient:Arch:00016:UNDI:003001"))
+	assert.Equal(t, selectorDefault, selectBootFile(has, nil, false, []iana.Arch{iana.EFI_ARM64}, "PXEClient"))
+	assert.Equal(t, selectorDefault, selectBootFile(has, nil, false, nil, ""))
+
+	delete(configured, selectorDefault)
+	assert.Equal(t, "", selectBootFile(has, nil, false, []iana.Arch{iana.EFI_ARM64}, "PXEClient"))
+
+	// iPXE chainloading and per-client overrides
+	other := net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0x00}
+	configured[testMAC.String()] = true
+	configured[testMAC.String()+ipxeSuffix] = true
+	configured[other.String()] = true
+	configured[selectorIPXE] = true
+	assert.Equal(t, "7", selectBootFile(has, nil, false, []iana.Arch{iana.EFI_X86_64}, "PXEClient"))
+	assert.Equal(t, selectorIPXE, selectBootFile(has, nil, true, []iana.Arch{iana.EFI_X86_64}, "PXEClient"))
+	assert.Equal(t, testMAC.String(), selectBootFile(has, testMAC, false, []iana.Arch{iana.EFI_X86_64}, "PXEClient"))
+	assert.Equal(t, testMAC.String()+ipxeSuffix, selectBootFile(has, testMAC, true, []iana.Arch{iana.EFI_X86_64}, "PXEClient"))
+	assert.Equal(t, other.String(), selectBootFile(has, other, false, []iana.Arch{iana.EFI_X86_64}, ""))
+	assert.Equal(t, selectorIPXE, selectBootFile(has, other, true, []iana.Arch{iana.EFI_X86_64}, ""))
+}
+
+func TestHandler4(t *testing.T) {
+	_, err := setup4(
+		"bios=tftp://10.0.0.1/undionly.kpxe",
+		"efi-x86_64=tftp://boot.example.com/ipxe.efi",
+		"http=http://10.0.0.1/boot.efi",
+	)
+	require.NoError(t, err)
+
+	// Legacy BIOS PXE client
+	req, err := dhcpv4.NewDiscovery(testMAC,
+		dhcpv4.WithOption(dhcpv4.OptClientArch(iana.INTEL_X86PC)),
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00000:UNDI:002001")),
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionTFTPServerName, dhcpv4.OptionBootfileName),
+	)
+	require.NoError(t, err)
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	resp, _ := nbpHandler4(req, stub)
+	assert.Equal(t, "10.0.0.1", resp.TFTPServerName())
+	assert.Equal(t, "/undionly.kpxe", resp.BootFileNameOption())
+	assert.True(t, resp.ServerIPAddr.Equal(net.IPv4(10, 0, 0, 1)))
+	assert.Equal(t, "/undionly.kpxe", resp.BootFileName)
+	assert.False(t, resp.Options.Has(dhcpv4.OptionClassIdentifier))
+
+	// UEFI x86-64 PXE client, TFTP server given by name
+	req, err = dhcpv4.NewDiscovery(testMAC,
+		dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_X86_64)),
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionTFTPServerName, dhcpv4.OptionBootfileName),
+	)
+	require.NoError(t, err)
+	stub, err = dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	resp, _ = nbpHandler4(req, stub)
+	assert.Equal(t, "boot.example.com", resp.TFTPServerName())
+	assert.Equal(t, "/ipxe.efi", resp.BootFileName)
+	assert.True(t, resp.ServerIPAddr.IsUnspecified())
+
+	// UEFI HTTP boot client
+	req, err = dhcpv4.NewDiscovery(testMAC,
+		dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_X86_64_HTTP)),
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("HTTPClient:Arch:00016:UNDI:003001")),
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionTFTPServerName, dhcpv4.OptionBootfileName),
+	)
+	require.NoError(t, err)
+	stub, err = dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	resp, _ = nbpHandler4(req, stub)
+	assert.False(t, resp.Options.Has(dhcpv4.OptionTFTPServerName))
+	assert.Equal(t, "http://10.0.0.1/boot.efi", resp.BootFileNameOption())
+	assert.Equal(t, "http://10.0.0.1/boot.efi", resp.BootFileName)
+	assert.Equal(t, "HTTPClient", resp.ClassIdentifier())
+
+	// Not a network boot client, and no default
+	req, err = dhcpv4.NewDiscovery(testMAC)
+	require.NoError(t, err)
+	stub, err = dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	resp, _ = nbpHandler4(req, stub)
+	assert.False(t, resp.Options.Has(dhcpv4.OptionBootfileName))
+	assert.Equal(t, "", resp.BootFileName)
+}
+
+func TestHandler4LongURL(t *testing.T) {
+	long := "http://10.0.0.1/" + strings.Repeat("a", 120) + ".efi"
+	_, err := setup4(long)
+	require.NoError(t, err)
+
+	// The URL is only sent in option 67, even when not requested
+	req, err := dhcpv4.NewDiscovery(testMAC,
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("HTTPClient:Arch:00016:UNDI:003001")),
+	)
+	require.NoError(t, err)
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	resp, _ := nbpHandler4(req, stub)
+	assert.Equal(t, long, resp.BootFileNameOption())
+	assert.Equal(t, "", resp.BootFileName)
+
+	_, err = setup4("http://10.0.0.1/" + strings.Repeat("a", 250))
+	assert.Error(t, err)
+}
+
+func writeOverrides(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "nbp_overrides")
+	require.NoError(t, err)
+	_, err = f.WriteString(content)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	return f.Name()
+}
+
+func TestLoadOverrides(t *testing.T) {
+	filename := writeOverrides(t, `# per-client NBPs
+AA:BB:CC:DD:EE:FF tftp://10.0.0.1/special.kpxe http://10.0.0.1/special.ipxe
+
+aa:bb:cc:dd:ee:00 http://10.0.0.1/rescue.efi
+`)
+	defer os.Remove(filename)
+	urls, err := loadOverrides(filename)
+	require.NoError(t, err)
+	assert.Len(t, urls, 3)
+	assert.Equal(t, "tftp://10.0.0.1/special.kpxe", urls["aa:bb:cc:dd:ee:ff"].String())
+	assert.Equal(t, "http://10.0.0.1/special.ipxe", urls["aa:bb:cc:dd:ee:ff/ipxe"].String())
+	assert.Equal(t, "http://10.0.0.1/rescue.efi", urls["aa:bb:cc:dd:ee:00"].String())
+
+	for _, content := range []string{
+		"aa:bb:cc:dd:ee:ff\n",
+		"aa:bb:cc:dd:ee:ff tftp://10.0.0.1/a tftp://10.0.0.1/b tftp://10.0.0.1/c\n",
+		"not-a-mac tftp://10.0.0.1/a\n",
+		"aa:bb:cc:dd:ee:ff tftp://10.0.0.1/a\naa:bb:cc:dd:ee:ff tftp://10.0.0.1/b\n",
+	} {
+		filename := writeOverrides(t, content)
+		_, err := loadOverrides(filename)
+		assert.Error(t, err, content)
+		os.Remove(filename)
+	}
+	_, err = loadOverrides("/nonexistent/nbp_overrides")
+	assert.Error(t, err)
+}
+
+func TestHandler4IPXE(t *testing.T) {
+	filename := writeOverrides(t, "aa:bb:cc:dd:ee:00 tftp://10.0.0.2/special.kpxe http://10.0.0.2/special.ipxe\n")
+	defer os.Remove(filename)
+	_, err := setup4(
+		"efi-x86_64=tftp://10.0.0.1/ipxe.efi",
+		"ipxe=http://10.0.0.1/boot.ipxe",
+		"mac-file="+filename,
+	)
+	require.NoError(t, err)
+
+	newRequest := func(mac net.HardwareAddr, modifiers ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+		modifiers = append(modifiers,
+			dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_X86_64)),
+			dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00007:UNDI:003016")),
+			dhcpv4.WithRequestedOptions(dhcpv4.OptionTFTPServerName, dhcpv4.OptionBootfileName),
+		)
+		req, err := dhcpv4.NewDiscovery(mac, modifiers...)
+		require.NoError(t, err)
+		return req
+	}
+	handle := func(req *dhcpv4.DHCPv4) *dhcpv4.DHCPv4 {
+		stub, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		resp, _ := nbpHandler4(req, stub)
+		return resp
+	}
+
+	// First stage: the firmware PXE client gets iPXE
+	resp := handle(newRequest(testMAC))
+	assert.Equal(t, "/ipxe.efi", resp.BootFileNameOption())
+
+	// Second stage: iPXE identifies itself with its user class...
+	resp = handle(newRequest(testMAC, dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.OptionUserClassInformation, []byte("iPXE")))))
+	assert.Equal(t, "http://10.0.0.1/boot.ipxe", resp.BootFileNameOption())
+	assert.Equal(t, "http://10.0.0.1/boot.ipxe", resp.BootFileName)
+	assert.False(t, resp.Options.Has(dhcpv4.OptionTFTPServerName))
+
+	// ... or with its encapsulated options
+	resp = handle(newRequest(testMAC, dhcpv4.WithOption(dhcpv4.OptGeneric(optionIPXEEncap, []byte{0xb1, 1, 1}))))
+	assert.Equal(t, "http://10.0.0.1/boot.ipxe", resp.BootFileNameOption())
+
+	// Per-client overrides, for both stages
+	other := net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0x00}
+	resp = handle(newRequest(other))
+	assert.Equal(t, "/special.kpxe", resp.BootFileNameOption())
+	assert.Equal(t, "10.0.0.2", resp.TFTPServerName())
+	resp = handle(newRequest(other, dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.OptionUserClassInformation, []byte("iPXE")))))
+	assert.Equal(t, "http://10.0.0.2/special.ipxe", resp.BootFileNameOption())
+}
+
+func TestHandler6(t *testing.T) {
+	_, err := setup6(
+		"efi-arm64=tftp://[2001:db8::1]/arm64.efi",
+		"http=http://[2001:db8::1]/boot.efi?params=console",
+		"tftp://[2001:db8::1]/default.efi",
+	)
+	require.NoError(t, err)
+
+	newRequest := func(opts ...dhcpv6.Option) *dhcpv6.Message {
+		req, err := dhcpv6.NewMessage()
+		require.NoError(t, err)
+		req.MessageType = dhcpv6.MessageTypeSolicit
+		req.AddOption(dhcpv6.OptRequestedOption(dhcpv6.OptionBootfileURL, dhcpv6.OptionBootfileParam))
+		for _, opt := range opts {
+			req.AddOption(opt)
+		}
+		return req
+	}
+	newStub := func() *dhcpv6.Message {
+		stub, err := dhcpv6.NewMessage()
+		require.NoError(t, err)
+		stub.MessageType = dhcpv6.MessageTypeAdvertise
+		return stub
+	}
+
+	resp, _ := nbpHandler6(newRequest(dhcpv6.OptClientArchType(iana.EFI_ARM64)), newStub())
+	opts := resp.(*dhcpv6.Message).Options
+	assert.Equal(t, "tftp://[2001:db8::1]/arm64.efi", opts.BootFileURL())
+	assert.Nil(t, opts.GetOne(dhcpv6.OptionVendorClass))
+
+	resp, _ = nbpHandler6(newRequest(
+		dhcpv6.OptClientArchType(iana.EFI_X86_64_HTTP),
+		&dhcpv6.OptVendorClass{
+			EnterpriseNumber: enterpriseIntel,
+			Data:             [][]byte{[]byte("HTTPClient:Arch:00016:UNDI:003001")},
+		},
+	), newStub())
+	opts = resp.(*dhcpv6.Message).Options
+	assert.Equal(t, "http://[2001:db8::1]/boot.efi?params=console", opts.BootFileURL())
+	assert.NotNil(t, opts.GetOne(dhcpv6.OptionBootfileParam))
+	vc, ok := opts.GetOne(dhcpv6.OptionVendorClass).(*dhcpv6.OptVendorClass)
+	require.True(t, ok)
+	assert.Equal(t, [][]byte{[]byte("HTTPClient")}, vc.Data)
+
+	resp, _ = nbpHandler6(newRequest(), newStub())
+	assert.Equal(t, "tftp://[2001:db8::1]/default.efi", resp.(*dhcpv6.Message).Options.BootFileURL())
+}
+
+func TestHandler6IPXE(t *testing.T) {
+	_, err := setup6(
+		"efi-x86_64=tftp://[2001:db8::1]/ipxe.efi",
+		"ipxe=http://[2001:db8::1]/boot.ipxe",
+	)
+	require.NoError(t, err)
+
+	req, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeSolicit
+	req.AddOption(dhcpv6.OptRequestedOption(dhcpv6.OptionBootfileURL))
+	req.AddOption(dhcpv6.OptClientArchType(iana.EFI_X86_64))
+	req.AddOption(&dhcpv6.OptUserClass{UserClasses: [][]byte{[]byte("iPXE")}})
+	stub, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	stub.MessageType = dhcpv6.MessageTypeAdvertise
+
+	resp, _ := nbpHandler6(req, stub)
+	assert.Equal(t, "http://[2001:db8::1]/boot.ipxe", resp.(*dhcpv6.Message).Options.BootFileURL())
+}
@@ -0,0 +1,85 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package netbios
+
+// This plugin sets the NetBIOS over TCP/IP name servers (WINS, option 44) and
+// node type (option 46), RFC 2132 section 8.
+// The arguments are the name server addresses, and optionally the node type as
+// `node-type=<B|P|M|H>`.
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+var log = logger.GetLogger("plugins/netbios")
+
+// Plugin wraps the NetBIOS plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "netbios",
+	Setup4: setup4,
+}
+
+// nodeTypes maps the node type names to their value in option 46
+var nodeTypes = map[string]byte{
+	"B": 0x1, // broadcast
+	"P": 0x2, // point-to-point, using the name servers
+	"M": 0x4, // mixed: broadcast, then point-to-point
+	"H": 0x8, // hybrid: point-to-point, then broadcast
+}
+
+var (
+	nameServers []net.IP
+	nodeType    byte
+)
+
+func setup4(args ...string) (handler.Handler4, error) {
+	log.Printf("loaded plugin for DHCPv4.")
+	nameServers, nodeType = nil, 0
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "node-type=") {
+			t, ok := nodeTypes[strings.ToUpper(strings.TrimPrefix(arg, "node-type="))]
+			if !ok {
+				return nil, errors.New("expected a node type among B, P, M and H, got: " + arg)
+			}
+			nodeType = t
+			continue
+		}
+		server := net.ParseIP(arg)
+		if server.To4() == nil {
+			return nil, errors.New("expected a NetBIOS name server IPv4 address, got: " + arg)
+		}
+		nameServers = append(nameServers, server.To4())
+	}
+	if len(nameServers) == 0 && nodeType == 0 {
+		return nil, errors.New("need at least one name server or a node type")
+	}
+	// Point-to-point resolution can't work without name servers
+	if len(nameServers) == 0 && nodeType != nodeTypes["B"] {
+		return nil, errors.New("node types other than B need at least one name server")
+	}
+	log.Infof("loaded %d NetBIOS name servers.", len(nameServers))
+	return Handler4, nil
+}
+
+// Handler4 handles DHCPv4 packets for the netbios plugin
+func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	if len(nameServers) > 0 && req.IsOptionRequested(dhcpv4.OptionNetBIOSOverTCPIPNameServer) {
+		resp.Options.Update(dhcpv4.Option{
+			Code:  dhcpv4.OptionNetBIOSOverTCPIPNameServer,
+			Value: dhcpv4.IPs(nameServers),
+		})
+	}
+	if nodeType != 0 && req.IsOptionRequested(dhcpv4.OptionNetBIOSOverTCPIPNodeType) {
+		resp.Options.Update(dhcpv4.OptGeneric(dhcpv4.OptionNetBIOSOverTCPIPNodeType, []byte{nodeType}))
+	}
+	return resp, false
+}
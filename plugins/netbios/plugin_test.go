@@ -0,0 +1,103 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package netbios
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestSetup4(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"node-type=X", "192.0.2.1"},
+		{"2001:db8::1"},
+		{"node-type=H"},
+	} {
+		if _, err := setup4(args...); err == nil {
+			t.Errorf("setup succeeded with invalid arguments %v", args)
+		}
+	}
+	if _, err := setup4("node-type=B"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := setup4("192.0.2.1", "node-type=h"); err != nil {
+		t.Fatal(err)
+	}
+	if len(nameServers) != 1 || nodeType != 0x8 {
+		t.Errorf("Loaded %v and node type %d, expected 1 server and node type 8", nameServers, nodeType)
+	}
+}
+
+func TestAddNetBIOS4(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		dhcpv4.WithRequestedOptions(
+			dhcpv4.OptionNetBIOSOverTCPIPNameServer,
+			dhcpv4.OptionNetBIOSOverTCPIPNodeType,
+		))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nameServers = []net.IP{
+		net.ParseIP("192.0.2.1").To4(),
+		net.ParseIP("192.0.2.3").To4(),
+	}
+	nodeType = 0x8
+
+	resp, stop := Handler4(req, stub)
+	if resp == nil {
+		t.Fatal("plugin did not return a message")
+	}
+	if stop {
+		t.Error("plugin interrupted processing")
+	}
+	servers := dhcpv4.GetIPs(dhcpv4.OptionNetBIOSOverTCPIPNameServer, resp.Options)
+	for i, srv := range servers {
+		if !srv.Equal(nameServers[i]) {
+			t.Errorf("Found server %s, expected %s", srv, nameServers[i])
+		}
+	}
+	if len(servers) != len(nameServers) {
+		t.Errorf("Found %d servers, expected %d", len(servers), len(nameServers))
+	}
+	if value := resp.Options.Get(dhcpv4.OptionNetBIOSOverTCPIPNodeType); !bytes.Equal(value, []byte{0x8}) {
+		t.Errorf("Found node type %v, expected [8]", value)
+	}
+}
+
+func TestNotRequested4(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nameServers = []net.IP{
+		net.ParseIP("192.0.2.1").To4(),
+	}
+	nodeType = 0x8
+
+	resp, stop := Handler4(req, stub)
+	if resp == nil {
+		t.Fatal("plugin did not return a message")
+	}
+	if stop {
+		t.Error("plugin interrupted processing")
+	}
+	if resp.Options.Has(dhcpv4.OptionNetBIOSOverTCPIPNameServer) || resp.Options.Has(dhcpv4.OptionNetBIOSOverTCPIPNodeType) {
+		t.Error("NetBIOS options were added when not requested")
+	}
+}
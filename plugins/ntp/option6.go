@@ -0,0 +1,77 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package ntp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+)
+
+// Suboptions of the NTP Server option, RFC 5908 section 4
+const (
+	ntpSuboptionSrvAddr uint16 = 1
+	ntpSuboptionMCAddr  uint16 = 2
+	ntpSuboptionSrvFQDN uint16 = 3
+)
+
+// ntpServer is a time source, given either by address or by name
+type ntpServer struct {
+	addr net.IP
+	fqdn string
+}
+
+// suboption returns the code and content of the suboption describing the
+// time source
+func (s ntpServer) suboption() (uint16, []byte) {
+	if s.fqdn != "" {
+		return ntpSuboptionSrvFQDN, (&rfc1035label.Labels{Labels: []string{s.fqdn}}).ToBytes()
+	}
+	if s.addr.IsMulticast() {
+		return ntpSuboptionMCAddr, s.addr.To16()
+	}
+	return ntpSuboptionSrvAddr, s.addr.To16()
+}
+
+func (s ntpServer) String() string {
+	if s.fqdn != "" {
+		return s.fqdn
+	}
+	return s.addr.String()
+}
+
+// optNTPServer is the DHCPv6 NTP Server option, RFC 5908
+type optNTPServer []ntpServer
+
+// Code implements dhcpv6.Option.Code
+func (o optNTPServer) Code() dhcpv6.OptionCode {
+	return dhcpv6.OptionNTPServer
+}
+
+// ToBytes implements dhcpv6.Option.ToBytes
+func (o optNTPServer) ToBytes() []byte {
+	var buf []byte
+	for _, s := range o {
+		code, data := s.suboption()
+		hdr := make([]byte, 4)
+		binary.BigEndian.PutUint16(hdr[0:2], code)
+		binary.BigEndian.PutUint16(hdr[2:4], uint16(len(data)))
+		buf = append(append(buf, hdr...), data...)
+	}
+	return buf
+}
+
+// String implements dhcpv6.Option.String
+func (o optNTPServer) String() string {
+	servers := make([]string, 0, len(o))
+	for _, s := range o {
+		servers = append(servers, s.String())
+	}
+	return fmt.Sprintf("NTP Server: [%s]", strings.Join(servers, ", "))
+}
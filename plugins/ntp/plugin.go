@@ -0,0 +1,127 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package ntp
+
+// This plugin advertises NTP servers: with option 42 for DHCPv4, and with the
+// NTP Server option (56, RFC 5908) and the legacy SNTP option (31, RFC 4075)
+// for DHCPv6.
+// DHCPv6 servers can be given by unicast address, multicast address or name;
+// only unicast addresses are sent in the SNTP option.
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+var log = logger.GetLogger("plugins/ntp")
+
+// Plugin wraps the NTP plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "ntp",
+	Setup6: setup6,
+	Setup4: setup4,
+}
+
+var (
+	ntpServers6  optNTPServer
+	sntpServers6 []net.IP
+	ntpServers4  []net.IP
+)
+
+// validName checks that a server name is a syntactically valid FQDN
+func validName(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	log.Printf("loaded plugin for DHCPv6.")
+	if len(args) < 1 {
+		return nil, errors.New("need at least one NTP server")
+	}
+	for _, arg := range args {
+		if ip := net.ParseIP(arg); ip != nil {
+			if ip.To4() != nil {
+				return nil, errors.New("expected an NTP server IPv6 address, got: " + arg)
+			}
+			ntpServers6 = append(ntpServers6, ntpServer{addr: ip})
+			if !ip.IsMulticast() {
+				sntpServers6 = append(sntpServers6, ip)
+			}
+			continue
+		}
+		name := strings.TrimSuffix(arg, ".")
+		if !validName(name) {
+			return nil, errors.New("expected an NTP server address or name, got: " + arg)
+		}
+		ntpServers6 = append(ntpServers6, ntpServer{fqdn: name})
+	}
+	log.Infof("loaded %d NTP servers.", len(ntpServers6))
+	return Handler6, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	log.Printf("loaded plugin for DHCPv4.")
+	if len(args) < 1 {
+		return nil, errors.New("need at least one NTP server")
+	}
+	for _, arg := range args {
+		server := net.ParseIP(arg)
+		if server.To4() == nil {
+			return nil, errors.New("expected an NTP server IPv4 address, got: " + arg)
+		}
+		ntpServers4 = append(ntpServers4, server.To4())
+	}
+	log.Infof("loaded %d NTP servers.", len(ntpServers4))
+	return Handler4, nil
+}
+
+// Handler6 handles DHCPv6 packets for the ntp plugin
+func Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	decap, err := req.GetInnerMessage()
+	if err != nil {
+		log.Errorf("Could not decapsulate relayed message, aborting: %v", err)
+		return nil, true
+	}
+
+	if decap.IsOptionRequested(dhcpv6.OptionNTPServer) {
+		resp.UpdateOption(ntpServers6)
+	}
+	if len(sntpServers6) > 0 && decap.IsOptionRequested(dhcpv6.OptionSNTPServerList) {
+		var data []byte
+		for _, ip := range sntpServers6 {
+			data = append(data, ip.To16()...)
+		}
+		resp.UpdateOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionSNTPServerList, OptionData: data})
+	}
+	return resp, false
+}
+
+// Handler4 handles DHCPv4 packets for the ntp plugin
+func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	if req.IsOptionRequested(dhcpv4.OptionNTPServers) {
+		resp.Options.Update(dhcpv4.OptNTPServers(ntpServers4...))
+	}
+	return resp, false
+}
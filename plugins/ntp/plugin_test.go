@@ -0,0 +1,201 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package ntp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func TestSetup6(t *testing.T) {
+	ntpServers6, sntpServers6 = nil, nil
+	for _, arg := range []string{"192.0.2.1", "ntp..example.com", "ntp_1.example.com"} {
+		if _, err := setup6(arg); err == nil {
+			t.Errorf("setup succeeded with invalid server %q", arg)
+		}
+	}
+	ntpServers6, sntpServers6 = nil, nil
+	if _, err := setup6("2001:db8::123", "ff05::101", "ntp.example.com."); err != nil {
+		t.Fatal(err)
+	}
+	if len(ntpServers6) != 3 {
+		t.Errorf("Loaded %d NTP servers, expected 3", len(ntpServers6))
+	}
+	if len(sntpServers6) != 1 {
+		t.Errorf("Loaded %d SNTP servers, expected 1", len(sntpServers6))
+	}
+}
+
+func TestNTPServerOption(t *testing.T) {
+	opt := optNTPServer{
+		{addr: net.ParseIP("2001:db8::1")},
+		{addr: net.ParseIP("ff05::101")},
+		{fqdn: "ntp.example.com"},
+	}
+	expected := []byte{
+		0, 1, 0, 16, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+		0, 2, 0, 16, 0xff, 0x05, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0x01,
+		0, 3, 0, 17, 3, 'n', 't', 'p', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+	}
+	if data := opt.ToBytes(); !bytes.Equal(data, expected) {
+		t.Errorf("Encoded option as %v, expected %v", data, expected)
+	}
+}
+
+func TestAddServer6(t *testing.T) {
+	req, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.MessageType = dhcpv6.MessageTypeRequest
+	req.AddOption(dhcpv6.OptRequestedOption(dhcpv6.OptionNTPServer, dhcpv6.OptionSNTPServerList))
+
+	stub, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.MessageType = dhcpv6.MessageTypeReply
+
+	ntpServers6 = optNTPServer{
+		{addr: net.ParseIP("2001:db8::1")},
+		{fqdn: "ntp.example.com"},
+	}
+	sntpServers6 = []net.IP{net.ParseIP("2001:db8::1")}
+
+	resp, stop := Handler6(req, stub)
+	if resp == nil {
+		t.Fatal("plugin did not return a message")
+	}
+	if stop {
+		t.Error("plugin interrupted processing")
+	}
+	opts := resp.GetOption(dhcpv6.OptionNTPServer)
+	if len(opts) != 1 {
+		t.Fatalf("Expected 1 NTP server option, got %d: %v", len(opts), opts)
+	}
+	if !bytes.Equal(opts[0].ToBytes(), ntpServers6.ToBytes()) {
+		t.Errorf("Found NTP server option %v, expected %v", opts[0], ntpServers6)
+	}
+	sntp := resp.GetOneOption(dhcpv6.OptionSNTPServerList)
+	if sntp == nil {
+		t.Fatal("SNTP option missing")
+	}
+	if !bytes.Equal(sntp.ToBytes(), net.ParseIP("2001:db8::1")) {
+		t.Errorf("Found SNTP servers %v, expected 2001:db8::1", sntp.ToBytes())
+	}
+}
+
+func TestNotRequested6(t *testing.T) {
+	req, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.MessageType = dhcpv6.MessageTypeRequest
+	req.AddOption(dhcpv6.OptRequestedOption(dhcpv6.OptionDNSRecursiveNameServer))
+
+	stub, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.MessageType = dhcpv6.MessageTypeReply
+
+	ntpServers6 = optNTPServer{{addr: net.ParseIP("2001:db8::1")}}
+	sntpServers6 = []net.IP{net.ParseIP("2001:db8::1")}
+
+	resp, stop := Handler6(req, stub)
+	if resp == nil {
+		t.Fatal("plugin did not return a message")
+	}
+	if stop {
+		t.Error("plugin interrupted processing")
+	}
+	if opts := resp.GetOption(dhcpv6.OptionNTPServer); len(opts) != 0 {
+		t.Errorf("NTP server options were added when not requested: %v", opts)
+	}
+	if opts := resp.GetOption(dhcpv6.OptionSNTPServerList); len(opts) != 0 {
+		t.Errorf("SNTP options were added when not requested: %v", opts)
+	}
+}
+
+func TestSetup4(t *testing.T) {
+	ntpServers4 = nil
+	if _, err := setup4(); err == nil {
+		t.Error("setup succeeded without any server")
+	}
+	if _, err := setup4("2001:db8::1"); err == nil {
+		t.Error("setup succeeded with an IPv6 server")
+	}
+	ntpServers4 = nil
+	if _, err := setup4("192.0.2.1", "192.0.2.2"); err != nil {
+		t.Fatal(err)
+	}
+	if len(ntpServers4) != 2 {
+		t.Errorf("Loaded %d servers, expected 2", len(ntpServers4))
+	}
+}
+
+func TestAddServer4(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionNTPServers))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ntpServers4 = []net.IP{
+		net.ParseIP("192.0.2.1"),
+		net.ParseIP("192.0.2.3"),
+	}
+
+	resp, stop := Handler4(req, stub)
+	if resp == nil {
+		t.Fatal("plugin did not return a message")
+	}
+	if stop {
+		t.Error("plugin interrupted processing")
+	}
+	servers := resp.NTPServers()
+	for i, srv := range servers {
+		if !srv.Equal(ntpServers4[i]) {
+			t.Errorf("Found server %s, expected %s", srv, ntpServers4[i])
+		}
+	}
+	if len(servers) != len(ntpServers4) {
+		t.Errorf("Found %d servers, expected %d", len(servers), len(ntpServers4))
+	}
+}
+
+func TestNotRequested4(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ntpServers4 = []net.IP{
+		net.ParseIP("192.0.2.1"),
+	}
+
+	resp, stop := Handler4(req, stub)
+	if resp == nil {
+		t.Fatal("plugin did not return a message")
+	}
+	if stop {
+		t.Error("plugin interrupted processing")
+	}
+	if servers := resp.NTPServers(); len(servers) != 0 {
+		t.Errorf("Found %d NTP servers when not requested", len(servers))
+	}
+}
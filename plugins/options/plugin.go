@@ -0,0 +1,227 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package options implements a plugin that sets arbitrary DHCPv4 and DHCPv6
+// options, given by code and typed value.
+//
+// Each option is given as an argument in the form code:type:value. The
+// supported types are:
+// - ip, ip-list: IPv4 addresses for DHCPv4 and IPv6 addresses for DHCPv6,
+//   comma-separated for lists
+// - uint8, uint16, uint32: unsigned integers, in decimal or 0x-prefixed hex
+// - string: the value as is
+// - hex: raw bytes in hexadecimal, optionally separated by colons
+// - domain-list: comma-separated domain names, in RFC 1035 encoding
+// - bool: true or false, encoded on one byte
+// - duration: a Go duration (e.g. 1h30m), encoded as seconds on 32 bits
+//
+// The argument `requested-only=true` makes the plugin only set the options
+// that the client asked for, in the Parameter Request List (DHCPv4 option 55)
+// or in the Option Request Option (DHCPv6 option 6).
+//
+// Example usage:
+//
+// server4:
+//   - plugins:
+//     - options: 42:ip-list:192.0.2.123,192.0.2.124 26:uint16:9000 requested-only=true
+// server6:
+//   - plugins:
+//     - options: 31:ip-list:2001:db8::123
+//
+package options
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+var log = logger.GetLogger("plugins/options")
+
+// Plugin wraps plugin registration information
+var Plugin = plugins.Plugin{
+	Name:   "options",
+	Setup6: setup6,
+	Setup4: setup4,
+}
+
+// option is a configured option, already encoded
+type option struct {
+	code uint16
+	data []byte
+}
+
+// PluginState is the configuration of one instance of the plugin
+type PluginState struct {
+	options       []option
+	requestedOnly bool
+}
+
+// reserved lists the options that are managed by the server or by dedicated
+// plugins, and that can't be set with this plugin
+var reserved = map[family]map[uint16]bool{
+	family4: {
+		uint16(dhcpv4.OptionPad):                  true,
+		uint16(dhcpv4.OptionDHCPMessageType):      true,
+		uint16(dhcpv4.OptionParameterRequestList): true,
+		uint16(dhcpv4.OptionEnd):                  true,
+	},
+	family6: {
+		0:                               true,
+		uint16(dhcpv6.OptionClientID):   true,
+		uint16(dhcpv6.OptionServerID):   true,
+		uint16(dhcpv6.OptionORO):        true,
+		uint16(dhcpv6.OptionRelayMsg):   true,
+		uint16(dhcpv6.OptionIANA):       true,
+		uint16(dhcpv6.OptionIATA):       true,
+		uint16(dhcpv6.OptionIAAddr):     true,
+		uint16(dhcpv6.OptionIAPD):       true,
+		uint16(dhcpv6.OptionIAPrefix):   true,
+		uint16(dhcpv6.OptionStatusCode): true,
+	},
+}
+
+// parseOption parses an option given as code:type:value
+func parseOption(arg string, fam family) (*option, error) {
+	parts := strings.SplitN(arg, ":", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("expected code:type:value, got `%s`", arg)
+	}
+	// Codes and lengths are one byte long in DHCPv4, two in DHCPv6
+	maxCode, maxLen := 255, 255
+	if fam == family6 {
+		maxCode, maxLen = 65535, 65535
+	}
+	code, err := strconv.Atoi(parts[0])
+	if err != nil || code < 0 || code > maxCode {
+		return nil, fmt.Errorf("invalid option code `%s`", parts[0])
+	}
+	if reserved[fam][uint16(code)] {
+		return nil, fmt.Errorf("option %d can't be set by this plugin", code)
+	}
+	enc, ok := encoders[parts[1]]
+	if !ok {
+		return nil, fmt.Errorf("unknown type `%s` for option %d", parts[1], code)
+	}
+	data, err := enc(parts[2], fam)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value for option %d: %v", parts[1], code, err)
+	}
+	if len(data) > maxLen {
+		return nil, fmt.Errorf("value of option %d is too long, %d bytes at most, got %d", code, maxLen, len(data))
+	}
+	return &option{code: uint16(code), data: data}, nil
+}
+
+// Parse4 parses a DHCPv4 option given as code:type:value, so that other
+// plugins can take options in the same format
+func Parse4(arg string) (dhcpv4.Option, error) {
+	opt, err := parseOption(arg, family4)
+	if err != nil {
+		return dhcpv4.Option{}, err
+	}
+	return dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(opt.code), opt.data), nil
+}
+
+// Parse6 parses a DHCPv6 option given as code:type:value, so that other
+// plugins can take options in the same format
+func Parse6(arg string) (dhcpv6.Option, error) {
+	opt, err := parseOption(arg, family6)
+	if err != nil {
+		return nil, err
+	}
+	return &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionCode(opt.code), OptionData: opt.data}, nil
+}
+
+func setup(fam family, args ...string) (*PluginState, error) {
+	var p PluginState
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "requested-only=") {
+			var err error
+			p.requestedOnly, err = strconv.ParseBool(strings.TrimPrefix(arg, "requested-only="))
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for requested-only: %v", err)
+			}
+			continue
+		}
+		opt, err := parseOption(arg, fam)
+		if err != nil {
+			return nil, err
+		}
+		p.options = append(p.options, *opt)
+	}
+	if len(p.options) == 0 {
+		return nil, fmt.Errorf("need at least one option")
+	}
+	log.Printf("loaded %d options for DHCPv%d", len(p.options), fam)
+	return &p, nil
+}
+
+// requested4 returns whether the option code is in the parameter request list.
+// OptionCodeList.Has can't be used, as it compares the code types as well
+func requested4(prl dhcpv4.OptionCodeList, code dhcpv4.OptionCode) bool {
+	for _, c := range prl {
+		if c.Code() == code.Code() {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler4 handles DHCPv4 packets for the options plugin
+func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	prl := req.ParameterRequestList()
+	for _, opt := range p.options {
+		code := dhcpv4.GenericOptionCode(opt.code)
+		if p.requestedOnly && !requested4(prl, code) {
+			continue
+		}
+		// Copy the data so that later plugins can't alter the configuration
+		resp.UpdateOption(dhcpv4.OptGeneric(code, append([]byte(nil), opt.data...)))
+	}
+	return resp, false
+}
+
+// Handler6 handles DHCPv6 packets for the options plugin
+func (p *PluginState) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	msg, err := req.GetInnerMessage()
+	if err != nil {
+		log.Errorf("Could not decapsulate relayed message, aborting: %v", err)
+		return nil, true
+	}
+	oro := msg.Options.RequestedOptions()
+	for _, opt := range p.options {
+		code := dhcpv6.OptionCode(opt.code)
+		if p.requestedOnly && !oro.Contains(code) {
+			continue
+		}
+		resp.UpdateOption(&dhcpv6.OptionGeneric{
+			OptionCode: code,
+			OptionData: append([]byte(nil), opt.data...),
+		})
+	}
+	return resp, false
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	p, err := setup(family6, args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler6, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	p, err := setup(family4, args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
@@ -0,0 +1,149 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package options
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	for _, tt := range []struct {
+		arg  string
+		fam  family
+		data []byte
+	}{
+		{"42:ip:192.0.2.1", family4, []byte{192, 0, 2, 1}},
+		{"42:ip-list:192.0.2.1,192.0.2.2", family4, []byte{192, 0, 2, 1, 192, 0, 2, 2}},
+		{"31:ip:2001:db8::1", family6, net.ParseIP("2001:db8::1")},
+		{"23:uint8:64", family4, []byte{64}},
+		{"26:uint16:0x2328", family4, []byte{0x23, 0x28}},
+		{"2:uint32:3600", family4, []byte{0, 0, 0x0e, 0x10}},
+		{"66:string:tftp.example.com", family4, []byte("tftp.example.com")},
+		{"43:hex:01:04:c0:00:02:01", family4, []byte{1, 4, 192, 0, 2, 1}},
+		{"43:hex:0a0b", family4, []byte{0x0a, 0x0b}},
+		{"119:domain-list:a.com,b.org.", family4, []byte{1, 'a', 3, 'c', 'o', 'm', 0, 1, 'b', 3, 'o', 'r', 'g', 0}},
+		{"19:bool:true", family4, []byte{1}},
+		{"19:bool:false", family4, []byte{0}},
+		{"58:duration:1h", family4, []byte{0, 0, 0x0e, 0x10}},
+		{"32:duration:90s", family6, []byte{0, 0, 0, 90}},
+		{"66:string:" + strings.Repeat("a", 255), family4, []byte(strings.Repeat("a", 255))},
+		{"15:string:" + strings.Repeat("a", 256), family6, []byte(strings.Repeat("a", 256))},
+	} {
+		opt, err := parseOption(tt.arg, tt.fam)
+		if assert.NoError(t, err, tt.arg) {
+			assert.Equal(t, tt.data, opt.data, tt.arg)
+		}
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	for _, tt := range []struct {
+		arg string
+		fam family
+	}{
+		{"42:ip", family4},
+		{"x:ip:192.0.2.1", family4},
+		{"256:uint8:1", family4},
+		{"53:uint8:1", family4},
+		{"1:hex:00", family6},
+		{"42:ipv4:192.0.2.1", family4},
+		{"42:ip:2001:db8::1", family4},
+		{"31:ip:192.0.2.1", family6},
+		{"42:ip-list:192.0.2.1,", family4},
+		{"23:uint8:256", family4},
+		{"26:uint16:-1", family4},
+		{"66:string:", family4},
+		{"43:hex:0g", family4},
+		{"119:domain-list:a..com", family4},
+		{"19:bool:maybe", family4},
+		{"58:duration:-1h", family4},
+		{"66:string:" + strings.Repeat("a", 256), family4},
+		{"43:hex:" + strings.Repeat("00", 256), family4},
+	} {
+		_, err := parseOption(tt.arg, tt.fam)
+		assert.Error(t, err, tt.arg)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	_, err := setup(family4)
+	assert.Error(t, err)
+	_, err = setup(family4, "requested-only=sure", "42:ip:192.0.2.1")
+	assert.Error(t, err)
+	p, err := setup(family4, "requested-only=true", "42:ip:192.0.2.1")
+	require.NoError(t, err)
+	assert.True(t, p.requestedOnly)
+	assert.Len(t, p.options, 1)
+}
+
+func TestHandler4(t *testing.T) {
+	p, err := setup(family4, "42:ip:192.0.2.1", "26:uint16:9000")
+	require.NoError(t, err)
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55})
+	require.NoError(t, err)
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+
+	resp, stop := p.Handler4(req, stub)
+	assert.False(t, stop)
+	assert.Equal(t, []byte{192, 0, 2, 1}, resp.Options.Get(dhcpv4.OptionNTPServers))
+	assert.Equal(t, []byte{0x23, 0x28}, resp.Options.Get(dhcpv4.OptionInterfaceMTU))
+
+	// Modifying the response must not alter the configuration
+	resp.Options.Get(dhcpv4.OptionNTPServers)[0] = 10
+	assert.Equal(t, []byte{192, 0, 2, 1}, p.options[0].data)
+}
+
+func TestHandler4Requested(t *testing.T) {
+	p, err := setup(family4, "requested-only=true", "42:ip:192.0.2.1", "26:uint16:9000")
+	require.NoError(t, err)
+
+	req, err := dhcpv4.New(dhcpv4.WithRequestedOptions(dhcpv4.OptionInterfaceMTU))
+	require.NoError(t, err)
+	// Go through the wire format, as the server would
+	req, err = dhcpv4.FromBytes(req.ToBytes())
+	require.NoError(t, err)
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+
+	resp, _ := p.Handler4(req, stub)
+	assert.False(t, resp.Options.Has(dhcpv4.OptionNTPServers))
+	assert.Equal(t, []byte{0x23, 0x28}, resp.Options.Get(dhcpv4.OptionInterfaceMTU))
+}
+
+func TestHandler6(t *testing.T) {
+	p, err := setup(family6, "requested-only=true", "31:ip:2001:db8::1", "56:hex:00010010", "32:duration:1h")
+	require.NoError(t, err)
+
+	req, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeInformationRequest
+	req.AddOption(dhcpv6.OptClientID(dhcpv6.Duid{
+		Type:          dhcpv6.DUID_LL,
+		HwType:        1,
+		LinkLayerAddr: net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+	}))
+	req.AddOption(dhcpv6.OptRequestedOption(dhcpv6.OptionSNTPServerList, dhcpv6.OptionInformationRefreshTime))
+	relayed, err := dhcpv6.EncapsulateRelay(req, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8::ff"), net.ParseIP("fe80::1"))
+	require.NoError(t, err)
+	stub, err := dhcpv6.NewReplyFromMessage(req)
+	require.NoError(t, err)
+
+	resp, stop := p.Handler6(relayed, stub)
+	assert.False(t, stop)
+	opts := resp.(*dhcpv6.Message).Options
+	require.Len(t, opts.Get(dhcpv6.OptionSNTPServerList), 1)
+	assert.Equal(t, []byte(net.ParseIP("2001:db8::1")), opts.GetOne(dhcpv6.OptionSNTPServerList).ToBytes())
+	assert.Nil(t, opts.GetOne(dhcpv6.OptionNTPServer))
+	assert.Equal(t, []byte{0, 0, 0x0e, 0x10}, opts.GetOne(dhcpv6.OptionInformationRefreshTime).ToBytes())
+}
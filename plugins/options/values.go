@@ -0,0 +1,152 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package options
+
+// This file implements the encoding of the typed values of the options.
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/dhcp/rfc1035label"
+)
+
+// family is the address family an option value is encoded for
+type family int
+
+const (
+	family4 family = 4
+	family6 family = 6
+)
+
+// encoder turns the textual representation of a value into the content of an
+// option
+type encoder func(value string, fam family) ([]byte, error)
+
+var encoders = map[string]encoder{
+	"ip":          encodeIP,
+	"ip-list":     encodeIPList,
+	"uint8":       encodeUint(8),
+	"uint16":      encodeUint(16),
+	"uint32":      encodeUint(32),
+	"string":      encodeString,
+	"hex":         encodeHex,
+	"domain-list": encodeDomainList,
+	"bool":        encodeBool,
+	"duration":    encodeDuration,
+}
+
+func encodeIP(value string, fam family) ([]byte, error) {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address: %s", value)
+	}
+	if fam == family4 {
+		if ip = ip.To4(); ip == nil {
+			return nil, fmt.Errorf("not an IPv4 address: %s", value)
+		}
+		return ip, nil
+	}
+	if ip.To4() != nil {
+		return nil, fmt.Errorf("not an IPv6 address: %s", value)
+	}
+	return ip.To16(), nil
+}
+
+func encodeIPList(value string, fam family) ([]byte, error) {
+	var data []byte
+	for _, v := range strings.Split(value, ",") {
+		ip, err := encodeIP(v, fam)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, ip...)
+	}
+	return data, nil
+}
+
+func encodeUint(bits int) encoder {
+	return func(value string, _ family) ([]byte, error) {
+		n, err := strconv.ParseUint(value, 0, bits)
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint64(data, n)
+		return data[8-bits/8:], nil
+	}
+}
+
+func encodeString(value string, _ family) ([]byte, error) {
+	if value == "" {
+		return nil, fmt.Errorf("empty string")
+	}
+	return []byte(value), nil
+}
+
+// encodeHex accepts hex strings, optionally with colons between bytes, e.g.
+// 0a0b0c or 0a:0b:0c
+func encodeHex(value string, _ family) ([]byte, error) {
+	data, err := hex.DecodeString(strings.Replace(value, ":", "", -1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+	return data, nil
+}
+
+// encodeDomainList encodes a comma-separated list of domains as in RFC 1035
+// section 3.1, as used by the DHCPv4 domain search option (RFC 3397) and the
+// DHCPv6 domain name options (RFC 8415 section 10)
+func encodeDomainList(value string, _ family) ([]byte, error) {
+	var labels rfc1035label.Labels
+	for _, domain := range strings.Split(value, ",") {
+		domain = strings.TrimSuffix(domain, ".")
+		if domain == "" {
+			return nil, fmt.Errorf("empty domain in %s", value)
+		}
+		for _, l := range strings.Split(domain, ".") {
+			if l == "" || len(l) > 63 {
+				return nil, fmt.Errorf("invalid domain: %s", domain)
+			}
+		}
+		labels.Labels = append(labels.Labels, domain)
+	}
+	return labels.ToBytes(), nil
+}
+
+func encodeBool(value string, _ family) ([]byte, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, err
+	}
+	if b {
+		return []byte{1}, nil
+	}
+	return []byte{0}, nil
+}
+
+// encodeDuration encodes a duration as a number of seconds on 32 bits, as used
+// by all the time-related options
+func encodeDuration(value string, _ family) ([]byte, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return nil, err
+	}
+	if d < 0 || d.Seconds() > math.MaxUint32 {
+		return nil, fmt.Errorf("duration out of range: %s", value)
+	}
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, uint32(d.Seconds()))
+	return data, nil
+}
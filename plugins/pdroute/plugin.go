@@ -0,0 +1,321 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package pdroute installs kernel routes towards the prefixes delegated to
+// clients, for servers running on the router of their clients.
+//
+// When a Reply delegates prefixes (IA_PD), the plugin routes each of them via
+// the client: the peer address of the first relay for relayed requests, which
+// is the address the client used to reach it, or the link-local address
+// derived from the MAC address of the client's DUID (DUID-LL or DUID-LLT)
+// otherwise. The routes are removed when the client releases the prefixes or
+// when they expire, and are marked with the DHCP routing protocol (`proto
+// dhcp`).
+//
+// The plugin needs to run after the `prefix` plugin. Arguments are:
+// - dev=<interface>: the interface the clients are reached through. It is
+//   mandatory for link-local next hops
+// - table=<id>: the routing table to install the routes in, main by default
+// - dry-run: only log the routes, without installing them
+//
+// Example usage:
+//
+// server6:
+//   - plugins:
+//     - prefix: 2001:db8::/48 64
+//     - pd_route: dev=eth1
+//
+package pdroute
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/vishvananda/netlink"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/relayinfo"
+)
+
+var log = logger.GetLogger("plugins/pd_route")
+
+// Plugin wraps plugin registration information
+var Plugin = plugins.Plugin{
+	Name:   "pd_route",
+	Setup6: setup6,
+}
+
+// rtprotDHCP is the routing protocol of the installed routes, RTPROT_DHCP
+const rtprotDHCP = 16
+
+// route is a delegated prefix routed to a client
+type route struct {
+	client  string
+	prefix  net.IPNet
+	nextHop net.IP
+	expires time.Time
+	timer   *time.Timer
+}
+
+// routeTable installs and removes routes
+type routeTable interface {
+	replace(r *route) error
+	remove(r *route) error
+}
+
+// kernelTable is a routing table of the kernel, managed through netlink. The
+// handle is bound to the network namespace the plugin was set up in, which the
+// expiry timers don't necessarily run in
+type kernelTable struct {
+	handle *netlink.Handle
+	link   int
+	table  int
+}
+
+func (k *kernelTable) netlinkRoute(r *route) *netlink.Route {
+	return &netlink.Route{
+		Dst:       &r.prefix,
+		Gw:        r.nextHop,
+		LinkIndex: k.link,
+		Table:     k.table,
+		Protocol:  rtprotDHCP,
+	}
+}
+
+func (k *kernelTable) replace(r *route) error {
+	return k.handle.RouteReplace(k.netlinkRoute(r))
+}
+
+func (k *kernelTable) remove(r *route) error {
+	return k.handle.RouteDel(k.netlinkRoute(r))
+}
+
+// dryRunTable logs the routes instead of installing them
+type dryRunTable struct {
+	// suffix describes the interface and table of the routes
+	suffix string
+}
+
+func (d *dryRunTable) replace(r *route) error {
+	log.Printf("dry-run: ip -6 route replace %s via %s%s proto dhcp", r.prefix.String(), r.nextHop, d.suffix)
+	return nil
+}
+
+func (d *dryRunTable) remove(r *route) error {
+	log.Printf("dry-run: ip -6 route del %s via %s%s proto dhcp", r.prefix.String(), r.nextHop, d.suffix)
+	return nil
+}
+
+// PluginState is the data held by an instance of the pd_route plugin
+type PluginState struct {
+	sync.Mutex
+	table routeTable
+	// link is the index of the interface of the clients, 0 when unset
+	link int
+	// routes maps the delegated prefixes to their route
+	routes map[string]*route
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	var (
+		dev    string
+		table  int
+		dryRun bool
+	)
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		switch {
+		case kv[0] == "dry-run" && len(kv) == 1:
+			dryRun = true
+		case kv[0] == "dev" && len(kv) == 2:
+			dev = kv[1]
+		case kv[0] == "table" && len(kv) == 2:
+			id, err := strconv.ParseUint(kv[1], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid routing table %q: %v", kv[1], err)
+			}
+			table = int(id)
+		default:
+			return nil, fmt.Errorf("unknown argument %q", arg)
+		}
+	}
+
+	p := &PluginState{routes: make(map[string]*route)}
+	suffix := ""
+	if dev != "" {
+		iface, err := net.InterfaceByName(dev)
+		if err != nil {
+			return nil, fmt.Errorf("unknown interface %s: %w", dev, err)
+		}
+		p.link = iface.Index
+		suffix += " dev " + dev
+	}
+	if table != 0 {
+		suffix += " table " + strconv.Itoa(table)
+	}
+	if dryRun {
+		p.table = &dryRunTable{suffix: suffix}
+	} else {
+		handle, err := netlink.NewHandle()
+		if err != nil {
+			return nil, fmt.Errorf("could not open a netlink socket: %w", err)
+		}
+		p.table = &kernelTable{handle: handle, link: p.link, table: table}
+	}
+	log.Printf("loaded pd_route plugin, routing delegated prefixes%s (dry-run: %t)", suffix, dryRun)
+	return p.Handler6, nil
+}
+
+// nextHop returns the address the prefixes delegated in response to a request
+// are routed to, or nil if it isn't known
+func nextHop(req dhcpv6.DHCPv6, duid *dhcpv6.Duid) net.IP {
+	if hops := relayinfo.Get6(req); len(hops) > 0 {
+		return hops[0].PeerAddr
+	}
+	if (duid.Type != dhcpv6.DUID_LL && duid.Type != dhcpv6.DUID_LLT) ||
+		duid.HwType != iana.HWTypeEthernet || len(duid.LinkLayerAddr) != 6 {
+		return nil
+	}
+	// Modified EUI-64 interface identifier, RFC 4291 appendix A
+	mac := duid.LinkLayerAddr
+	return net.IP{
+		0xfe, 0x80, 0, 0, 0, 0, 0, 0,
+		mac[0] ^ 0x02, mac[1], mac[2], 0xff, 0xfe, mac[3], mac[4], mac[5],
+	}
+}
+
+// add routes a prefix to a client, or extends the lifetime of its route
+func (p *PluginState) add(client string, prefix net.IPNet, nextHop net.IP, valid time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+
+	key := prefix.String()
+	expires := time.Now().Add(valid)
+	old, ok := p.routes[key]
+	if ok {
+		old.timer.Stop()
+		if old.client == client && old.nextHop.Equal(nextHop) {
+			old.expires = expires
+			old.timer.Reset(valid)
+			return
+		}
+		delete(p.routes, key)
+	}
+	r := &route{client: client, prefix: prefix, nextHop: nextHop, expires: expires}
+	// Replacing also moves a route installed for another next hop
+	if err := p.table.replace(r); err != nil {
+		log.Warningf("Could not add route to %s via %s: %v", key, nextHop, err)
+		// The previous route is not tracked anymore, it must not be left
+		// behind pointing to the wrong next hop
+		if ok {
+			p.removeRoute(old)
+		}
+		return
+	}
+	r.timer = time.AfterFunc(valid, func() { p.expire(key, r) })
+	p.routes[key] = r
+	log.Debugf("Added route to %s via %s", key, nextHop)
+}
+
+// del removes the route to a prefix delegated to a client, if any
+func (p *PluginState) del(client string, prefix net.IPNet) {
+	p.Lock()
+	defer p.Unlock()
+
+	key := prefix.String()
+	r, ok := p.routes[key]
+	if !ok || r.client != client {
+		return
+	}
+	r.timer.Stop()
+	delete(p.routes, key)
+	p.removeRoute(r)
+}
+
+// expire removes a route when the prefix expires, unless it has been replaced
+// or renewed in the meantime
+func (p *PluginState) expire(key string, r *route) {
+	p.Lock()
+	defer p.Unlock()
+
+	if cur, ok := p.routes[key]; !ok || cur != r || time.Now().Before(r.expires) {
+		return
+	}
+	delete(p.routes, key)
+	p.removeRoute(r)
+}
+
+func (p *PluginState) removeRoute(r *route) {
+	if err := p.table.remove(r); err != nil {
+		log.Warningf("Could not remove route to %s via %s: %v", r.prefix.String(), r.nextHop, err)
+		return
+	}
+	log.Debugf("Removed route to %s via %s", r.prefix.String(), r.nextHop)
+}
+
+// Handler6 handles DHCPv6 packets for the pd_route plugin
+func (p *PluginState) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	msg, err := req.GetInnerMessage()
+	if err != nil {
+		log.Errorf("Could not decapsulate request: %v", err)
+		return nil, true
+	}
+	duid := msg.Options.ClientID()
+	if duid == nil {
+		return resp, false
+	}
+	client := string(duid.ToBytes())
+
+	switch msg.MessageType {
+	case dhcpv6.MessageTypeRelease:
+		for _, iapd := range msg.Options.IAPD() {
+			for _, prefix := range iapd.Options.Prefixes() {
+				if prefix.Prefix != nil {
+					p.del(client, *prefix.Prefix)
+				}
+			}
+		}
+		return resp, false
+	case dhcpv6.MessageTypeSolicit, dhcpv6.MessageTypeRequest,
+		dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRebind:
+	default:
+		return resp, false
+	}
+	reply, ok := resp.(*dhcpv6.Message)
+	if !ok || reply.MessageType != dhcpv6.MessageTypeReply || len(reply.Options.IAPD()) == 0 {
+		return resp, false
+	}
+
+	via := nextHop(req, duid)
+	if via == nil {
+		log.Warningf("Cannot route the prefixes of %s: no next hop for a client without a MAC address", duid)
+		return resp, false
+	}
+	if via.IsLinkLocalUnicast() && p.link == 0 {
+		log.Warningf("Cannot route the prefixes of %s via %s: link-local next hops need dev to be set", duid, via)
+		return resp, false
+	}
+	for _, iapd := range reply.Options.IAPD() {
+		for _, prefix := range iapd.Options.Prefixes() {
+			if prefix.Prefix == nil {
+				continue
+			}
+			if prefix.ValidLifetime == 0 {
+				// The prefix is no longer valid for the client
+				p.del(client, *prefix.Prefix)
+				continue
+			}
+			p.add(client, *prefix.Prefix, via, prefix.ValidLifetime)
+		}
+	}
+	return resp, false
+}
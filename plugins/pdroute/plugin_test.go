@@ -0,0 +1,192 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package pdroute
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTable records the installed routes, as prefix -> next hop
+type fakeTable struct {
+	sync.Mutex
+	routes map[string]string
+	// fail makes replace fail
+	fail bool
+}
+
+func (f *fakeTable) replace(r *route) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.fail {
+		return errors.New("replace failed")
+	}
+	f.routes[r.prefix.String()] = r.nextHop.String()
+	return nil
+}
+
+func (f *fakeTable) remove(r *route) error {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.routes, r.prefix.String())
+	return nil
+}
+
+func (f *fakeTable) get() map[string]string {
+	f.Lock()
+	defer f.Unlock()
+	ret := make(map[string]string, len(f.routes))
+	for k, v := range f.routes {
+		ret[k] = v
+	}
+	return ret
+}
+
+func newTestState() (*PluginState, *fakeTable) {
+	table := &fakeTable{routes: make(map[string]string)}
+	return &PluginState{table: table, link: 1, routes: make(map[string]*route)}, table
+}
+
+var testMAC = net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+
+func newMessage(t *testing.T, typ dhcpv6.MessageType, mac net.HardwareAddr, prefixes ...string) *dhcpv6.Message {
+	msg, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	msg.MessageType = typ
+	msg.AddOption(dhcpv6.OptClientID(dhcpv6.Duid{
+		Type:          dhcpv6.DUID_LL,
+		HwType:        iana.HWTypeEthernet,
+		LinkLayerAddr: mac,
+	}))
+	iapd := &dhcpv6.OptIAPD{IaId: [4]byte{1}}
+	for _, p := range prefixes {
+		_, prefix, err := net.ParseCIDR(p)
+		require.NoError(t, err)
+		iapd.Options.Add(&dhcpv6.OptIAPrefix{
+			PreferredLifetime: 30 * time.Minute,
+			ValidLifetime:     time.Hour,
+			Prefix:            prefix,
+		})
+	}
+	msg.AddOption(iapd)
+	return msg
+}
+
+func TestNextHop(t *testing.T) {
+	req := newMessage(t, dhcpv6.MessageTypeRequest, testMAC)
+	assert.Equal(t, "fe80::211:22ff:fe33:4455", nextHop(req, req.Options.ClientID()).String())
+
+	// The peer address of the innermost relay
+	relay, err := dhcpv6.EncapsulateRelay(req, dhcpv6.MessageTypeRelayForward,
+		net.ParseIP("2001:db8:1::1"), net.ParseIP("fe80::1234"))
+	require.NoError(t, err)
+	relay, err = dhcpv6.EncapsulateRelay(relay, dhcpv6.MessageTypeRelayForward,
+		net.ParseIP("2001:db8:2::1"), net.ParseIP("2001:db8:1::1"))
+	require.NoError(t, err)
+	assert.Equal(t, "fe80::1234", nextHop(relay, req.Options.ClientID()).String())
+
+	// No MAC address in the DUID
+	assert.Nil(t, nextHop(req, &dhcpv6.Duid{Type: dhcpv6.DUID_UUID, Uuid: make([]byte, 16)}))
+}
+
+func TestRoutes(t *testing.T) {
+	p, table := newTestState()
+
+	// Advertised prefixes aren't routed
+	req := newMessage(t, dhcpv6.MessageTypeSolicit, testMAC)
+	p.Handler6(req, newMessage(t, dhcpv6.MessageTypeAdvertise, testMAC, "2001:db8:0:100::/56"))
+	assert.Empty(t, table.get())
+
+	req = newMessage(t, dhcpv6.MessageTypeRequest, testMAC)
+	p.Handler6(req, newMessage(t, dhcpv6.MessageTypeReply, testMAC, "2001:db8:0:100::/56", "2001:db8:0:200::/56"))
+	assert.Equal(t, map[string]string{
+		"2001:db8:0:100::/56": "fe80::211:22ff:fe33:4455",
+		"2001:db8:0:200::/56": "fe80::211:22ff:fe33:4455",
+	}, table.get())
+
+	// Another client can't remove the routes
+	other := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x66}
+	p.Handler6(newMessage(t, dhcpv6.MessageTypeRelease, other, "2001:db8:0:100::/56"),
+		newMessage(t, dhcpv6.MessageTypeReply, other))
+	assert.Len(t, table.get(), 2)
+
+	p.Handler6(newMessage(t, dhcpv6.MessageTypeRelease, testMAC, "2001:db8:0:100::/56"),
+		newMessage(t, dhcpv6.MessageTypeReply, testMAC))
+	assert.Equal(t, map[string]string{"2001:db8:0:200::/56": "fe80::211:22ff:fe33:4455"}, table.get())
+
+	// The prefix moves to another client
+	p.Handler6(newMessage(t, dhcpv6.MessageTypeRequest, other),
+		newMessage(t, dhcpv6.MessageTypeReply, other, "2001:db8:0:200::/56"))
+	assert.Equal(t, map[string]string{"2001:db8:0:200::/56": "fe80::211:22ff:fe33:4466"}, table.get())
+	assert.Len(t, p.routes, 1)
+}
+
+func TestReplaceFailure(t *testing.T) {
+	p, table := newTestState()
+	_, prefix, err := net.ParseCIDR("2001:db8:0:100::/56")
+	require.NoError(t, err)
+
+	p.add("client", *prefix, net.ParseIP("fe80::1"), time.Hour)
+	assert.Equal(t, map[string]string{"2001:db8:0:100::/56": "fe80::1"}, table.get())
+
+	// The route to the previous next hop isn't left behind
+	table.Lock()
+	table.fail = true
+	table.Unlock()
+	p.add("other", *prefix, net.ParseIP("fe80::2"), time.Hour)
+	assert.Empty(t, table.get())
+	assert.Empty(t, p.routes)
+}
+
+func TestExpiry(t *testing.T) {
+	p, table := newTestState()
+	_, prefix, err := net.ParseCIDR("2001:db8:0:100::/56")
+	require.NoError(t, err)
+
+	p.add("client", *prefix, net.ParseIP("fe80::1"), 200*time.Millisecond)
+	require.Len(t, table.get(), 1)
+	// Renewing extends the lifetime of the route
+	time.Sleep(120 * time.Millisecond)
+	p.add("client", *prefix, net.ParseIP("fe80::1"), 200*time.Millisecond)
+	time.Sleep(120 * time.Millisecond)
+	assert.Len(t, table.get(), 1)
+
+	time.Sleep(200 * time.Millisecond)
+	assert.Empty(t, table.get())
+	p.Lock()
+	assert.Empty(t, p.routes)
+	p.Unlock()
+}
+
+func TestLinkLocalNeedsDev(t *testing.T) {
+	p, table := newTestState()
+	p.link = 0
+	p.Handler6(newMessage(t, dhcpv6.MessageTypeRequest, testMAC),
+		newMessage(t, dhcpv6.MessageTypeReply, testMAC, "2001:db8:0:100::/56"))
+	assert.Empty(t, table.get())
+}
+
+func TestSetup(t *testing.T) {
+	_, err := setup6("dry-run", "table=100")
+	require.NoError(t, err)
+
+	for _, args := range [][]string{
+		{"table=main"},
+		{"dev=does-not-exist0"},
+		{"dry-run=yes"},
+		{"bogus"},
+	} {
+		_, err := setup6(args...)
+		assert.Error(t, err, "args %v", args)
+	}
+}
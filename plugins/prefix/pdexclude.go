@@ -0,0 +1,60 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package prefix
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// optPDExclude is the DHCPv6 PD Exclude option, RFC 6603. It is sent within
+// an IA Prefix option, to carve a prefix out of the delegated prefix
+type optPDExclude struct {
+	// delegated is the length of the prefix the option is sent with
+	delegated int
+	excluded  net.IPNet
+}
+
+// newPDExclude excludes the first /length prefix of the delegated prefix. It
+// returns nil when the delegated prefix isn't larger than that
+func newPDExclude(delegated net.IPNet, length int) *optPDExclude {
+	ones, _ := delegated.Mask.Size()
+	if length <= ones || length > 128 {
+		return nil
+	}
+	mask := net.CIDRMask(length, 128)
+	return &optPDExclude{
+		delegated: ones,
+		excluded:  net.IPNet{IP: delegated.IP.To16().Mask(mask), Mask: mask},
+	}
+}
+
+// Code implements dhcpv6.Option.Code
+func (o *optPDExclude) Code() dhcpv6.OptionCode {
+	return dhcpv6.OptionPDExclude
+}
+
+// ToBytes implements dhcpv6.Option.ToBytes. The excluded prefix is encoded as
+// its length followed by the bits following the delegated prefix, the subnet
+// ID, padded to a byte boundary
+func (o *optPDExclude) ToBytes() []byte {
+	ones, _ := o.excluded.Mask.Size()
+	subnetID := make([]byte, (ones-o.delegated-1)/8+1)
+	ip := o.excluded.IP.To16()
+	for i := o.delegated; i < ones; i++ {
+		if ip[i/8]&(0x80>>uint(i%8)) != 0 {
+			bit := i - o.delegated
+			subnetID[bit/8] |= 0x80 >> uint(bit%8)
+		}
+	}
+	return append([]byte{byte(ones)}, subnetID...)
+}
+
+// String implements dhcpv6.Option.String
+func (o *optPDExclude) String() string {
+	return fmt.Sprintf("PD Exclude: %s", o.excluded.String())
+}
@@ -9,10 +9,21 @@
 // - prefix: The base prefix from which assigned prefixes are carved
 // - max: maximum size of the prefix delegated to clients. When a client requests a larger prefix
 // than this, this is the size of the offered prefix
+// - strategy=sequential|random (optional): how prefixes are picked, sequential by default. Random
+// prefixes are not predictable and don't reveal how many clients there are
+// - min=<length> (optional): shortest prefix length delegated to clients. When set, clients get
+// prefixes of the length they request, between min and max; max when they don't request any
+// - pd-exclude=<length> (optional): length of a prefix carved out of each delegated prefix with
+// the PD Exclude option (RFC 6603), typically for the link between the server and the client.
+// The first /length prefix is excluded, and only for the clients requesting the option. It must
+// be longer than max
+// - subnet=<CIDR> (optional): only delegate prefixes to the clients of a subnet, so that each link
+// gets its own pool from an instance of the plugin. Relayed clients are matched on the link
+// address of the relay closest to them; clients on the link of the server always match
 package prefix
 
-// FIXME: various settings will be hardcoded (default size, minimum size, lease times) pending a
-// better configuration system
+// FIXME: various settings will be hardcoded (default size, minimum size) pending a better
+// configuration system. Lease times come from the lease_time plugin, see leasetime.V6
 
 import (
 	"bytes"
@@ -20,6 +31,7 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -32,18 +44,24 @@ import (
 	"github.com/coredhcp/coredhcp/plugins"
 	"github.com/coredhcp/coredhcp/plugins/allocators"
 	"github.com/coredhcp/coredhcp/plugins/allocators/bitmap"
+	"github.com/coredhcp/coredhcp/plugins/allocators/buddy"
+	"github.com/coredhcp/coredhcp/plugins/allocators/sparse"
+	"github.com/coredhcp/coredhcp/plugins/leasetime"
+	"github.com/coredhcp/coredhcp/plugins/relayinfo"
 )
 
 var log = logger.GetLogger("plugins/prefix")
 
+// sparseOrder is the order of the pool size from which the sparse allocator is
+// used, as the bitmap allocator would use too much memory
+const sparseOrder = 32
+
 // Plugin registers the prefix. Prefix delegation only exists for DHCPv6
 var Plugin = plugins.Plugin{
 	Name:   "prefix",
 	Setup6: setupPrefix,
 }
 
-const leaseDuration = 3600 * time.Second
-
 func setupPrefix(args ...string) (handler.Handler6, error) {
 	// - prefix: 2001:db8::/48 64
 	if len(args) < 2 {
@@ -60,15 +78,70 @@ func setupPrefix(args ...string) (handler.Handler6, error) {
 		return nil, fmt.Errorf("Invalid prefix length: %v", err)
 	}
 
-	// TODO: select allocators based on heuristics or user configuration
-	alloc, err := bitmap.NewBitmapAllocator(*prefix, allocSize)
+	poolSize, _ := prefix.Mask.Size()
+	var (
+		shortest  = allocSize
+		pdExclude int
+		strategy  = allocators.Sequential
+		subnet    *net.IPNet
+	)
+	for _, arg := range args[2:] {
+		switch {
+		case strings.HasPrefix(arg, "strategy="):
+			strategy, err = allocators.ParseStrategy(strings.TrimPrefix(arg, "strategy="))
+			if err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(arg, "min="):
+			shortest, err = strconv.Atoi(strings.TrimPrefix(arg, "min="))
+			if err != nil || shortest < poolSize || shortest > allocSize {
+				return nil, fmt.Errorf("Invalid minimum prefix length, want between /%d and /%d: %s", poolSize, allocSize, arg)
+			}
+		case strings.HasPrefix(arg, "pd-exclude="):
+			pdExclude, err = strconv.Atoi(strings.TrimPrefix(arg, "pd-exclude="))
+			if err != nil || pdExclude <= 0 || pdExclude > 128 {
+				return nil, fmt.Errorf("Invalid excluded prefix length: %s", arg)
+			}
+		case strings.HasPrefix(arg, "subnet="):
+			_, subnet, err = net.ParseCIDR(strings.TrimPrefix(arg, "subnet="))
+			if err != nil || subnet.IP.To4() != nil {
+				return nil, fmt.Errorf("Invalid subnet, want an IPv6 CIDR: %s", arg)
+			}
+		default:
+			return nil, fmt.Errorf("Unknown argument %s", arg)
+		}
+	}
+	if pdExclude != 0 && pdExclude <= allocSize {
+		return nil, fmt.Errorf("The excluded prefix /%d must be longer than the delegated prefixes, up to /%d", pdExclude, allocSize)
+	}
+
+	// TODO: select allocators based on user configuration
+	var alloc interface {
+		allocators.Allocator
+		allocators.StrategySetter
+	}
+	switch {
+	case shortest < allocSize:
+		// Clients may request prefixes of different lengths
+		alloc, err = buddy.NewAllocator(*prefix, shortest, allocSize)
+	case allocSize-poolSize >= sparseOrder:
+		// A bitmap would be too large for this pool
+		alloc, err = sparse.NewAllocator(*prefix, allocSize)
+	default:
+		alloc, err = bitmap.NewBitmapAllocator(*prefix, allocSize)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("Could not initialize prefix allocator: %v", err)
 	}
+	if err := alloc.SetStrategy(strategy); err != nil {
+		return nil, err
+	}
 
 	return (&Handler{
 		Records:   make(map[string][]lease),
 		allocator: alloc,
+		pdExclude: pdExclude,
+		subnet:    subnet,
 	}).Handle, nil
 }
 
@@ -86,6 +159,11 @@ type Handler struct {
 	// Since it's not valid utf-8 we can't use any other string function though
 	Records   map[string][]lease
 	allocator allocators.Allocator
+	// pdExclude is the length of the prefix excluded from the delegated
+	// prefixes, for clients supporting the PD Exclude option. 0 disables it
+	pdExclude int
+	// subnet restricts the plugin to the clients of a subnet, when set
+	subnet *net.IPNet
 }
 
 // samePrefix returns true if both prefixes are defined and equal
@@ -115,6 +193,20 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 		log.Error("Invalid packet received, no clientID")
 		return nil, true
 	}
+	// Clients of other links are left to the next plugins. Clients on the link
+	// of the server have no link address, and are served by any subnet
+	if h.subnet != nil {
+		if link := relayinfo.LinkAddr6(req); link != nil && !h.subnet.Contains(link) {
+			return resp, false
+		}
+	}
+
+	timers := leasetime.V6()
+	// The excluded prefix is only sent to clients requesting it
+	exclude := 0
+	if msg.IsOptionRequested(dhcpv6.OptionPDExclude) {
+		exclude = h.pdExclude
+	}
 
 	// Each request IA_PD requires an IA_PD response
 	for _, iapd := range msg.Options.IAPD() {
@@ -156,13 +248,13 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 		for hintIdx, h := range hints {
 			for leaseIdx := range knownLeases {
 				if samePrefix(h.Prefix, &knownLeases[leaseIdx].Prefix) {
-					expire := time.Now().Add(leaseDuration)
+					expire := time.Now().Add(timers.Valid)
 					if knownLeases[leaseIdx].Expire.Before(expire) {
 						knownLeases[leaseIdx].Expire = expire
 					}
 					satisfied.Set(uint(hintIdx))
 					givenOut.Set(uint(leaseIdx))
-					addPrefix(iapdResp, knownLeases[leaseIdx])
+					addPrefix(iapdResp, knownLeases[leaseIdx], timers, exclude)
 				}
 			}
 		}
@@ -187,13 +279,13 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 						continue
 					}
 				}
-				expire := time.Now().Add(leaseDuration)
+				expire := time.Now().Add(timers.Valid)
 				if knownLeases[leaseIdx].Expire.Before(expire) {
 					knownLeases[leaseIdx].Expire = expire
 				}
 				satisfied.Set(uint(hintIdx))
 				givenOut.Set(uint(leaseIdx))
-				addPrefix(iapdResp, knownLeases[leaseIdx])
+				addPrefix(iapdResp, knownLeases[leaseIdx], timers, exclude)
 			}
 		}
 
@@ -223,11 +315,11 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 				continue
 			}
 			l := lease{
-				Expire: time.Now().Add(leaseDuration),
+				Expire: time.Now().Add(timers.Valid),
 				Prefix: allocated,
 			}
 
-			addPrefix(iapdResp, l)
+			addPrefix(iapdResp, l, timers, exclude)
 			newLeases = append(knownLeases, l)
 			log.Debugf("Allocated %s to %s (IAID: %x)", &allocated, client, iapd.IaId)
 		}
@@ -242,6 +334,15 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 			iapdResp.Options.Add(&dhcpv6.OptStatusCode{
 				StatusCode: dhcpIana.StatusNoPrefixAvail,
 			})
+		} else {
+			// Renew before the first prefix stops being preferred
+			shortest := timers.Preferred
+			for _, p := range iapdResp.Options.Prefixes() {
+				if p.PreferredLifetime < shortest {
+					shortest = p.PreferredLifetime
+				}
+			}
+			iapdResp.T1, iapdResp.T2 = timers.Times(shortest)
 		}
 
 		resp.AddOption(iapdResp)
@@ -250,14 +351,22 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 	return resp, false
 }
 
-func addPrefix(resp *dhcpv6.OptIAPD, l lease) {
-	lifetime := time.Until(l.Expire)
+// addPrefix adds a lease to the IA_PD response. When exclude is set, the first
+// /exclude prefix of the lease is carved out of it with the PD Exclude option
+func addPrefix(resp *dhcpv6.OptIAPD, l lease, timers *leasetime.Policy6, exclude int) {
+	preferred, valid := timers.Lifetimes(l.Expire)
 
-	resp.Options.Add(&dhcpv6.OptIAPrefix{
-		PreferredLifetime: lifetime,
-		ValidLifetime:     lifetime,
+	iaPrefix := &dhcpv6.OptIAPrefix{
+		PreferredLifetime: preferred,
+		ValidLifetime:     valid,
 		Prefix:            dup(&l.Prefix),
-	})
+	}
+	if exclude != 0 {
+		if opt := newPDExclude(l.Prefix, exclude); opt != nil {
+			iaPrefix.Options.Add(opt)
+		}
+	}
+	resp.Options.Add(iaPrefix)
 }
 
 func dup(src *net.IPNet) (dst *net.IPNet) {
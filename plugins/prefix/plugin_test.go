@@ -7,6 +7,7 @@ package prefix
 import (
 	"net"
 	"testing"
+	"time"
 
 	"github.com/insomniacslk/dhcp/dhcpv6"
 	dhcpIana "github.com/insomniacslk/dhcp/iana"
@@ -79,6 +80,18 @@ func TestRoundTrip(t *testing.T) {
 		t.Fatalf("Response did not contain exactly one prefix in the IA_PD option (found %s)",
 			iapd.Options.Prefixes())
 	}
+
+	// Check the timers, with the default lifetimes of an hour
+	prefix := iapd.Options.Prefixes()[0]
+	if prefix.ValidLifetime < 3599*time.Second || prefix.ValidLifetime > 3600*time.Second {
+		t.Errorf("Unexpected valid lifetime %s", prefix.ValidLifetime)
+	}
+	if prefix.PreferredLifetime > prefix.ValidLifetime {
+		t.Errorf("Preferred lifetime %s exceeds valid lifetime %s", prefix.PreferredLifetime, prefix.ValidLifetime)
+	}
+	if iapd.T1 == 0 || iapd.T1 >= iapd.T2 || iapd.T2 >= prefix.PreferredLifetime {
+		t.Errorf("Unexpected T1 %s and T2 %s", iapd.T1, iapd.T2)
+	}
 }
 
 func TestDup(t *testing.T) {
@@ -91,3 +104,212 @@ func TestDup(t *testing.T) {
 		t.Fatalf("dup doesn't work: got %v expected %v", dupPrefix, prefix)
 	}
 }
+
+func TestSetupStrategy(t *testing.T) {
+	if _, err := setupPrefix("2001:db8::/48", "64", "strategy=random"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := setupPrefix("2001:db8::/48", "64", "strategy=first"); err == nil {
+		t.Fatal("Expected an error with an unknown strategy")
+	}
+	if _, err := setupPrefix("2001:db8::/48", "64", "bogus"); err == nil {
+		t.Fatal("Expected an error with an unknown argument")
+	}
+}
+
+func TestSetupLargePool(t *testing.T) {
+	// /128s out of a /64 are too many for the bitmap allocator
+	if _, err := setupPrefix("2001:db8::/64", "128"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// solicitPD returns a solicit for a prefix with the given hint, and the
+// corresponding advertise
+func solicitPD(t *testing.T, hint *net.IPNet, oro ...dhcpv6.OptionCode) (*dhcpv6.Message, *dhcpv6.Message) {
+	req, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddOption(dhcpv6.OptClientID(dhcpv6.Duid{
+		Type:          dhcpv6.DUID_LL,
+		HwType:        dhcpIana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+	}))
+	iapd := &dhcpv6.OptIAPD{IaId: [4]uint8{1, 2, 3, 4}}
+	iapd.Options.Add(&dhcpv6.OptIAPrefix{Prefix: hint})
+	req.AddOption(iapd)
+	if len(oro) > 0 {
+		req.AddOption(dhcpv6.OptRequestedOption(oro...))
+	}
+	resp, err := dhcpv6.NewAdvertiseFromSolicit(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req, resp
+}
+
+// delegated returns the single prefix delegated in a response
+func delegated(t *testing.T, resp dhcpv6.DHCPv6) *dhcpv6.OptIAPrefix {
+	if resp == nil {
+		t.Fatal("No response")
+	}
+	iapds := resp.(*dhcpv6.Message).Options.IAPD()
+	if len(iapds) != 1 || len(iapds[0].Options.Prefixes()) != 1 {
+		t.Fatalf("Expected exactly one delegated prefix, got %v", iapds)
+	}
+	return iapds[0].Options.Prefixes()[0]
+}
+
+func TestVariableLength(t *testing.T) {
+	handler, err := setupPrefix("2001:db8::/48", "64", "min=56")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		hint     string
+		expected int
+	}{
+		{"::/56", 56},
+		{"::/60", 60},
+		{"::/48", 56},
+		{"::/80", 64},
+	} {
+		_, hint, _ := net.ParseCIDR(tt.hint)
+		req, resp := solicitPD(t, hint)
+		result, _ := handler(req, resp)
+		if ones, _ := delegated(t, result).Prefix.Mask.Size(); ones != tt.expected {
+			t.Errorf("Expected a /%d for hint %s, got a /%d", tt.expected, tt.hint, ones)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"2001:db8::/48", "64", "min=40"},
+		{"2001:db8::/48", "64", "min=72"},
+		{"2001:db8::/48", "64", "min=x"},
+	} {
+		if _, err := setupPrefix(args...); err == nil {
+			t.Errorf("Expected an error with %v", args)
+		}
+	}
+}
+
+func TestPDExcludeEncoding(t *testing.T) {
+	for _, tt := range []struct {
+		delegated string
+		length    int
+		expected  []byte
+	}{
+		// RFC 6603 section 4.2: only the subnet ID bits following the
+		// delegated prefix are sent, padded to a byte boundary
+		{"2001:db8:0:ab00::/56", 64, []byte{64, 0x00}},
+		{"2001:db8:0:ab00::/56", 60, []byte{60, 0x00}},
+		{"2001:db8:0:ab00::/56", 72, []byte{72, 0x00, 0x00}},
+		{"2001:db8::/48", 49, []byte{49, 0x00}},
+	} {
+		_, d, _ := net.ParseCIDR(tt.delegated)
+		opt := newPDExclude(*d, tt.length)
+		if opt == nil {
+			t.Fatalf("No exclusion for /%d out of %s", tt.length, tt.delegated)
+		}
+		if got := opt.ToBytes(); string(got) != string(tt.expected) {
+			t.Errorf("Excluding /%d out of %s: expected %x, got %x", tt.length, tt.delegated, tt.expected, got)
+		}
+	}
+
+	// A subnet ID other than 0
+	_, excluded, _ := net.ParseCIDR("2001:db8:0:ab5a::/63")
+	opt := &optPDExclude{delegated: 56, excluded: *excluded}
+	if got := opt.ToBytes(); string(got) != string([]byte{63, 0x5a}) {
+		t.Errorf("Expected subnet ID 5a, got %x", got[1:])
+	}
+
+	_, d, _ := net.ParseCIDR("2001:db8:0:ab00::/56")
+	if newPDExclude(*d, 56) != nil || newPDExclude(*d, 48) != nil {
+		t.Error("Excluded a prefix not longer than the delegated prefix")
+	}
+}
+
+func TestPDExclude(t *testing.T) {
+	handler, err := setupPrefix("2001:db8::/48", "60", "min=56", "pd-exclude=64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, hint, _ := net.ParseCIDR("::/56")
+
+	req, resp := solicitPD(t, hint)
+	result, _ := handler(req, resp)
+	if opt := delegated(t, result).Options.GetOne(dhcpv6.OptionPDExclude); opt != nil {
+		t.Errorf("PD Exclude sent to a client which didn't request it: %s", opt)
+	}
+
+	req, resp = solicitPD(t, hint, dhcpv6.OptionPDExclude)
+	result, _ = handler(req, resp)
+	iaPrefix := delegated(t, result)
+	opt := iaPrefix.Options.GetOne(dhcpv6.OptionPDExclude)
+	if opt == nil {
+		t.Fatal("PD Exclude not sent to a client requesting it")
+	}
+	if excl := opt.(*optPDExclude).excluded; excl.String() != iaPrefix.Prefix.IP.String()+"/64" {
+		t.Errorf("Expected the first /64 of %s to be excluded, got %s", iaPrefix.Prefix, &excl)
+	}
+
+	// Prefixes of the longest length get it too
+	_, hint, _ = net.ParseCIDR("::/60")
+	req, resp = solicitPD(t, hint, dhcpv6.OptionPDExclude)
+	result, _ = handler(req, resp)
+	if opt := delegated(t, result).Options.GetOne(dhcpv6.OptionPDExclude); opt == nil {
+		t.Error("PD Exclude not sent with a /60")
+	}
+
+	for _, args := range [][]string{
+		{"2001:db8::/48", "64", "pd-exclude=64"},
+		// The /64s delegated could not carry the option
+		{"2001:db8::/48", "64", "min=48", "pd-exclude=56"},
+		{"2001:db8::/48", "64", "pd-exclude=64", "min=64"},
+		{"2001:db8::/48", "64", "min=56", "pd-exclude=129"},
+	} {
+		if _, err := setupPrefix(args...); err == nil {
+			t.Errorf("Expected an error with %v", args)
+		}
+	}
+}
+
+func TestSubnet(t *testing.T) {
+	handler, err := setupPrefix("2001:db8::/48", "64", "subnet=2001:db8:1::/64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		links     []string
+		delegated bool
+	}{
+		// On the link of the server
+		{nil, true},
+		{[]string{"2001:db8:1::1"}, true},
+		{[]string{"2001:db8:2::1"}, false},
+		// A lightweight relay leaves the link address unspecified, the next
+		// relay sets it
+		{[]string{"::", "2001:db8:1::1"}, true},
+	} {
+		req, resp := solicitPD(t, &net.IPNet{})
+		var relayed dhcpv6.DHCPv6 = req
+		for _, link := range tt.links {
+			relayed, err = dhcpv6.EncapsulateRelay(relayed, dhcpv6.MessageTypeRelayForward,
+				net.ParseIP(link), net.ParseIP("fe80::1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		result, _ := handler(relayed, resp)
+		if got := len(result.(*dhcpv6.Message).Options.IAPD()) > 0; got != tt.delegated {
+			t.Errorf("Expected delegation %t through %v, got %t", tt.delegated, tt.links, got)
+		}
+	}
+
+	for _, arg := range []string{"subnet=10.0.0.0/8", "subnet=2001:db8::1"} {
+		if _, err := setupPrefix("2001:db8::/48", "64", arg); err == nil {
+			t.Errorf("Expected an error with %s", arg)
+		}
+	}
+}
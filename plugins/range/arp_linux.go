@@ -0,0 +1,90 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+//go:build linux
+// +build linux
+
+package rangeplugin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	arpRequest = 1
+	arpReply   = 2
+)
+
+func htons(v uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return binary.LittleEndian.Uint16(b[:])
+}
+
+// probeARP sends an ARP probe (RFC 5227) for an address on the link of an
+// interface, and waits for a host owning it to answer
+func probeARP(iface *net.Interface, ip net.IP, timeout time.Duration) (bool, error) {
+	if len(iface.HardwareAddr) != 6 {
+		return false, errors.New("ARP probes need an Ethernet interface")
+	}
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return false, err
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ARP), Ifindex: iface.Index}); err != nil {
+		return false, err
+	}
+
+	// The sender address of a probe is 0.0.0.0, so that no host updates its
+	// ARP cache with it
+	req := make([]byte, 28)
+	binary.BigEndian.PutUint16(req[0:], 1) // Ethernet
+	binary.BigEndian.PutUint16(req[2:], unix.ETH_P_IP)
+	req[4], req[5] = 6, 4
+	binary.BigEndian.PutUint16(req[6:], arpRequest)
+	copy(req[8:14], iface.HardwareAddr)
+	copy(req[24:28], ip.To4())
+	broadcast := &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ARP),
+		Ifindex:  iface.Index,
+		Halen:    6,
+		Addr:     [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+	if err := unix.Sendto(fd, req, 0, broadcast); err != nil {
+		return false, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 1500)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false, nil
+		}
+		tv := unix.NsecToTimeval(remaining.Nanoseconds())
+		if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+			return false, err
+		}
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if err == unix.EAGAIN || err == unix.EINTR {
+				continue
+			}
+			return false, err
+		}
+		if n < 28 || binary.BigEndian.Uint16(buf[6:]) != arpReply {
+			continue
+		}
+		if bytes.Equal(buf[14:18], ip.To4()) {
+			return true, nil
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// Defaults of the conflict detection settings
+const (
+	DefaultProbeCache = time.Minute
+	DefaultQuarantine = time.Hour
+)
+
+// Prober checks whether an address is already in use on the network
+type Prober interface {
+	InUse(ip net.IP) (bool, error)
+}
+
+// conflictDetector probes addresses before they are offered, and keeps those
+// found in use out of the pool for a while. Probes run without the lock of the
+// plugin held, so it has a lock of its own, which isn't held while probing
+type conflictDetector struct {
+	sync.Mutex
+	prober     Prober
+	cacheTTL   time.Duration
+	quarantine time.Duration
+	// free holds addresses found free, until when the result is trusted
+	free map[string]time.Time
+	// quarantined holds addresses found in use, until when they are kept
+	// allocated
+	quarantined map[string]time.Time
+}
+
+func newConflictDetector(prober Prober) *conflictDetector {
+	return &conflictDetector{
+		prober:      prober,
+		cacheTTL:    DefaultProbeCache,
+		quarantine:  DefaultQuarantine,
+		free:        make(map[string]time.Time),
+		quarantined: make(map[string]time.Time),
+	}
+}
+
+// inUse returns whether an address is in use by a host on the network. Such
+// addresses are quarantined: the caller must keep them allocated until
+// release frees them
+func (c *conflictDetector) inUse(ip net.IP) bool {
+	key := ip.To4().String()
+	c.Lock()
+	now := time.Now()
+	if until, ok := c.quarantined[key]; ok && now.Before(until) {
+		c.Unlock()
+		return true
+	}
+	if until, ok := c.free[key]; ok && now.Before(until) {
+		c.Unlock()
+		return false
+	}
+	c.Unlock()
+
+	inUse, err := c.prober.InUse(ip)
+	c.Lock()
+	defer c.Unlock()
+	now = time.Now()
+	if err != nil {
+		// Not being able to probe shouldn't prevent handing out addresses
+		log.Warningf("Could not probe IP address %s: %v", ip, err)
+		return false
+	}
+	if inUse {
+		log.Warningf("IP address %s is in use by another host, quarantining it for %s", ip, c.quarantine)
+		delete(c.free, key)
+		c.quarantined[key] = now.Add(c.quarantine)
+		return true
+	}
+	c.free[key] = now.Add(c.cacheTTL)
+	return false
+}
+
+// release returns the addresses whose quarantine is over to the pool, and
+// forgets outdated probe results
+func (c *conflictDetector) release(alloc allocators.Allocator) {
+	c.Lock()
+	defer c.Unlock()
+	now := time.Now()
+	for key, until := range c.free {
+		if !now.Before(until) {
+			delete(c.free, key)
+		}
+	}
+	for key, until := range c.quarantined {
+		if now.Before(until) {
+			continue
+		}
+		delete(c.quarantined, key)
+		ip := net.ParseIP(key).To4()
+		if err := alloc.Free(net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}); err != nil {
+			log.Debugf("Could not return quarantined address %s to the pool: %v", ip, err)
+		}
+	}
+}
+
+// netProber probes addresses with an ICMP echo request, and with an ARP probe
+// (RFC 5227) when they are on a directly attached link. Hosts may not answer
+// ICMP, but they always answer ARP
+type netProber struct {
+	timeout time.Duration
+}
+
+// InUse sends the probes and waits for an answer until the timeout
+func (n *netProber) InUse(ip net.IP) (bool, error) {
+	type result struct {
+		inUse bool
+		err   error
+	}
+	probes := []func(net.IP, time.Duration) (bool, error){pingICMP}
+	if iface := attachedInterface(ip); iface != nil {
+		probes = append(probes, func(ip net.IP, timeout time.Duration) (bool, error) {
+			return probeARP(iface, ip, timeout)
+		})
+	}
+	results := make(chan result, len(probes))
+	for _, probe := range probes {
+		go func(probe func(net.IP, time.Duration) (bool, error)) {
+			inUse, err := probe(ip, n.timeout)
+			results <- result{inUse, err}
+		}(probe)
+	}
+
+	var errs []string
+	for range probes {
+		r := <-results
+		if r.inUse {
+			return true, nil
+		}
+		if r.err != nil {
+			errs = append(errs, r.err.Error())
+		}
+	}
+	if len(errs) == len(probes) {
+		return false, fmt.Errorf("all probes failed: %v", errs)
+	}
+	return false, nil
+}
+
+// attachedInterface returns the interface on whose link an address is, if any
+func attachedInterface(ip net.IP) *net.Interface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for i := range ifaces {
+		if ifaces[i].Flags&net.FlagUp == 0 || ifaces[i].Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := ifaces[i].Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if n, ok := addr.(*net.IPNet); ok && n.IP.To4() != nil && n.Contains(ip) {
+				return &ifaces[i]
+			}
+		}
+	}
+	return nil
+}
+
+// pingICMP sends an ICMP echo request, and waits for the reply
+func pingICMP(ip net.IP, timeout time.Duration) (bool, error) {
+	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	echo := &icmp.Echo{ID: os.Getpid() & 0xffff, Seq: rand.Intn(1 << 16), Data: []byte("coredhcp")}
+	msg, err := (&icmp.Message{Type: ipv4.ICMPTypeEcho, Body: echo}).Marshal(nil)
+	if err != nil {
+		return false, err
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return false, err
+	}
+	if _, err := conn.WriteTo(msg, &net.IPAddr{IP: ip}); err != nil {
+		return false, err
+	}
+
+	// The socket receives all the ICMP traffic of the host, skip the rest
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return false, nil
+			}
+			return false, err
+		}
+		if addr, ok := peer.(*net.IPAddr); !ok || !addr.IP.Equal(ip) {
+			continue
+		}
+		reply, err := icmp.ParseMessage(ipv4.ICMPTypeEcho.Protocol(), buf[:n])
+		if err != nil || reply.Type != ipv4.ICMPTypeEchoReply {
+			continue
+		}
+		if body, ok := reply.Body.(*icmp.Echo); ok && body.ID == echo.ID && body.Seq == echo.Seq {
+			return true, nil
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators/bitmap"
+	"github.com/coredhcp/coredhcp/plugins/leasetime"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProber reports the addresses of a set as in use, and counts probes
+type fakeProber struct {
+	used   map[string]bool
+	probes int
+}
+
+func (f *fakeProber) InUse(ip net.IP) (bool, error) {
+	f.probes++
+	return f.used[ip.String()], nil
+}
+
+// blockingProber blocks probes until unblocked
+type blockingProber struct {
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (b *blockingProber) InUse(ip net.IP) (bool, error) {
+	b.started <- struct{}{}
+	<-b.unblock
+	return false, nil
+}
+
+func TestConflictDetector(t *testing.T) {
+	prober := &fakeProber{used: map[string]bool{"10.0.0.1": true}}
+	c := newConflictDetector(prober)
+	alloc, err := bitmap.NewIPv4Allocator(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2))
+	require.NoError(t, err)
+
+	// Results are cached
+	assert.False(t, c.inUse(net.IPv4(10, 0, 0, 2)))
+	assert.False(t, c.inUse(net.IPv4(10, 0, 0, 2)))
+	assert.True(t, c.inUse(net.IPv4(10, 0, 0, 1)))
+	assert.True(t, c.inUse(net.IPv4(10, 0, 0, 1)))
+	assert.Equal(t, 2, prober.probes)
+
+	// Quarantined addresses go back to the pool once the quarantine is over
+	n, err := alloc.Allocate(net.IPNet{})
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.1", n.IP.String())
+	c.release(alloc)
+	assert.Contains(t, c.quarantined, "10.0.0.1")
+	c.quarantined["10.0.0.1"] = time.Now()
+	c.free["10.0.0.2"] = time.Now()
+	c.release(alloc)
+	assert.Empty(t, c.quarantined)
+	assert.Empty(t, c.free)
+	n, err = alloc.Allocate(net.IPNet{})
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", n.IP.String())
+}
+
+func TestHandler4Conflicts(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "coredhcptest")
+	if err != nil {
+		t.Skipf("Could not setup file-based test: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	prober := &fakeProber{used: map[string]bool{"10.0.0.1": true}}
+	p := PluginState{
+		Recordsv4:   make(map[string]*Record),
+		LeasePolicy: leasetime.NewPolicy(time.Hour),
+		conflicts:   newConflictDetector(prober),
+	}
+	p.allocator, err = bitmap.NewIPv4Allocator(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 3))
+	require.NoError(t, err)
+	require.NoError(t, p.registerBackingFile(tmpfile.Name()))
+	defer p.leasefile.Close()
+
+	handle := func(mac net.HardwareAddr, msgType dhcpv4.MessageType) net.IP {
+		req, err := dhcpv4.NewDiscovery(mac, dhcpv4.WithMessageType(msgType))
+		require.NoError(t, err)
+		stub, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		resp, _ := p.Handler4(req, stub)
+		require.NotNil(t, resp)
+		return resp.YourIPAddr
+	}
+	first := net.HardwareAddr{0xaa, 0, 0, 0, 0, 1}
+
+	// The address in use is skipped, and repeated DISCOVERs use the cache
+	assert.Equal(t, "10.0.0.2", handle(first, dhcpv4.MessageTypeDiscover).String())
+	assert.Equal(t, "10.0.0.2", handle(first, dhcpv4.MessageTypeDiscover).String())
+	assert.Equal(t, 2, prober.probes)
+
+	// The address of a client is never probed again, as the client may
+	// already use it
+	prober.used["10.0.0.2"] = true
+	p.conflicts.free["10.0.0.2"] = time.Now()
+	assert.Equal(t, "10.0.0.2", handle(first, dhcpv4.MessageTypeRequest).String())
+	assert.Equal(t, "10.0.0.2", handle(first, dhcpv4.MessageTypeDiscover).String())
+	assert.Equal(t, 2, prober.probes)
+	assert.NotContains(t, p.conflicts.quarantined, "10.0.0.2")
+
+	// Only new addresses are
+	assert.Equal(t, "10.0.0.3", handle(net.HardwareAddr{0xaa, 0, 0, 0, 0, 2}, dhcpv4.MessageTypeDiscover).String())
+	assert.Equal(t, 3, prober.probes)
+}
+
+func TestHandler4ProbeUnlocked(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "coredhcptest")
+	if err != nil {
+		t.Skipf("Could not setup file-based test: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	prober := &blockingProber{started: make(chan struct{}), unblock: make(chan struct{})}
+	p := PluginState{
+		Recordsv4:   make(map[string]*Record),
+		LeasePolicy: leasetime.NewPolicy(time.Hour),
+		conflicts:   newConflictDetector(prober),
+	}
+	p.allocator, err = bitmap.NewIPv4Allocator(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 3))
+	require.NoError(t, err)
+	require.NoError(t, p.registerBackingFile(tmpfile.Name()))
+	defer p.leasefile.Close()
+
+	handle := func(mac net.HardwareAddr, msgType dhcpv4.MessageType) net.IP {
+		req, err := dhcpv4.NewDiscovery(mac, dhcpv4.WithMessageType(msgType))
+		require.NoError(t, err)
+		stub, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		resp, _ := p.Handler4(req, stub)
+		require.NotNil(t, resp)
+		return resp.YourIPAddr
+	}
+
+	offered := make(chan net.IP)
+	go func() { offered <- handle(net.HardwareAddr{0xaa, 0, 0, 0, 0, 1}, dhcpv4.MessageTypeDiscover) }()
+	<-prober.started
+	// Other clients are served while the address offered to the first one
+	// is probed, and don't get it
+	assert.Equal(t, "10.0.0.2", handle(net.HardwareAddr{0xaa, 0, 0, 0, 0, 2}, dhcpv4.MessageTypeRequest).String())
+	close(prober.unblock)
+	assert.Equal(t, "10.0.0.1", (<-offered).String())
+}
@@ -5,11 +5,11 @@
 package rangeplugin
 
 import (
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"net"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,7 +17,9 @@ import (
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
 	"github.com/coredhcp/coredhcp/plugins/allocators"
-	"github.com/coredhcp/coredhcp/plugins/allocators/bitmap"
+	"github.com/coredhcp/coredhcp/plugins/file"
+	"github.com/coredhcp/coredhcp/plugins/leasetime"
+	"github.com/coredhcp/coredhcp/plugins/relayinfo"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 )
 
@@ -41,27 +43,155 @@ type PluginState struct {
 	sync.Mutex
 	// Recordsv4 holds a MAC -> IP address and lease time mapping
 	Recordsv4 map[string]*Record
-	LeaseTime time.Duration
-	leasefile *os.File
-	allocator allocators.Allocator
+	// LeasePolicy decides the lease time of the clients, unless an earlier
+	// plugin such as lease_time already set it in the response
+	LeasePolicy *leasetime.Policy
+	leasefile   *os.File
+	allocator   allocators.Allocator
+	// conflicts probes addresses before offering them, when enabled
+	conflicts *conflictDetector
+	// hashRanges are the ranges of the pool when the hash strategy is used
+	hashRanges [][2]net.IP
+	// subnet restricts the plugin to the clients of a subnet, when set
+	subnet *net.IPNet
+}
+
+// Prefixes of the arguments adding ranges to the pool, listing addresses the
+// plugin must never lease, such as the server's own address and gateways,
+// setting up conflict detection and restricting the plugin to a subnet
+const (
+	rangeArg      = "range="
+	excludeArg    = "exclude="
+	probeArg      = "probe="
+	probeCacheArg = "probe-cache="
+	quarantineArg = "quarantine="
+	strategyArg   = "strategy="
+	subnetArg     = "subnet="
+)
+
+// unusable returns whether an address must not be leased dynamically: it is
+// reserved for a client in the file plugin, or it is the address of the server
+// or of a gateway, as set in the response by earlier plugins
+func unusable(ip net.IP, resp *dhcpv4.DHCPv4) bool {
+	if file.Reserved4(ip) {
+		return true
+	}
+	if sid := resp.ServerIdentifier(); sid != nil && sid.Equal(ip) {
+		return true
+	}
+	for _, router := range resp.Router() {
+		if router.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// probing returns whether a new address offered to a client must be probed
+// first. Only clients sending a DISCOVER are not using their address yet
+func (p *PluginState) probing(req *dhcpv4.DHCPv4) bool {
+	return p.conflicts != nil && req.MessageType() == dhcpv4.MessageTypeDiscover
+}
+
+// allocate allocates a new address that can be leased. It is called with the
+// lock held, which is released while the address is probed: it is already
+// allocated by then, so no other client gets it
+func (p *PluginState) allocate(req, resp *dhcpv4.DHCPv4) (net.IP, error) {
+	if p.conflicts != nil {
+		p.conflicts.release(p.allocator)
+	}
+	var hint net.IPNet
+	if p.hashRanges != nil {
+		hint = hashHint(p.hashRanges, clientIdentity(req))
+	}
+	// The addresses skipped are left allocated until an address is found, so
+	// that they aren't tried again, and freed then: reservations change when
+	// the file is reloaded. Quarantined addresses are freed by release
+	var skipped []net.IPNet
+	defer func() {
+		for _, ip := range skipped {
+			if err := p.allocator.Free(ip); err != nil {
+				log.Errorf("Could not free skipped address %s: %v", ip.IP, err)
+			}
+		}
+	}()
+	for {
+		ip, err := p.allocator.Allocate(hint)
+		if err != nil {
+			return nil, err
+		}
+		if unusable(ip.IP, resp) {
+			log.Debugf("Skipping address %s, which is reserved or in use by the server", ip.IP)
+			skipped = append(skipped, ip)
+			continue
+		}
+		if p.probing(req) {
+			p.Unlock()
+			inUse := p.conflicts.inUse(ip.IP)
+			p.Lock()
+			if inUse {
+				continue
+			}
+		}
+		return ip.IP.To4(), nil
+	}
 }
 
 // Handler4 handles DHCPv4 packets for the range plugin
 func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	// Leases are kept until they expire, a release mustn't renew them
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeRelease, dhcpv4.MessageTypeDecline:
+		return resp, false
+	}
+	// Clients of other subnets are left to the next plugins. Clients on the
+	// link of the server have no link address, and are served by any subnet
+	if p.subnet != nil {
+		if link := relayinfo.LinkAddr4(req); link != nil && !p.subnet.Contains(link) {
+			return resp, false
+		}
+	}
+	leaseTime := resp.IPAddressLeaseTime(0)
+	if leaseTime == 0 {
+		leaseTime = p.LeasePolicy.LeaseTime(req)
+	}
+	// Clients with a reservation get their reserved address, not one from the pool
+	if res, ok := file.Lookup4(req); ok {
+		resp.YourIPAddr = res.Addresses[0]
+		p.LeasePolicy.Apply(resp, leaseTime)
+		log.Printf("using reserved IP address %s for MAC %s", resp.YourIPAddr, req.ClientHWAddr.String())
+		return resp, false
+	}
 	p.Lock()
 	defer p.Unlock()
 	record, ok := p.Recordsv4[req.ClientHWAddr.String()]
+	if ok && file.Reserved4(record.IP) {
+		// The address was reserved for another client since it was leased
+		log.Warningf("IP address %s of MAC %s is now reserved, leasing another one", record.IP, req.ClientHWAddr.String())
+		p.dropRecord(req.ClientHWAddr)
+		ok = false
+	}
 	if !ok {
 		// Allocating new address since there isn't one allocated
 		log.Printf("MAC address %s is new, leasing new IPv4 address", req.ClientHWAddr.String())
-		ip, err := p.allocator.Allocate(net.IPNet{})
+		ip, err := p.allocate(req, resp)
 		if err != nil {
 			log.Errorf("Could not allocate IP for MAC %s: %v", req.ClientHWAddr.String(), err)
 			return nil, true
 		}
+		if other, ok := p.Recordsv4[req.ClientHWAddr.String()]; ok {
+			// Another request of the client got an address while this one
+			// was probed
+			if err := p.allocator.Free(net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}); err != nil {
+				log.Warningf("Could not free IP address %s: %v", ip, err)
+			}
+			resp.YourIPAddr = other.IP
+			p.LeasePolicy.Apply(resp, leaseTime)
+			return resp, false
+		}
 		rec := Record{
-			IP:      ip.IP.To4(),
-			expires: time.Now().Add(p.LeaseTime),
+			IP:      ip,
+			expires: time.Now().Add(leaseTime),
 		}
 		err = p.saveIPAddress(req.ClientHWAddr, &rec)
 		if err != nil {
@@ -71,8 +201,8 @@ func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool)
 		record = &rec
 	} else {
 		// Ensure we extend the existing lease at least past when the one we're giving expires
-		if record.expires.Before(time.Now().Add(p.LeaseTime)) {
-			record.expires = time.Now().Add(p.LeaseTime).Round(time.Second)
+		if record.expires.Before(time.Now().Add(leaseTime)) {
+			record.expires = time.Now().Add(leaseTime).Round(time.Second)
 			err := p.saveIPAddress(req.ClientHWAddr, record)
 			if err != nil {
 				log.Errorf("Could not persist lease for MAC %s: %v", req.ClientHWAddr.String(), err)
@@ -80,7 +210,7 @@ func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool)
 		}
 	}
 	resp.YourIPAddr = record.IP
-	resp.Options.Update(dhcpv4.OptIPAddressLeaseTime(p.LeaseTime.Round(time.Second)))
+	p.LeasePolicy.Apply(resp, leaseTime)
 	log.Printf("found IP address %s for MAC %s", record.IP, req.ClientHWAddr.String())
 	return resp, false
 }
@@ -92,32 +222,118 @@ func setupRange(args ...string) (handler.Handler4, error) {
 	)
 
 	if len(args) < 4 {
-		return nil, fmt.Errorf("invalid number of arguments, want: 4 (file name, start IP, end IP, lease time), got: %d", len(args))
+		return nil, fmt.Errorf("invalid number of arguments, want: 4 (file name, start IP, end IP, lease time) and optional settings, got: %d", len(args))
 	}
 	filename := args[0]
 	if filename == "" {
 		return nil, errors.New("file name cannot be empty")
 	}
-	ipRangeStart := net.ParseIP(args[1])
-	if ipRangeStart.To4() == nil {
-		return nil, fmt.Errorf("invalid IPv4 address: %v", args[1])
+	start, end, err := parseRange(args[1], args[2])
+	if err != nil {
+		return nil, err
 	}
-	ipRangeEnd := net.ParseIP(args[2])
-	if ipRangeEnd.To4() == nil {
-		return nil, fmt.Errorf("invalid IPv4 address: %v", args[2])
+	ranges := [][2]net.IP{{start, end}}
+
+	leaseTime, err := time.ParseDuration(args[3])
+	if err != nil {
+		return nil, fmt.Errorf("invalid lease duration: %v", args[3])
+	}
+	var (
+		policyArgs, exclusions             []string
+		probeTimeout, cacheTTL, quarantine time.Duration
+		strategy                           = allocators.Sequential
+		hash                               bool
+	)
+	for _, arg := range args[4:] {
+		switch {
+		case strings.HasPrefix(arg, strategyArg):
+			switch name := strings.TrimPrefix(arg, strategyArg); name {
+			case strategyHash:
+				strategy, hash = allocators.Sequential, true
+			case strategyLowest:
+				strategy, hash = allocators.Sequential, false
+			default:
+				if strategy, err = allocators.ParseStrategy(name); err != nil {
+					return nil, fmt.Errorf("unknown allocation strategy, want %s, %s or %s: %v", allocators.Sequential, allocators.Random, strategyHash, name)
+				}
+				hash = false
+			}
+		case strings.HasPrefix(arg, probeArg):
+			if probeTimeout, err = parseDurationArg(arg, probeArg); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(arg, probeCacheArg):
+			if cacheTTL, err = parseDurationArg(arg, probeCacheArg); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(arg, quarantineArg):
+			if quarantine, err = parseDurationArg(arg, quarantineArg); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(arg, rangeArg):
+			bounds := strings.SplitN(strings.TrimPrefix(arg, rangeArg), "-", 2)
+			if len(bounds) != 2 {
+				return nil, fmt.Errorf("malformed range, want <start IP>-<end IP>: %v", arg)
+			}
+			start, end, err := parseRange(bounds[0], bounds[1])
+			if err != nil {
+				return nil, err
+			}
+			ranges = append(ranges, [2]net.IP{start, end})
+		case strings.HasPrefix(arg, excludeArg):
+			exclusions = append(exclusions, strings.TrimPrefix(arg, excludeArg))
+		case strings.HasPrefix(arg, subnetArg):
+			_, subnet, err := net.ParseCIDR(strings.TrimPrefix(arg, subnetArg))
+			if err != nil || subnet.IP.To4() == nil {
+				return nil, fmt.Errorf("invalid subnet, want an IPv4 CIDR: %v", arg)
+			}
+			p.subnet = subnet
+		default:
+			policyArgs = append(policyArgs, arg)
+		}
 	}
-	if binary.BigEndian.Uint32(ipRangeStart.To4()) >= binary.BigEndian.Uint32(ipRangeEnd.To4()) {
-		return nil, errors.New("start of IP range has to be lower than the end of an IP range")
+	if p.subnet != nil {
+		for _, r := range ranges {
+			if !p.subnet.Contains(r[0]) || !p.subnet.Contains(r[1]) {
+				return nil, fmt.Errorf("range %s-%s is outside of subnet %s", r[0], r[1], p.subnet)
+			}
+		}
 	}
-
-	p.allocator, err = bitmap.NewIPv4Allocator(ipRangeStart, ipRangeEnd)
+	p.allocator, err = newPool(ranges)
 	if err != nil {
 		return nil, fmt.Errorf("could not create an allocator: %w", err)
 	}
-
-	p.LeaseTime, err = time.ParseDuration(args[3])
-	if err != nil {
-		return nil, fmt.Errorf("invalid lease duration: %v", args[3])
+	if hash {
+		p.hashRanges = ranges
+	}
+	if strategy != allocators.Sequential {
+		setter, ok := p.allocator.(allocators.StrategySetter)
+		if !ok {
+			return nil, fmt.Errorf("the allocator does not support the %s strategy", strategy)
+		}
+		if err := setter.SetStrategy(strategy); err != nil {
+			return nil, fmt.Errorf("could not use the %s strategy: %w", strategy, err)
+		}
+	}
+	for _, list := range exclusions {
+		if err := exclude(p.allocator, list); err != nil {
+			return nil, err
+		}
+	}
+	if probeTimeout != 0 {
+		p.conflicts = newConflictDetector(&netProber{timeout: probeTimeout})
+		if cacheTTL != 0 {
+			p.conflicts.cacheTTL = cacheTTL
+		}
+		if quarantine != 0 {
+			p.conflicts.quarantine = quarantine
+		}
+	} else if cacheTTL != 0 || quarantine != 0 {
+		return nil, fmt.Errorf("%s and %s need conflict detection to be enabled with %s", probeCacheArg, quarantineArg, probeArg)
+	}
+	p.LeasePolicy = leasetime.NewPolicy(leaseTime)
+	if err := p.LeasePolicy.Parse(policyArgs...); err != nil {
+		return nil, fmt.Errorf("invalid lease time settings: %w", err)
 	}
 
 	p.Recordsv4, err = loadRecordsFromFile(filename)
@@ -126,6 +342,9 @@ func setupRange(args ...string) (handler.Handler4, error) {
 	}
 
 	log.Printf("Loaded %d DHCPv4 leases from %s", len(p.Recordsv4), filename)
+	if err := p.restoreLeases(); err != nil {
+		return nil, fmt.Errorf("could not restore leases: %w", err)
+	}
 
 	if err := p.registerBackingFile(filename); err != nil {
 		return nil, fmt.Errorf("could not setup lease storage: %w", err)
@@ -133,3 +352,12 @@ func setupRange(args ...string) (handler.Handler4, error) {
 
 	return p.Handler4, nil
 }
+
+// parseDurationArg parses the positive duration of a <prefix><duration> argument
+func parseDurationArg(arg, prefix string) (time.Duration, error) {
+	d, err := time.ParseDuration(strings.TrimPrefix(arg, prefix))
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration for %s: %v", strings.TrimSuffix(prefix, "="), arg)
+	}
+	return d, nil
+}
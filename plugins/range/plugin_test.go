@@ -0,0 +1,195 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators"
+	"github.com/coredhcp/coredhcp/plugins/allocators/bitmap"
+	"github.com/coredhcp/coredhcp/plugins/file"
+	"github.com/coredhcp/coredhcp/plugins/leasetime"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler4LeaseTime(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "coredhcptest")
+	if err != nil {
+		t.Skipf("Could not setup file-based test: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	p := PluginState{
+		Recordsv4:   make(map[string]*Record),
+		LeasePolicy: leasetime.NewPolicy(time.Hour),
+	}
+	require.NoError(t, p.LeasePolicy.Parse("max=2h"))
+	p.allocator, err = bitmap.NewIPv4Allocator(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 10))
+	require.NoError(t, err)
+	require.NoError(t, p.registerBackingFile(tmpfile.Name()))
+	defer p.leasefile.Close()
+
+	mac := net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+	req, err := dhcpv4.NewDiscovery(mac, dhcpv4.WithLeaseTime(86400))
+	require.NoError(t, err)
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+
+	resp, stop := p.Handler4(req, stub)
+	require.NotNil(t, resp)
+	assert.False(t, stop)
+	assert.Equal(t, 2*time.Hour, resp.IPAddressLeaseTime(0))
+	assert.Equal(t, time.Hour, resp.IPAddressRenewalTime(0))
+	assert.Equal(t, 105*time.Minute, resp.IPAddressRebindingTime(0))
+	expires := p.Recordsv4[mac.String()].expires
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), expires, time.Minute)
+
+	// A lease time set by an earlier plugin takes precedence, and the
+	// existing lease is not shortened
+	stub, err = dhcpv4.NewReplyFromRequest(req, dhcpv4.WithLeaseTime(600))
+	require.NoError(t, err)
+	resp, _ = p.Handler4(req, stub)
+	assert.Equal(t, 10*time.Minute, resp.IPAddressLeaseTime(0))
+	assert.Equal(t, 5*time.Minute, resp.IPAddressRenewalTime(0))
+	assert.Equal(t, expires, p.Recordsv4[mac.String()].expires)
+
+	// A release doesn't renew the lease
+	release, err := dhcpv4.New(dhcpv4.WithHwAddr(mac), dhcpv4.WithMessageType(dhcpv4.MessageTypeRelease),
+		dhcpv4.WithClientIP(resp.YourIPAddr))
+	require.NoError(t, err)
+	stub, err = dhcpv4.NewReplyFromRequest(release)
+	require.NoError(t, err)
+	resp, stop = p.Handler4(release, stub)
+	assert.Equal(t, stub, resp)
+	assert.False(t, stop)
+	assert.Equal(t, expires, p.Recordsv4[mac.String()].expires)
+}
+
+func TestHandler4Reservations(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "coredhcptest")
+	if err != nil {
+		t.Skipf("Could not setup file-based test: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	p := PluginState{
+		Recordsv4:   make(map[string]*Record),
+		LeasePolicy: leasetime.NewPolicy(time.Hour),
+	}
+	p.allocator, err = bitmap.NewIPv4Allocator(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 10))
+	require.NoError(t, err)
+	require.NoError(t, exclude(p.allocator, "10.0.0.1,10.0.0.8/30"))
+	require.Error(t, exclude(p.allocator, "2001:db8::1"))
+	require.NoError(t, p.registerBackingFile(tmpfile.Name()))
+	defer p.leasefile.Close()
+
+	reserved := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	file.DHCPv4Records = file.NewRecords([]*file.Reservation{
+		{Match: file.Identifiers{file.IDMAC: reserved}, Addresses: []net.IP{net.IPv4(10, 0, 0, 2)}},
+	})
+	defer func() { file.DHCPv4Records = nil }()
+
+	// Earlier plugins set the server's address and the gateway
+	serverModifiers := []dhcpv4.Modifier{
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IPv4(10, 0, 0, 3))),
+		dhcpv4.WithRouter(net.IPv4(10, 0, 0, 6)),
+	}
+	handle := func(mac net.HardwareAddr) *dhcpv4.DHCPv4 {
+		req, err := dhcpv4.NewDiscovery(mac)
+		require.NoError(t, err)
+		stub, err := dhcpv4.NewReplyFromRequest(req, serverModifiers...)
+		require.NoError(t, err)
+		resp, stop := p.Handler4(req, stub)
+		// Processing only stops when no address could be given
+		assert.Equal(t, resp == nil, stop)
+		return resp
+	}
+
+	// The reserved client gets its address, without taking one from the pool
+	assert.Equal(t, "10.0.0.2", handle(reserved).YourIPAddr.String())
+	assert.NotContains(t, p.Recordsv4, reserved.String())
+
+	// Others skip the excluded and reserved addresses, and the server's
+	assert.Equal(t, "10.0.0.4", handle(net.HardwareAddr{0xaa, 0, 0, 0, 0, 1}).YourIPAddr.String())
+	assert.Equal(t, "10.0.0.5", handle(net.HardwareAddr{0xaa, 0, 0, 0, 0, 2}).YourIPAddr.String())
+	assert.Equal(t, "10.0.0.7", handle(net.HardwareAddr{0xaa, 0, 0, 0, 0, 3}).YourIPAddr.String())
+
+	// A lease whose address is reserved later is moved to another address:
+	// the previously reserved address, which wasn't kept out of the pool
+	file.DHCPv4Records = file.NewRecords([]*file.Reservation{
+		{Match: file.Identifiers{file.IDMAC: reserved}, Addresses: []net.IP{net.IPv4(10, 0, 0, 5)}},
+	})
+	inspector := p.allocator.(allocators.Inspector)
+	assert.Equal(t, uint64(3), inspector.Used())
+	assert.Equal(t, "10.0.0.2", handle(net.HardwareAddr{0xaa, 0, 0, 0, 0, 2}).YourIPAddr.String())
+	// The address of the dropped lease went back to the pool
+	assert.Equal(t, uint64(3), inspector.Used())
+	assert.False(t, inspector.IsAllocated(net.IPv4(10, 0, 0, 5)))
+
+	// and dropped when there is none left
+	file.DHCPv4Records = file.NewRecords([]*file.Reservation{
+		{Match: file.Identifiers{file.IDMAC: reserved}, Addresses: []net.IP{net.IPv4(10, 0, 0, 5)}},
+		{Match: file.Identifiers{file.IDMAC: net.HardwareAddr{0xbb, 0, 0, 0, 0, 1}}, Addresses: []net.IP{net.IPv4(10, 0, 0, 2)}},
+	})
+	assert.Nil(t, handle(net.HardwareAddr{0xaa, 0, 0, 0, 0, 2}))
+	assert.NotContains(t, p.Recordsv4, "aa:00:00:00:00:02")
+	// The dropped lease isn't restored at startup
+	stored, err := loadRecordsFromFile(tmpfile.Name())
+	require.NoError(t, err)
+	assert.False(t, stored["aa:00:00:00:00:02"].expires.After(time.Now()))
+}
+
+func TestHandler4Subnet(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "coredhcptest")
+	if err != nil {
+		t.Skipf("Could not setup file-based test: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	p := PluginState{
+		Recordsv4:   make(map[string]*Record),
+		LeasePolicy: leasetime.NewPolicy(time.Hour),
+	}
+	_, p.subnet, err = net.ParseCIDR("10.0.1.0/24")
+	require.NoError(t, err)
+	p.allocator, err = bitmap.NewIPv4Allocator(net.IPv4(10, 0, 1, 10), net.IPv4(10, 0, 1, 20))
+	require.NoError(t, err)
+	require.NoError(t, p.registerBackingFile(tmpfile.Name()))
+	defer p.leasefile.Close()
+
+	for i, tc := range []struct {
+		modifiers []dhcpv4.Modifier
+		served    bool
+	}{
+		// On the link of the server
+		{nil, true},
+		{[]dhcpv4.Modifier{dhcpv4.WithGatewayIP(net.IPv4(10, 0, 1, 1))}, true},
+		{[]dhcpv4.Modifier{dhcpv4.WithGatewayIP(net.IPv4(10, 0, 2, 1))}, false},
+		// The link selection sub-option takes precedence over giaddr
+		{[]dhcpv4.Modifier{
+			dhcpv4.WithGatewayIP(net.IPv4(10, 0, 2, 1)),
+			dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(
+				dhcpv4.OptGeneric(dhcpv4.LinkSelectionSubOption, []byte{10, 0, 1, 0}))),
+		}, true},
+	} {
+		req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xaa, 0, 0, 0, 0, byte(i)}, tc.modifiers...)
+		require.NoError(t, err)
+		stub, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		resp, stop := p.Handler4(req, stub)
+		require.NotNil(t, resp)
+		assert.False(t, stop)
+		assert.Equal(t, tc.served, !resp.YourIPAddr.IsUnspecified(), "case %d", i)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators"
+	"github.com/coredhcp/coredhcp/plugins/allocators/bitmap"
+	"github.com/coredhcp/coredhcp/plugins/allocators/composite"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// Besides the strategies of the allocators, addresses can be derived from a
+// hash of the client identity, so that a client tends to get the same address
+// from any server with the same ranges, even without its lease. lowest is an
+// alias of the sequential strategy
+const (
+	strategyHash   = "hash"
+	strategyLowest = "lowest"
+)
+
+// parseRange parses the bounds of a range of IPv4 addresses
+func parseRange(first, last string) (start, end net.IP, err error) {
+	start = net.ParseIP(first).To4()
+	if start == nil {
+		return nil, nil, fmt.Errorf("invalid IPv4 address: %v", first)
+	}
+	end = net.ParseIP(last).To4()
+	if end == nil {
+		return nil, nil, fmt.Errorf("invalid IPv4 address: %v", last)
+	}
+	if binary.BigEndian.Uint32(start) >= binary.BigEndian.Uint32(end) {
+		return nil, nil, errors.New("start of IP range has to be lower than the end of an IP range")
+	}
+	return start, end, nil
+}
+
+// newPool creates an allocator for a pool made of several ranges of IPv4
+// addresses, which must not overlap. Addresses are allocated from the lowest
+// range first
+func newPool(ranges [][2]net.IP) (allocators.Allocator, error) {
+	sorted := make([][2]net.IP, len(ranges))
+	for i, r := range ranges {
+		sorted[i] = [2]net.IP{r[0].To4(), r[1].To4()}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return binary.BigEndian.Uint32(sorted[i][0]) < binary.BigEndian.Uint32(sorted[j][0])
+	})
+	for i := 1; i < len(sorted); i++ {
+		if binary.BigEndian.Uint32(sorted[i][0]) <= binary.BigEndian.Uint32(sorted[i-1][1]) {
+			return nil, fmt.Errorf("range %s-%s overlaps range %s-%s", sorted[i][0], sorted[i][1], sorted[i-1][0], sorted[i-1][1])
+		}
+	}
+
+	members := make([]composite.Member, 0, len(ranges))
+	for _, r := range ranges {
+		alloc, err := bitmap.NewIPv4Allocator(r[0], r[1])
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, alloc)
+	}
+	return composite.New(members...)
+}
+
+// rangeToNets splits a range of IPv4 addresses into the smallest list of
+// subnets covering it
+func rangeToNets(start, end net.IP) []net.IPNet {
+	var nets []net.IPNet
+	first, last := uint64(binary.BigEndian.Uint32(start.To4())), uint64(binary.BigEndian.Uint32(end.To4()))
+	for first <= last {
+		// The largest block aligned on first, and not going past last
+		size := uint(0)
+		for size < 32 && first&(1<<(size+1)-1) == 0 && first+1<<(size+1)-1 <= last {
+			size++
+		}
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, uint32(first))
+		nets = append(nets, net.IPNet{IP: ip, Mask: net.CIDRMask(32-int(size), 32)})
+		first += 1 << size
+	}
+	return nets
+}
+
+// exclude removes a comma-separated list of addresses, subnets and
+// <start IP>-<end IP> ranges from the pool of an allocator
+func exclude(alloc allocators.Allocator, list string) error {
+	excluder, ok := alloc.(allocators.Excluder)
+	if !ok {
+		return errors.New("the allocator does not support exclusions")
+	}
+	for _, item := range strings.Split(list, ",") {
+		var nets []net.IPNet
+		if ip := net.ParseIP(item); ip.To4() != nil {
+			nets = []net.IPNet{{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}}
+		} else if _, subnet, err := net.ParseCIDR(item); err == nil && subnet.IP.To4() != nil {
+			nets = []net.IPNet{*subnet}
+		} else if bounds := strings.SplitN(item, "-", 2); len(bounds) == 2 {
+			start, end, err := parseRange(bounds[0], bounds[1])
+			if err != nil {
+				return fmt.Errorf("invalid excluded range %v: %w", item, err)
+			}
+			nets = rangeToNets(start, end)
+		} else {
+			return fmt.Errorf("invalid excluded IPv4 address, subnet or range: %v", item)
+		}
+		for _, n := range nets {
+			if err := excluder.Exclude(n); err != nil {
+				return fmt.Errorf("could not exclude %s: %w", item, err)
+			}
+		}
+	}
+	return nil
+}
+
+// clientIdentity returns what identifies a client for the hash strategy: its
+// client identifier (option 61) if it sent one, or else its hardware address
+func clientIdentity(req *dhcpv4.DHCPv4) []byte {
+	if cid := req.Options.Get(dhcpv4.OptionClientIdentifier); len(cid) > 0 {
+		return cid
+	}
+	return req.ClientHWAddr
+}
+
+// hashHint returns the address of a pool made of ranges that a client
+// identity hashes to. The allocator probes the following addresses when it
+// is taken
+func hashHint(ranges [][2]net.IP, identity []byte) net.IPNet {
+	var size uint64
+	for _, r := range ranges {
+		size += uint64(binary.BigEndian.Uint32(r[1].To4())-binary.BigEndian.Uint32(r[0].To4())) + 1
+	}
+	h := fnv.New64a()
+	_, _ = h.Write(identity)
+	idx := h.Sum64() % size
+	for _, r := range ranges {
+		start := binary.BigEndian.Uint32(r[0].To4())
+		if rsize := uint64(binary.BigEndian.Uint32(r[1].To4())-start) + 1; idx >= rsize {
+			idx -= rsize
+			continue
+		}
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, start+uint32(idx))
+		return net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
+	}
+	return net.IPNet{}
+}
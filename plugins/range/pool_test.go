@@ -0,0 +1,165 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/leasetime"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangeToNets(t *testing.T) {
+	var got []string
+	for _, n := range rangeToNets(net.IPv4(10, 0, 0, 3), net.IPv4(10, 0, 0, 17)) {
+		got = append(got, n.String())
+	}
+	assert.Equal(t, []string{"10.0.0.3/32", "10.0.0.4/30", "10.0.0.8/29", "10.0.0.16/31"}, got)
+
+	got = nil
+	for _, n := range rangeToNets(net.IPv4(0, 0, 0, 0), net.IPv4(255, 255, 255, 255)) {
+		got = append(got, n.String())
+	}
+	assert.Equal(t, []string{"0.0.0.0/0"}, got)
+}
+
+func TestNewPool(t *testing.T) {
+	_, err := newPool([][2]net.IP{
+		{net.IPv4(10, 0, 0, 20), net.IPv4(10, 0, 0, 30)},
+		{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 20)},
+	})
+	assert.Error(t, err, "overlapping ranges")
+
+	pool, err := newPool([][2]net.IP{
+		{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 10)},
+		{net.IPv4(10, 0, 1, 1), net.IPv4(10, 0, 1, 2)},
+	})
+	require.NoError(t, err)
+	require.NoError(t, exclude(pool, "10.0.0.2-10.0.0.10,10.0.1.1"))
+	assert.Error(t, exclude(pool, "10.0.0.5-10.0.0.1"))
+	assert.Error(t, exclude(pool, "printer"))
+
+	var got []string
+	for {
+		n, err := pool.Allocate(net.IPNet{})
+		if err != nil {
+			break
+		}
+		got = append(got, n.IP.String())
+	}
+	assert.Equal(t, []string{"10.0.0.1", "10.0.1.2"}, got)
+}
+
+func TestSetupRanges(t *testing.T) {
+	_, err := setupRange("", "10.0.0.1", "10.0.0.10", "1h")
+	assert.Error(t, err)
+	_, err = setupRange("leases.txt", "10.0.0.1", "10.0.0.10", "1h", "range=10.0.1.1")
+	assert.Error(t, err)
+	_, err = setupRange("leases.txt", "10.0.0.1", "10.0.0.10", "1h", "range=10.0.0.5-10.0.0.20")
+	assert.Error(t, err)
+	_, err = setupRange("leases.txt", "10.0.0.1", "10.0.0.10", "1h", "strategy=first")
+	assert.Error(t, err)
+	_, err = setupRange("leases.txt", "10.0.0.1", "10.0.0.10", "1h", "subnet=2001:db8::/64")
+	assert.Error(t, err)
+	_, err = setupRange("leases.txt", "10.0.0.1", "10.0.1.10", "1h", "subnet=10.0.0.0/24")
+	assert.Error(t, err)
+
+	tmpfile, err := ioutil.TempFile("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name())
+	require.NoError(t, tmpfile.Close())
+	// The strategies of the allocators, hash, and lowest for sequential
+	for _, strategy := range []string{"sequential", "random", "hash", "lowest"} {
+		_, err = setupRange(tmpfile.Name(), "10.0.0.1", "10.0.0.10", "1h", "strategy="+strategy)
+		assert.NoError(t, err, strategy)
+	}
+}
+
+func TestHashHint(t *testing.T) {
+	ranges := [][2]net.IP{
+		{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 10)},
+		{net.IPv4(10, 0, 1, 1), net.IPv4(10, 0, 1, 10)},
+	}
+	pool, err := newPool(ranges)
+	require.NoError(t, err)
+	composite := pool.(interface{ Contains(net.IP) bool })
+
+	perRange := make(map[byte]int)
+	for i := 0; i < 100; i++ {
+		id := []byte{0xaa, 0, 0, 0, 0, byte(i)}
+		hint := hashHint(ranges, id)
+		assert.True(t, composite.Contains(hint.IP), "hint %s outside of the pool", hint.IP)
+		assert.Equal(t, hint, hashHint(ranges, id), "hints must be deterministic")
+		perRange[hint.IP.To4()[2]]++
+	}
+	// Clients are spread over both ranges
+	assert.NotZero(t, perRange[0])
+	assert.NotZero(t, perRange[1])
+}
+
+func TestHandler4Sticky(t *testing.T) {
+	ranges := [][2]net.IP{{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 200)}}
+	newServer := func() *PluginState {
+		tmpfile, err := ioutil.TempFile("", "coredhcptest")
+		require.NoError(t, err)
+		t.Cleanup(func() { os.Remove(tmpfile.Name()) })
+		require.NoError(t, tmpfile.Close())
+
+		p := &PluginState{
+			Recordsv4:   make(map[string]*Record),
+			LeasePolicy: leasetime.NewPolicy(time.Hour),
+			hashRanges:  ranges,
+		}
+		p.allocator, err = newPool(ranges)
+		require.NoError(t, err)
+		require.NoError(t, p.registerBackingFile(tmpfile.Name()))
+		t.Cleanup(func() { p.leasefile.Close() })
+		return p
+	}
+	handle := func(p *PluginState, mac net.HardwareAddr) net.IP {
+		req, err := dhcpv4.NewDiscovery(mac)
+		require.NoError(t, err)
+		stub, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		resp, _ := p.Handler4(req, stub)
+		require.NotNil(t, resp)
+		return resp.YourIPAddr
+	}
+
+	// Independent servers give a client the same address, whatever the order
+	// clients come in
+	first, second := newServer(), newServer()
+	var macs []net.HardwareAddr
+	for i := 0; i < 5; i++ {
+		macs = append(macs, net.HardwareAddr{0xaa, 0, 0, 0, 0, byte(i)})
+	}
+	got := make(map[string]string)
+	for _, mac := range macs {
+		got[mac.String()] = handle(first, mac).String()
+	}
+	for i := len(macs) - 1; i >= 0; i-- {
+		assert.Equal(t, got[macs[i].String()], handle(second, macs[i]).String())
+	}
+
+	// When the address of a client is taken, it gets the next one
+	mac := net.HardwareAddr{0xbb, 0, 0, 0, 0, 1}
+	hint := hashHint(ranges, mac)
+	third := newServer()
+	_, err := third.allocator.Allocate(hint)
+	require.NoError(t, err)
+	next := hint.IP.To4()
+	if next[3] == 200 {
+		next = net.IPv4(10, 0, 0, 1).To4()
+	} else {
+		next[3]++
+	}
+	assert.Equal(t, next.String(), handle(third, mac).String())
+}
@@ -11,8 +11,11 @@ import (
 	"io"
 	"net"
 	"os"
+	"sort"
 	"strings"
 	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators"
 )
 
 // loadRecords loads the DHCPv6/v4 Records global map with records stored on
@@ -60,6 +63,60 @@ func loadRecordsFromFile(filename string) (map[string]*Record, error) {
 	return loadRecords(reader)
 }
 
+// restoreLeases marks the addresses of the unexpired leases as allocated.
+// Expired leases are dropped, as well as leases outside of the pool or on an
+// excluded address. When several MAC addresses hold the same address, the
+// lease expiring last is kept
+func (p *PluginState) restoreLeases() error {
+	reserver, ok := p.allocator.(allocators.Reserver)
+	if !ok {
+		return errors.New("the allocator does not support restoring leases")
+	}
+	macs := make([]string, 0, len(p.Recordsv4))
+	for mac := range p.Recordsv4 {
+		macs = append(macs, mac)
+	}
+	sort.Slice(macs, func(i, j int) bool {
+		a, b := p.Recordsv4[macs[i]], p.Recordsv4[macs[j]]
+		if !a.expires.Equal(b.expires) {
+			return a.expires.After(b.expires)
+		}
+		return macs[i] < macs[j]
+	})
+
+	now := time.Now()
+	holders := make(map[string]string)
+	expired := 0
+	for _, mac := range macs {
+		record := p.Recordsv4[mac]
+		if !record.expires.After(now) {
+			expired++
+			delete(p.Recordsv4, mac)
+			continue
+		}
+		_, err := reserver.Reserve(record.IP)
+		switch {
+		case err == nil:
+			holders[record.IP.String()] = mac
+			continue
+		case !errors.Is(err, allocators.ErrAllocated):
+			log.Warningf("Dropping lease of %s for MAC %s: the address is outside of the pool", record.IP, mac)
+		case holders[record.IP.String()] != "":
+			log.Warningf("Dropping lease of %s for MAC %s: the address is also leased to MAC %s", record.IP, mac, holders[record.IP.String()])
+		default:
+			log.Warningf("Dropping lease of %s for MAC %s: the address is excluded", record.IP, mac)
+		}
+		delete(p.Recordsv4, mac)
+	}
+	if expired > 0 {
+		log.Debugf("Dropped %d expired leases", expired)
+	}
+	if inspector, ok := p.allocator.(allocators.Inspector); ok {
+		log.Printf("%d addresses of %d in use", inspector.Used(), inspector.Capacity())
+	}
+	return nil
+}
+
 // saveIPAddress writes out a lease to storage
 func (p *PluginState) saveIPAddress(mac net.HardwareAddr, record *Record) error {
 	_, err := p.leasefile.WriteString(mac.String() + " " + record.IP.String() + " " + record.expires.Format(time.RFC3339) + "\n")
@@ -73,6 +130,22 @@ func (p *PluginState) saveIPAddress(mac net.HardwareAddr, record *Record) error
 	return nil
 }
 
+// dropRecord forgets the lease of a client, returns its address to the pool,
+// and stores it as expired so that it isn't restored at startup
+func (p *PluginState) dropRecord(mac net.HardwareAddr) {
+	record, ok := p.Recordsv4[mac.String()]
+	if !ok {
+		return
+	}
+	delete(p.Recordsv4, mac.String())
+	if err := p.allocator.Free(net.IPNet{IP: record.IP, Mask: net.CIDRMask(32, 32)}); err != nil {
+		log.Warningf("Could not free IP address %s: %v", record.IP, err)
+	}
+	if err := p.saveIPAddress(mac, &Record{IP: record.IP, expires: time.Now()}); err != nil {
+		log.Errorf("Could not persist the end of the lease of MAC %s: %v", mac.String(), err)
+	}
+}
+
 // registerBackingFile installs a file as the backing store for leases
 func (p *PluginState) registerBackingFile(filename string) error {
 	if p.leasefile != nil {
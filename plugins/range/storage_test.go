@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var leasefile string = `02:00:00:00:00:00 10.0.0.0 2000-01-01T00:00:00Z
@@ -85,3 +86,38 @@ func TestWriteRecords(t *testing.T) {
 	}
 	assert.Equal(t, leasefile, string(written), "Data written to the file doesn't match records")
 }
+
+func TestRestoreLeases(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	p := PluginState{
+		Recordsv4: map[string]*Record{
+			"02:00:00:00:00:01": {net.IPv4(10, 0, 0, 1), future},
+			"02:00:00:00:00:02": {net.IPv4(10, 0, 0, 2), expire},
+			// Conflicts with 02:00:00:00:00:04, which expires later
+			"02:00:00:00:00:03": {net.IPv4(10, 0, 0, 3), future},
+			"02:00:00:00:00:04": {net.IPv4(10, 0, 0, 3), future.Add(time.Minute)},
+			"02:00:00:00:00:05": {net.IPv4(10, 0, 1, 1), future},
+			"02:00:00:00:00:06": {net.IPv4(10, 0, 0, 6), future},
+		},
+	}
+	var err error
+	p.allocator, err = newPool([][2]net.IP{{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 10)}})
+	require.NoError(t, err)
+	require.NoError(t, exclude(p.allocator, "10.0.0.6"))
+
+	require.NoError(t, p.restoreLeases())
+	var kept []string
+	for mac := range p.Recordsv4 {
+		kept = append(kept, mac)
+	}
+	assert.ElementsMatch(t, []string{"02:00:00:00:00:01", "02:00:00:00:00:04"}, kept)
+
+	// Restored addresses are not allocated again, and expired ones are free
+	var got []string
+	for i := 0; i < 3; i++ {
+		n, err := p.allocator.Allocate(net.IPNet{})
+		require.NoError(t, err)
+		got = append(got, n.IP.String())
+	}
+	assert.Equal(t, []string{"10.0.0.2", "10.0.0.4", "10.0.0.5"}, got)
+}
@@ -0,0 +1,140 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package relayinfo handles the Relay Agent Information option (82) that
+// relay agents add to the DHCPv4 requests they forward, RFC 3046, and the
+// options DHCPv6 relay agents add to their Relay-forward messages.
+//
+// Other plugins use Get4 to read its sub-options, e.g. to match reservations
+// or classify clients, and LinkAddr4 to select the subnet of a client: the
+// link selection sub-option (RFC 3527) when present, otherwise the address of
+// the relay agent (giaddr). The `range` plugin uses it with its `subnet`
+// argument, and the `file` plugin matches reservations on the circuit,
+// remote and subscriber IDs.
+//
+// For DHCPv6, Get6 returns the options of every relay agent a request went
+// through (interface ID, remote ID, subscriber ID, client link-layer address
+// and relay source port), and LinkAddr6 the link address of the relay agent
+// closest to the client, which the `prefix` plugin uses with its `subnet`
+// argument. The interface and remote IDs are echoed in Relay-reply messages
+// by the server itself.
+//
+// The relay_info plugin itself makes the server follow the relay agents:
+// - when a relay agent sets the server identifier override sub-option (RFC
+//   5107), it is used as the server identifier of the reply, as clients
+//   only see the relay agent
+// - the option is echoed back in replies, as RFC 3046 requires, for the
+//   relay agent to know where to forward them
+//
+// It takes no argument, and must come after server_id:
+//
+// server4:
+//   - plugins:
+//     - server_id: 10.10.10.1
+//     - relay_info:
+//
+package relayinfo
+
+import (
+	"errors"
+	"net"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+var log = logger.GetLogger("plugins/relay_info")
+
+// Plugin wraps plugin registration information
+var Plugin = plugins.Plugin{
+	Name:   "relay_info",
+	Setup4: setup4,
+}
+
+// Info holds the sub-options of the Relay Agent Information option of a
+// request. Absent sub-options are left empty
+type Info struct {
+	// CircuitID identifies the circuit the request came from, sub-option 1
+	CircuitID []byte
+	// RemoteID identifies the remote host end of the circuit, sub-option 2
+	RemoteID []byte
+	// LinkSelection is an address of the subnet of the client, sub-option 5
+	// (RFC 3527)
+	LinkSelection net.IP
+	// SubscriberID identifies the subscriber, sub-option 6 (RFC 3993)
+	SubscriberID string
+	// ServerIDOverride is the address clients see as the server's, sub-option
+	// 11 (RFC 5107)
+	ServerIDOverride net.IP
+}
+
+// Get4 returns the relay agent information of a request, or nil if it has
+// none. Malformed address sub-options are ignored
+func Get4(req *dhcpv4.DHCPv4) *Info {
+	rai := req.RelayAgentInfo()
+	if rai == nil {
+		return nil
+	}
+	info := &Info{
+		CircuitID:    rai.Get(dhcpv4.AgentCircuitIDSubOption),
+		RemoteID:     rai.Get(dhcpv4.AgentRemoteIDSubOption),
+		SubscriberID: string(rai.Get(dhcpv4.SubscriberIDSubOption)),
+	}
+	if ip := rai.Get(dhcpv4.LinkSelectionSubOption); len(ip) == net.IPv4len {
+		info.LinkSelection = net.IP(ip)
+	}
+	if ip := rai.Get(dhcpv4.ServerIdentifierOverrideSubOption); len(ip) == net.IPv4len {
+		info.ServerIDOverride = net.IP(ip)
+	}
+	return info
+}
+
+// relayed returns whether a request was forwarded by a relay agent. The
+// address sub-options are only used in relayed requests, as relay agents
+// setting them must also set giaddr (RFC 3527 section 3, RFC 5107 section 4)
+func relayed(req *dhcpv4.DHCPv4) bool {
+	return req.GatewayIPAddr != nil && !req.GatewayIPAddr.IsUnspecified()
+}
+
+// LinkAddr4 returns an address of the subnet of the client, to select the
+// subnet it gets an address from: the link selection sub-option if the relay
+// agent set it, otherwise the address of the relay agent. It returns nil for
+// requests that weren't relayed, from clients on the link of the server
+func LinkAddr4(req *dhcpv4.DHCPv4) net.IP {
+	if !relayed(req) {
+		return nil
+	}
+	if info := Get4(req); info != nil && info.LinkSelection != nil {
+		return info.LinkSelection
+	}
+	return req.GatewayIPAddr.To4()
+}
+
+// Handler4 handles DHCPv4 packets for the relay_info plugin
+func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	info := Get4(req)
+	if info == nil {
+		return resp, false
+	}
+	log.Debugf("Relay agent information of %s: circuit ID %q, remote ID %q, subscriber ID %q, link selection %v",
+		req.ClientHWAddr, info.CircuitID, info.RemoteID, info.SubscriberID, info.LinkSelection)
+	if info.ServerIDOverride != nil && relayed(req) {
+		resp.UpdateOption(dhcpv4.OptServerIdentifier(info.ServerIDOverride))
+	}
+	// RFC 3046 section 2.2: the whole option is echoed, in case an earlier
+	// plugin replaced or removed it
+	resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionRelayAgentInformation,
+		req.Options.Get(dhcpv4.OptionRelayAgentInformation)))
+	return resp, false
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	if len(args) > 0 {
+		return nil, errors.New("relay_info takes no argument")
+	}
+	log.Printf("loaded plugin for DHCPv4.")
+	return Handler4, nil
+}
@@ -0,0 +1,141 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package relayinfo
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testMAC = net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+
+func relayedDiscovery(t *testing.T, giaddr net.IP, subOptions ...dhcpv4.Option) *dhcpv4.DHCPv4 {
+	modifiers := []dhcpv4.Modifier{dhcpv4.WithGatewayIP(giaddr)}
+	if len(subOptions) > 0 {
+		modifiers = append(modifiers, dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(subOptions...)))
+	}
+	req, err := dhcpv4.NewDiscovery(testMAC, modifiers...)
+	require.NoError(t, err)
+	return req
+}
+
+func TestGet4(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(testMAC)
+	require.NoError(t, err)
+	assert.Nil(t, Get4(req))
+
+	req = relayedDiscovery(t, net.IPv4(10, 0, 1, 1),
+		dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("eth0/1")),
+		dhcpv4.OptGeneric(dhcpv4.AgentRemoteIDSubOption, []byte{1, 2, 3}),
+		dhcpv4.OptGeneric(dhcpv4.LinkSelectionSubOption, []byte{10, 0, 2, 0}),
+		dhcpv4.OptGeneric(dhcpv4.SubscriberIDSubOption, []byte("subscriber1")),
+		dhcpv4.OptGeneric(dhcpv4.ServerIdentifierOverrideSubOption, []byte{10, 0, 2, 1}),
+	)
+	assert.Equal(t, &Info{
+		CircuitID:        []byte("eth0/1"),
+		RemoteID:         []byte{1, 2, 3},
+		LinkSelection:    net.IP{10, 0, 2, 0},
+		SubscriberID:     "subscriber1",
+		ServerIDOverride: net.IP{10, 0, 2, 1},
+	}, Get4(req))
+
+	// Malformed addresses are ignored
+	req = relayedDiscovery(t, net.IPv4(10, 0, 1, 1),
+		dhcpv4.OptGeneric(dhcpv4.LinkSelectionSubOption, []byte{10, 0, 2}),
+	)
+	assert.Nil(t, Get4(req).LinkSelection)
+}
+
+func TestLinkAddr4(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(testMAC)
+	require.NoError(t, err)
+	assert.Nil(t, LinkAddr4(req))
+
+	req = relayedDiscovery(t, net.IPv4(10, 0, 1, 1))
+	assert.Equal(t, "10.0.1.1", LinkAddr4(req).String())
+
+	req = relayedDiscovery(t, net.IPv4(10, 0, 1, 1),
+		dhcpv4.OptGeneric(dhcpv4.LinkSelectionSubOption, []byte{10, 0, 2, 0}))
+	assert.Equal(t, "10.0.2.0", LinkAddr4(req).String())
+
+	// Sub-options of unrelayed requests can't be trusted
+	req = relayedDiscovery(t, net.IPv4zero,
+		dhcpv4.OptGeneric(dhcpv4.LinkSelectionSubOption, []byte{10, 0, 2, 0}))
+	assert.Nil(t, LinkAddr4(req))
+}
+
+func TestHandler4(t *testing.T) {
+	req := relayedDiscovery(t, net.IPv4(10, 0, 1, 1),
+		dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("eth0/1")),
+		dhcpv4.OptGeneric(dhcpv4.ServerIdentifierOverrideSubOption, []byte{10, 0, 1, 1}),
+	)
+	stub, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IPv4(192, 168, 0, 1))))
+	require.NoError(t, err)
+	// An earlier plugin dropped the option
+	delete(stub.Options, dhcpv4.OptionRelayAgentInformation.Code())
+
+	resp, stop := Handler4(req, stub)
+	assert.False(t, stop)
+	assert.Equal(t, "10.0.1.1", resp.ServerIdentifier().String())
+	assert.Equal(t, req.Options.Get(dhcpv4.OptionRelayAgentInformation),
+		resp.Options.Get(dhcpv4.OptionRelayAgentInformation))
+
+	// Without option 82, the reply is left alone
+	req, err = dhcpv4.NewDiscovery(testMAC)
+	require.NoError(t, err)
+	stub, err = dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	resp, _ = Handler4(req, stub)
+	assert.Nil(t, resp.RelayAgentInfo())
+}
+
+func TestSetup4(t *testing.T) {
+	_, err := setup4()
+	assert.NoError(t, err)
+	_, err = setup4("bogus")
+	assert.Error(t, err)
+}
+
+func TestGet6(t *testing.T) {
+	msg, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	assert.Nil(t, Get6(msg))
+	assert.Nil(t, LinkAddr6(msg))
+
+	// A lightweight relay, without an address on the link of the client
+	ldra, err := dhcpv6.EncapsulateRelay(msg, dhcpv6.MessageTypeRelayForward, net.IPv6unspecified, net.ParseIP("fe80::1"))
+	require.NoError(t, err)
+	ldra.AddOption(dhcpv6.OptInterfaceID([]byte("port1")))
+	ldra.AddOption(dhcpv6.OptClientLinkLayerAddress(iana.HWTypeEthernet, testMAC))
+	relay, err := dhcpv6.EncapsulateRelay(ldra, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8:1::1"), net.ParseIP("fe80::2"))
+	require.NoError(t, err)
+	relay.AddOption(&dhcpv6.OptRemoteID{EnterpriseNumber: 1234, RemoteID: []byte("switch1")})
+	relay.AddOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionRelayAgentSubscriberID, OptionData: []byte("customer42")})
+	relay.AddOption(&dhcpv6.OptionGeneric{OptionCode: optionRelaySourcePort, OptionData: []byte{0x12, 0x34}})
+
+	// Through the wire format, as the server sees it
+	req, err := dhcpv6.FromBytes(relay.ToBytes())
+	require.NoError(t, err)
+	hops := Get6(req)
+	require.Len(t, hops, 2)
+	assert.Equal(t, []byte("port1"), hops[0].InterfaceID)
+	assert.Equal(t, iana.HWTypeEthernet, hops[0].ClientHWType)
+	assert.Equal(t, testMAC, hops[0].ClientHWAddr)
+	assert.Equal(t, "fe80::1", hops[0].PeerAddr.String())
+	assert.Equal(t, uint8(1), hops[1].HopCount)
+	assert.Equal(t, []byte("switch1"), hops[1].RemoteID.RemoteID)
+	assert.Equal(t, uint32(1234), hops[1].RemoteID.EnterpriseNumber)
+	assert.Equal(t, "customer42", hops[1].SubscriberID)
+	assert.Equal(t, uint16(0x1234), hops[1].SourcePort)
+
+	assert.Equal(t, "2001:db8:1::1", LinkAddr6(req).String())
+}
@@ -0,0 +1,88 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package relayinfo
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// optionRelaySourcePort is the Relay Source Port option, RFC 8357
+const optionRelaySourcePort dhcpv6.OptionCode = 135
+
+// Hop holds the fields and options a DHCPv6 relay agent added when forwarding
+// a request. Absent options are left empty
+type Hop struct {
+	HopCount uint8
+	// LinkAddr identifies the link of the client. It is unspecified when the
+	// relay agent has no address on it, e.g. a lightweight relay (RFC 6221)
+	LinkAddr net.IP
+	// PeerAddr is the address the request was received from
+	PeerAddr net.IP
+	// InterfaceID identifies the interface the request came from, option 18
+	InterfaceID []byte
+	// RemoteID identifies the remote host end of the circuit, option 37 (RFC
+	// 4649)
+	RemoteID *dhcpv6.OptRemoteID
+	// SubscriberID identifies the subscriber, option 38 (RFC 4580)
+	SubscriberID string
+	// ClientHWType and ClientHWAddr are the link-layer address of the client,
+	// option 79 (RFC 6939)
+	ClientHWType iana.HWType
+	ClientHWAddr net.HardwareAddr
+	// SourcePort is the port the relay agent sends from, when it isn't the
+	// DHCPv6 server port, option 135 (RFC 8357). Replies are sent back to the
+	// port requests come from, so it is informational
+	SourcePort uint16
+}
+
+// Get6 returns the relay agents a request went through, starting from the one
+// closest to the client. It returns nil for requests sent directly by clients
+func Get6(req dhcpv6.DHCPv6) []Hop {
+	var hops []Hop
+	for req != nil && req.IsRelay() {
+		relay, ok := req.(*dhcpv6.RelayMessage)
+		if !ok {
+			break
+		}
+		hop := Hop{
+			HopCount:    relay.HopCount,
+			LinkAddr:    relay.LinkAddr,
+			PeerAddr:    relay.PeerAddr,
+			InterfaceID: relay.Options.InterfaceID(),
+			RemoteID:    relay.Options.RemoteID(),
+		}
+		hop.ClientHWType, hop.ClientHWAddr = relay.Options.ClientLinkLayerAddress()
+		if opt := relay.Options.GetOne(dhcpv6.OptionRelayAgentSubscriberID); opt != nil {
+			hop.SubscriberID = string(opt.ToBytes())
+		}
+		if opt := relay.Options.GetOne(optionRelaySourcePort); opt != nil {
+			if port := opt.ToBytes(); len(port) == 2 {
+				hop.SourcePort = binary.BigEndian.Uint16(port)
+			}
+		}
+		// The outermost relay comes first in the message
+		hops = append([]Hop{hop}, hops...)
+		req = relay.Options.RelayMessage()
+	}
+	return hops
+}
+
+// LinkAddr6 returns an address of the link of the client, to select the
+// subnet it gets addresses and prefixes from: the link address set by the
+// relay agent closest to the client, skipping those leaving it unspecified. It
+// returns nil for requests that weren't relayed, from clients on the link of
+// the server
+func LinkAddr6(req dhcpv6.DHCPv6) net.IP {
+	for _, hop := range Get6(req) {
+		if hop.LinkAddr != nil && !hop.LinkAddr.IsUnspecified() {
+			return hop.LinkAddr
+		}
+	}
+	return nil
+}
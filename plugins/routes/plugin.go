@@ -0,0 +1,152 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package routes implements a plugin that advertises classless static routes
+// (RFC 3442) with DHCPv4 option 121, and optionally with the pre-standard
+// Microsoft option 249.
+//
+// Routes are given as destination,gateway pairs. A gateway of 0.0.0.0 means
+// that the destination is directly reachable on the link.
+// Clients that support option 121 ignore the Router option (option 3), so the
+// default route (0.0.0.0/0) must be part of the routes, unless the network
+// deliberately has no default gateway, which is acknowledged with
+// `no-default=true`.
+// With `ms-classless=true`, the routes are also sent as option 249 to the
+// clients that request it.
+//
+// Example usage:
+//
+// server4:
+//   - plugins:
+//     - routes: 0.0.0.0/0,192.0.2.1 10.0.0.0/8,192.0.2.254 ms-classless=true
+//
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+var log = logger.GetLogger("plugins/routes")
+
+// Plugin wraps plugin registration information
+var Plugin = plugins.Plugin{
+	Name:   "routes",
+	Setup4: setup4,
+}
+
+// optionMSClasslessStaticRoute is the Microsoft Classless Static Route option,
+// which uses the same encoding as option 121
+const optionMSClasslessStaticRoute = dhcpv4.GenericOptionCode(249)
+
+// PluginState is the configuration of one instance of the plugin
+type PluginState struct {
+	routes      dhcpv4.Routes
+	msClassless bool
+}
+
+// parseRoute parses a route given as destination,gateway
+func parseRoute(arg string) (*dhcpv4.Route, error) {
+	parts := strings.Split(arg, ",")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("expected destination,gateway, got `%s`", arg)
+	}
+	ip, dest, err := net.ParseCIDR(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	if ip.To4() == nil {
+		return nil, fmt.Errorf("not an IPv4 destination: %s", parts[0])
+	}
+	if !ip.Equal(dest.IP) {
+		return nil, fmt.Errorf("destination %s has host bits set, did you mean %s?", parts[0], dest)
+	}
+	gw := net.ParseIP(parts[1])
+	if gw.To4() == nil {
+		return nil, fmt.Errorf("expected an IPv4 gateway, got `%s`", parts[1])
+	}
+	dest.IP = dest.IP.To4()
+	return &dhcpv4.Route{Dest: dest, Router: gw.To4()}, nil
+}
+
+func isDefault(r *dhcpv4.Route) bool {
+	ones, _ := r.Dest.Mask.Size()
+	return ones == 0
+}
+
+func setup(args ...string) (*PluginState, error) {
+	var (
+		p         PluginState
+		noDefault bool
+	)
+	for _, arg := range args {
+		var err error
+		switch {
+		case strings.HasPrefix(arg, "ms-classless="):
+			p.msClassless, err = strconv.ParseBool(strings.TrimPrefix(arg, "ms-classless="))
+		case strings.HasPrefix(arg, "no-default="):
+			noDefault, err = strconv.ParseBool(strings.TrimPrefix(arg, "no-default="))
+		default:
+			var r *dhcpv4.Route
+			if r, err = parseRoute(arg); err == nil {
+				p.routes = append(p.routes, r)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid argument `%s`: %v", arg, err)
+		}
+	}
+	if len(p.routes) == 0 {
+		return nil, errors.New("need at least one route")
+	}
+
+	hasDefault := false
+	seen := make(map[string]bool, len(p.routes))
+	for _, r := range p.routes {
+		if seen[r.Dest.String()] {
+			return nil, fmt.Errorf("duplicate route to %s", r.Dest)
+		}
+		seen[r.Dest.String()] = true
+		hasDefault = hasDefault || isDefault(r)
+	}
+	if !hasDefault && !noDefault {
+		return nil, errors.New("no default route (0.0.0.0/0): clients that support classless static routes ignore the router option. " +
+			"Add a default route or set no-default=true")
+	}
+	if hasDefault && noDefault {
+		return nil, errors.New("no-default=true, but a default route is configured")
+	}
+	log.Infof("loaded %d routes", len(p.routes))
+	return &p, nil
+}
+
+// Handler4 handles DHCPv4 packets for the routes plugin
+func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	resp.UpdateOption(dhcpv4.OptClasslessStaticRoute(p.routes...))
+	if p.msClassless {
+		for _, code := range req.ParameterRequestList() {
+			if code.Code() == optionMSClasslessStaticRoute.Code() {
+				resp.UpdateOption(dhcpv4.OptGeneric(optionMSClasslessStaticRoute, p.routes.ToBytes()))
+				break
+			}
+		}
+	}
+	return resp, false
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	p, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
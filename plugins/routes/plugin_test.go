@@ -0,0 +1,77 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package routes
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetup(t *testing.T) {
+	p, err := setup("0.0.0.0/0,192.0.2.1", "10.0.0.0/8,192.0.2.254", "ms-classless=true")
+	require.NoError(t, err)
+	assert.Len(t, p.routes, 2)
+	assert.True(t, p.msClassless)
+
+	p, err = setup("10.0.0.0/8,192.0.2.254", "no-default=true")
+	require.NoError(t, err)
+	assert.Len(t, p.routes, 1)
+}
+
+func TestSetupErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"no-default=true"},
+		// No default route
+		{"10.0.0.0/8,192.0.2.254"},
+		{"0.0.0.0/0,192.0.2.1", "no-default=true"},
+		{"0.0.0.0/0"},
+		{"0.0.0.0/0,192.0.2.1,192.0.2.2"},
+		{"0.0.0.0,192.0.2.1"},
+		{"0.0.0.0/0,2001:db8::1"},
+		{"2001:db8::/32,192.0.2.1", "no-default=true"},
+		{"10.0.0.1/8,192.0.2.1", "no-default=true"},
+		{"10.0.0.0/8,192.0.2.1", "10.0.0.0/8,192.0.2.2", "no-default=true"},
+		{"0.0.0.0/0,192.0.2.1", "ms-classless=perhaps"},
+	} {
+		_, err := setup(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestHandler4(t *testing.T) {
+	p, err := setup("0.0.0.0/0,192.0.2.1", "10.0.0.0/8,192.0.2.254", "198.51.100.0/25,0.0.0.0", "ms-classless=true")
+	require.NoError(t, err)
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55})
+	require.NoError(t, err)
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+
+	resp, stop := p.Handler4(req, stub)
+	assert.False(t, stop)
+	// RFC 3442 section 3 encoding
+	expected := []byte{
+		0, 192, 0, 2, 1,
+		8, 10, 192, 0, 2, 254,
+		25, 198, 51, 100, 0, 0, 0, 0, 0,
+	}
+	assert.Equal(t, expected, resp.Options.Get(dhcpv4.OptionClasslessStaticRoute))
+	routes := resp.ClasslessStaticRoute()
+	require.Len(t, routes, 3)
+	assert.Equal(t, "10.0.0.0/8", routes[1].Dest.String())
+	// Option 249 is only sent when requested
+	assert.False(t, resp.Options.Has(optionMSClasslessStaticRoute))
+
+	req.UpdateOption(dhcpv4.OptParameterRequestList(optionMSClasslessStaticRoute))
+	req, err = dhcpv4.FromBytes(req.ToBytes())
+	require.NoError(t, err)
+	resp, _ = p.Handler4(req, stub)
+	assert.Equal(t, expected, resp.Options.Get(optionMSClasslessStaticRoute))
+}
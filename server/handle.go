@@ -126,6 +126,8 @@ func (l *listener4) HandleMsg4(buf []byte, oob *ipv4.ControlMessage, _peer net.A
 		tmp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeOffer))
 	case dhcpv4.MessageTypeRequest:
 		tmp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeAck))
+	case dhcpv4.MessageTypeRelease, dhcpv4.MessageTypeDecline:
+		// There is no reply, but the plugins release the client's resources
 	default:
 		log.Printf("plugins/server: Unhandled message type: %v", mt)
 		return
@@ -138,6 +140,9 @@ func (l *listener4) HandleMsg4(buf []byte, oob *ipv4.ControlMessage, _peer net.A
 			break
 		}
 	}
+	if mt := req.MessageType(); mt == dhcpv4.MessageTypeRelease || mt == dhcpv4.MessageTypeDecline {
+		return
+	}
 
 	if resp != nil {
 		var peer *net.UDPAddr